@@ -0,0 +1,364 @@
+package starbox
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+
+	"github.com/psanford/memfs"
+)
+
+// ArchiveManifestName is the name of the manifest file in the root of script bundle archives.
+const ArchiveManifestName = "manifest.json"
+
+var errArchiveTooLarge = errors.New("archive exceeds size limit")
+
+const (
+	// tarMagicOffset is the offset of the magic string in the header of tar archives.
+	tarMagicOffset = 257
+	// tarMagic is the magic string of tar archives in the USTAR, PAX and GNU formats.
+	tarMagic = "ustar"
+)
+
+// ArchiveLimits defines the limits for loading script bundle archives to prevent archive bombs.
+// Zero or negative value means the corresponding limit of DefaultArchiveLimits is used.
+type ArchiveLimits struct {
+	MaxArchiveSize int64 // MaxArchiveSize is the maximum size in bytes of the archive itself.
+	MaxFileSize    int64 // MaxFileSize is the maximum uncompressed size in bytes of a single file.
+	MaxTotalSize   int64 // MaxTotalSize is the maximum uncompressed size in bytes of all files.
+	MaxFiles       int   // MaxFiles is the maximum number of files.
+}
+
+// DefaultArchiveLimits is the default limits for loading script bundle archives.
+var DefaultArchiveLimits = ArchiveLimits{
+	MaxArchiveSize: 16 << 20,
+	MaxFileSize:    4 << 20,
+	MaxTotalSize:   32 << 20,
+	MaxFiles:       1024,
+}
+
+// withDefaults returns a copy of the limits with non-positive values replaced by the default ones.
+func (l ArchiveLimits) withDefaults() ArchiveLimits {
+	if l.MaxArchiveSize <= 0 {
+		l.MaxArchiveSize = DefaultArchiveLimits.MaxArchiveSize
+	}
+	if l.MaxFileSize <= 0 {
+		l.MaxFileSize = DefaultArchiveLimits.MaxFileSize
+	}
+	if l.MaxTotalSize <= 0 {
+		l.MaxTotalSize = DefaultArchiveLimits.MaxTotalSize
+	}
+	if l.MaxFiles <= 0 {
+		l.MaxFiles = DefaultArchiveLimits.MaxFiles
+	}
+	return l
+}
+
+// ArchiveManifest describes a script bundle, it's loaded from the manifest file in the root of the archive.
+type ArchiveManifest struct {
+	Name      string        `json:"name,omitempty"`       // Name is the name of the bundle.
+	Entry     string        `json:"entry,omitempty"`      // Entry is the path of the entry script in the bundle.
+	ModuleSet ModuleSetName `json:"module_set,omitempty"` // ModuleSet is the module set required by the bundle.
+	Modules   []string      `json:"modules,omitempty"`    // Modules is the names of builtin modules required by the bundle.
+	fsys      fs.FS
+}
+
+// FS returns the filesystem of the bundle.
+func (m *ArchiveManifest) FS() fs.FS {
+	return m.fsys
+}
+
+// EntryScript returns the content of the entry script declared by the manifest.
+func (m *ArchiveManifest) EntryScript() (string, error) {
+	if m.Entry == "" {
+		return "", errors.New("no entry script in manifest")
+	}
+	if m.fsys == nil {
+		return "", errors.New("no filesystem for manifest")
+	}
+	b, err := fs.ReadFile(m.fsys, m.Entry)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// LoadArchive reads a zip, tar or tar.gz archive of scripts into an in-memory filesystem with the given limits, and parses the optional manifest file.
+// If the archive has no manifest file, an empty manifest is returned along with the filesystem.
+// It returns an error if the archive is not in a recognized format, or it's empty without any files, e.g. zip or tar archives only with directories.
+func LoadArchive(rd io.Reader, limits ArchiveLimits) (*ArchiveManifest, error) {
+	if rd == nil {
+		return nil, errors.New("nil archive reader")
+	}
+	limits = limits.withDefaults()
+
+	// read the archive with size limit
+	br := bufio.NewReader(&sizeLimitReader{rd: rd, left: limits.MaxArchiveSize})
+	head, err := br.Peek(tarMagicOffset + len(tarMagic))
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("read archive: %w", err)
+	}
+	if len(head) == 0 {
+		return nil, errors.New("empty archive")
+	}
+
+	// extract files by format
+	ex := newArchiveExtractor(limits)
+	switch {
+	case bytes.HasPrefix(head, []byte("PK\x03\x04")), bytes.HasPrefix(head, []byte("PK\x05\x06")):
+		err = ex.readZip(br)
+	case bytes.HasPrefix(head, []byte{0x1f, 0x8b}):
+		var gr *gzip.Reader
+		if gr, err = gzip.NewReader(br); err == nil {
+			err = ex.readTar(gr)
+		}
+	case len(head) == tarMagicOffset+len(tarMagic) && (bytes.Equal(head[tarMagicOffset:], []byte(tarMagic)) || isZeroBytes(head)):
+		// tar archives without files only have the zero blocks of the end
+		err = ex.readTar(br)
+	default:
+		return nil, errors.New("unrecognized archive format, expect zip, tar or tar.gz")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if ex.fileCount == 0 {
+		return nil, errors.New("empty archive, no files found")
+	}
+
+	// parse manifest
+	mf := &ArchiveManifest{fsys: ex.fsys}
+	if b, e := fs.ReadFile(ex.fsys, ArchiveManifestName); e == nil {
+		if err = json.Unmarshal(b, mf); err != nil {
+			return nil, fmt.Errorf("invalid manifest: %w", err)
+		}
+	}
+	if mf.Entry != "" {
+		if _, err = fs.Stat(ex.fsys, mf.Entry); err != nil {
+			return nil, fmt.Errorf("invalid manifest entry: %w", err)
+		}
+	}
+	return mf, nil
+}
+
+// isZeroBytes reports whether all the bytes are zero.
+func isZeroBytes(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// sizeLimitReader reads from the underlying reader, and fails if more than the given bytes are read.
+type sizeLimitReader struct {
+	rd   io.Reader
+	left int64
+}
+
+func (r *sizeLimitReader) Read(p []byte) (int, error) {
+	if r.left < 0 {
+		return 0, errArchiveTooLarge
+	}
+	if int64(len(p)) > r.left+1 {
+		p = p[:r.left+1]
+	}
+	n, err := r.rd.Read(p)
+	r.left -= int64(n)
+	if r.left < 0 {
+		return n, errArchiveTooLarge
+	}
+	return n, err
+}
+
+// archiveExtractor extracts files from archives into an in-memory filesystem with limits.
+type archiveExtractor struct {
+	limits    ArchiveLimits
+	fsys      *memfs.FS
+	fileCount int
+	totalSize int64
+}
+
+func newArchiveExtractor(limits ArchiveLimits) *archiveExtractor {
+	return &archiveExtractor{limits: limits, fsys: memfs.New()}
+}
+
+func (e *archiveExtractor) readZip(rd io.Reader) error {
+	data, err := io.ReadAll(rd)
+	if err != nil {
+		return err
+	}
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return err
+	}
+	for _, zf := range zr.File {
+		if zf.FileInfo().IsDir() || !zf.Mode().IsRegular() {
+			continue
+		}
+		fr, err := zf.Open()
+		if err != nil {
+			return err
+		}
+		err = e.addFile(zf.Name, fr)
+		_ = fr.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *archiveExtractor) readTar(rd io.Reader) error {
+	tr := tar.NewReader(rd)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err = e.addFile(hdr.Name, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// addFile writes a file into the filesystem after checking the path and limits.
+func (e *archiveExtractor) addFile(name string, rd io.Reader) error {
+	// check path
+	fp := strings.TrimPrefix(path.Clean(strings.TrimPrefix(name, "./")), "/")
+	if !fs.ValidPath(fp) || fp == "." {
+		return fmt.Errorf("invalid file path in archive: %q", name)
+	}
+
+	// check count
+	e.fileCount++
+	if e.fileCount > e.limits.MaxFiles {
+		return fmt.Errorf("archive exceeds file count limit: %d", e.limits.MaxFiles)
+	}
+
+	// read with size limits
+	data, err := io.ReadAll(io.LimitReader(rd, e.limits.MaxFileSize+1))
+	if err != nil {
+		return err
+	}
+	size := int64(len(data))
+	if size > e.limits.MaxFileSize {
+		return fmt.Errorf("file %q exceeds size limit: %d bytes", fp, e.limits.MaxFileSize)
+	}
+	e.totalSize += size
+	if e.totalSize > e.limits.MaxTotalSize {
+		return fmt.Errorf("archive exceeds total size limit: %d bytes", e.limits.MaxTotalSize)
+	}
+
+	// write file
+	if dir := path.Dir(fp); dir != "." {
+		if err := e.fsys.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	return e.fsys.WriteFile(fp, data, 0644)
+}
+
+// SetArchiveLimits sets the limits for loading script bundle archives by AddModuleArchive() and SetFSFromArchive().
+// It panics if called after execution.
+func (s *Starbox) SetArchiveLimits(limits ArchiveLimits) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.hasExec {
+		log.DPanic("cannot set archive limits after execution")
+	}
+	s.archLimits = limits
+}
+
+// AddModuleArchive loads a zip, tar or tar.gz script bundle and adds it as a virtual filesystem layer for module scripts like AddFS().
+// The module set and builtin modules declared in the manifest of the bundle must be allowed by the box like SetFSFromArchive(), otherwise the bundle is rejected.
+// It panics if called after execution.
+func (s *Starbox) AddModuleArchive(rd io.Reader) (*ArchiveManifest, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.hasExec {
+		log.DPanic("cannot add module archive after execution")
+	}
+	mf, err := LoadArchive(rd, s.archLimits)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkManifest(mf); err != nil {
+		return nil, err
+	}
+	s.modFS = append(s.modFS, mf.fsys)
+	return mf, nil
+}
+
+// SetFSFromArchive loads a zip, tar or tar.gz script bundle and sets it as the virtual filesystem for module scripts like SetFS().
+// The module set and builtin modules declared in the manifest of the bundle must be allowed by the module set, named modules or custom modules of the box set before, otherwise the bundle is rejected, and the entry script can be read via the returned manifest.
+// It panics if called after execution.
+func (s *Starbox) SetFSFromArchive(rd io.Reader) (*ArchiveManifest, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.hasExec {
+		log.DPanic("cannot set filesystem after execution")
+	}
+	mf, err := LoadArchive(rd, s.archLimits)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkManifest(mf); err != nil {
+		return nil, err
+	}
+	s.modFS = []fs.FS{mf.fsys}
+	return mf, nil
+}
+
+// checkManifest checks the modules required by the manifest are allowed by the box, i.e. in the module set, the named modules or the custom module loaders of the box, so bundles can't gain more modules than the host configured.
+func (s *Starbox) checkManifest(mf *ArchiveManifest) error {
+	required, err := getModuleSet(mf.ModuleSet)
+	if err != nil {
+		return fmt.Errorf("invalid manifest: %w", err)
+	}
+	required = append(append([]string(nil), required...), mf.Modules...)
+
+	allowed, err := getModuleSet(s.modSet)
+	if err != nil {
+		return err
+	}
+	names := make(map[string]bool, len(allowed)+len(s.builtMods)+len(s.loadMods))
+	for _, n := range allowed {
+		names[n] = true
+	}
+	for _, n := range s.builtMods {
+		names[n] = true
+	}
+	for n := range s.loadMods {
+		names[n] = true
+	}
+
+	var missing []string
+	for _, n := range uniqueStrings(required) {
+		if !names[n] {
+			missing = append(missing, n)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("modules required by the bundle are not allowed: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
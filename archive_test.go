@@ -0,0 +1,232 @@
+package starbox_test
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/PureMature/starbox"
+	"go.starlark.net/starlark"
+)
+
+var testBundleFiles = map[string]string{
+	"manifest.json":  `{"name": "demo", "entry": "main.star", "modules": ["json"]}`,
+	"main.star":      `load("lib/calc.star", "double"); v = double(21); s = json.encode({"v": v})`,
+	"lib/calc.star":  `def double(x): return x * 2`,
+	"lib/other.star": `z = 1`,
+}
+
+func makeZip(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = w.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func makeTarGz(t *testing.T, files map[string]string, compress bool) []byte {
+	var buf bytes.Buffer
+	var tw *tar.Writer
+	var gw *gzip.Writer
+	if compress {
+		gw = gzip.NewWriter(&buf)
+		tw = tar.NewWriter(gw)
+	} else {
+		tw = tar.NewWriter(&buf)
+	}
+	for name, content := range files {
+		hdr := &tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if gw != nil {
+		if err := gw.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buf.Bytes()
+}
+
+// TestSetFSFromArchive tests the following:
+// 1. Create archives in zip, tar and tar.gz formats.
+// 2. Load the archive as filesystem of a new Starbox instance.
+// 3. Run the entry script declared by the manifest.
+// 4. Check the output -- the required module and module scripts should work.
+func TestSetFSFromArchive(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"zip", makeZip(t, testBundleFiles)},
+		{"tar", makeTarGz(t, testBundleFiles, false)},
+		{"tar.gz", makeTarGz(t, testBundleFiles, true)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := starbox.New("test")
+			b.SetModuleSet(starbox.SafeModuleSet)
+			mf, err := b.SetFSFromArchive(bytes.NewReader(tt.data))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if mf.Name != "demo" || mf.Entry != "main.star" {
+				t.Errorf("unexpected manifest: %+v", mf)
+				return
+			}
+			script, err := mf.EntryScript()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			out, err := b.Run(script)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if es := int64(42); out["v"] != es {
+				t.Errorf("expect %d, got %v", es, out["v"])
+			}
+			if es := `{"v":42}`; out["s"] != es {
+				t.Errorf("expect %q, got %v", es, out["s"])
+			}
+		})
+	}
+}
+
+// TestArchiveManifestModules tests the following:
+// 1. Load bundles requiring modules allowed by the module set, named modules or custom modules of the box, and check they are accepted.
+// 2. Load bundles requiring a larger module set, more modules or an unknown module set, and check they are rejected.
+// 3. Check the module set of the box is not changed by the bundle.
+func TestArchiveManifestModules(t *testing.T) {
+	bundle := func(manifest string) []byte {
+		return makeZip(t, map[string]string{"manifest.json": manifest, "lib/a.star": "a = 1"})
+	}
+	tests := []struct {
+		name     string
+		manifest string
+		wantErr  bool
+	}{
+		{"no modules", `{}`, false},
+		{"same set", `{"module_set": "safe"}`, false},
+		{"smaller set", `{"module_set": "none", "modules": ["json"]}`, false},
+		{"named module", `{"modules": ["http"]}`, false},
+		{"custom module", `{"modules": ["custom"]}`, false},
+		{"larger set", `{"module_set": "full"}`, true},
+		{"more modules", `{"modules": ["json", "file"]}`, true},
+		{"unknown set", `{"module_set": "root"}`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := starbox.New("test")
+			b.SetModuleSet(starbox.SafeModuleSet)
+			b.AddNamedModules("http")
+			b.AddModuleData("custom", starlark.StringDict{"x": starlark.MakeInt(1)})
+			for i, load := range []func() (*starbox.ArchiveManifest, error){
+				func() (*starbox.ArchiveManifest, error) {
+					return b.AddModuleArchive(bytes.NewReader(bundle(tt.manifest)))
+				},
+				func() (*starbox.ArchiveManifest, error) {
+					return b.SetFSFromArchive(bytes.NewReader(bundle(tt.manifest)))
+				},
+			} {
+				if _, err := load(); (err != nil) != tt.wantErr {
+					t.Errorf("#%d: expect error %v, got %v", i, tt.wantErr, err)
+				}
+			}
+			if _, err := b.Run(`load("file", "trim_bom")`); err == nil {
+				t.Error("expect file module not allowed, got nil")
+			}
+		})
+	}
+}
+
+// TestAddModuleArchive tests the following:
+// 1. Create a new Starbox instance with a module script.
+// 2. Add an archive without manifest as a filesystem layer.
+// 3. Run a script that loads modules from the archive and the module script.
+// 4. Check the output.
+func TestAddModuleArchive(t *testing.T) {
+	b := starbox.New("test")
+	b.AddModuleScript("lib/other", "z = 100")
+	mf, err := b.AddModuleArchive(bytes.NewReader(makeZip(t, map[string]string{
+		"lib/calc.star":  `def double(x): return x * 2`,
+		"lib/other.star": `z = 1`,
+	})))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if _, err := mf.EntryScript(); err == nil {
+		t.Error("expect error for no entry, got nil")
+	}
+	out, err := b.Run(`load("lib/calc", "double"); load("lib/other", "z"); v = double(z)`)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if es := int64(200); out["v"] != es {
+		t.Errorf("expect %d, got %v", es, out["v"])
+	}
+}
+
+// TestLoadArchiveLimits tests the archives exceeding limits or with invalid content are rejected.
+func TestLoadArchiveLimits(t *testing.T) {
+	big := strings.Repeat("a", 2048)
+	tests := []struct {
+		name   string
+		data   []byte
+		limits starbox.ArchiveLimits
+	}{
+		{"archive size", makeZip(t, map[string]string{"a.star": big}), starbox.ArchiveLimits{MaxArchiveSize: 100}},
+		{"file size", makeTarGz(t, map[string]string{"a.star": big}, true), starbox.ArchiveLimits{MaxFileSize: 1024}},
+		{"total size", makeTarGz(t, map[string]string{"a.star": big, "b.star": big}, false), starbox.ArchiveLimits{MaxTotalSize: 3000}},
+		{"file count", makeZip(t, map[string]string{"a.star": "a", "b.star": "b", "c.star": "c"}), starbox.ArchiveLimits{MaxFiles: 2}},
+		{"bad path", makeTarGz(t, map[string]string{"../a.star": "a"}, false), starbox.ArchiveLimits{}},
+		{"bad manifest", makeZip(t, map[string]string{"manifest.json": "{"}), starbox.ArchiveLimits{}},
+		{"missing entry", makeZip(t, map[string]string{"manifest.json": `{"entry": "x.star"}`}), starbox.ArchiveLimits{}},
+		{"not archive", []byte("this is not an archive, but some plain text to be parsed as tar"), starbox.ArchiveLimits{}},
+		{"empty", nil, starbox.ArchiveLimits{}},
+		{"short garbage", []byte("PK"), starbox.ArchiveLimits{}},
+		{"zero blocks", make([]byte, 1024), starbox.ArchiveLimits{}},
+		{"empty tar", makeTarGz(t, nil, false), starbox.ArchiveLimits{}},
+		{"empty tar.gz", makeTarGz(t, nil, true), starbox.ArchiveLimits{}},
+		{"empty zip", makeZip(t, nil), starbox.ArchiveLimits{}},
+		{"zip with directory only", makeZip(t, map[string]string{"lib/": ""}), starbox.ArchiveLimits{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := starbox.LoadArchive(bytes.NewReader(tt.data), tt.limits); err == nil {
+				t.Error("expect error, got nil")
+			} else {
+				t.Logf("got error: %v", err)
+			}
+		})
+	}
+
+	if _, err := starbox.LoadArchive(iotest.ErrReader(errors.New("broken")), starbox.ArchiveLimits{}); err == nil || !strings.Contains(err.Error(), "broken") {
+		t.Errorf("expect error of reader, got %v", err)
+	}
+}
@@ -0,0 +1,115 @@
+package starbox
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/1set/starlet"
+)
+
+// ScriptCache is the interface for caching compiled Starlark programs, the keys contain the Starlark compiler version and the hash of script content.
+type ScriptCache = starlet.ByteCache
+
+// NewMemoryCache creates a new in-memory cache for compiled Starlark programs, it can be shared among multiple Starbox instances in the same process.
+func NewMemoryCache() ScriptCache {
+	return starlet.NewMemoryCache()
+}
+
+// DirCache is an on-disk cache for compiled Starlark programs, each program is saved as a file in the directory, so the cache persists across processes.
+type DirCache struct {
+	dir string
+}
+
+const (
+	dirCacheExt     = ".starc"
+	dirCacheTempPat = "tmp-*"
+)
+
+// NewDirCache creates a new on-disk cache for compiled Starlark programs in the given directory, and creates the directory if it doesn't exist.
+func NewDirCache(dir string) (*DirCache, error) {
+	if dir == "" {
+		return nil, errors.New("empty cache directory")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &DirCache{dir: dir}, nil
+}
+
+// Dir returns the directory of the cache.
+func (c *DirCache) Dir() string {
+	return c.dir
+}
+
+// Get returns the compiled program for the given key, and whether the key exists.
+func (c *DirCache) Get(key string) ([]byte, bool) {
+	b, err := os.ReadFile(c.path(key))
+	if err != nil {
+		return nil, false
+	}
+	return b, true
+}
+
+// Set saves the compiled program for the given key. The file is written to a temporary file first and then renamed, so concurrent readers in other processes never see a partial file.
+func (c *DirCache) Set(key string, value []byte) error {
+	tmp, err := os.CreateTemp(c.dir, dirCacheTempPat)
+	if err != nil {
+		return err
+	}
+	tn := tmp.Name()
+	if _, err = tmp.Write(value); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tn)
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		_ = os.Remove(tn)
+		return err
+	}
+	if err = os.Rename(tn, c.path(key)); err != nil {
+		_ = os.Remove(tn)
+		return err
+	}
+	return nil
+}
+
+// Clear removes all the cached programs in the directory, and the temporary files left by interrupted writes of Set().
+func (c *DirCache) Clear() error {
+	var files []string
+	for _, pat := range []string{"*" + dirCacheExt, dirCacheTempPat} {
+		m, err := filepath.Glob(filepath.Join(c.dir, pat))
+		if err != nil {
+			return err
+		}
+		files = append(files, m...)
+	}
+	for _, f := range files {
+		if err := os.Remove(f); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// path returns the file path for the given key, the key is hashed to avoid invalid characters in file names.
+func (c *DirCache) path(key string) string {
+	h := sha256.Sum256([]byte(key))
+	return filepath.Join(c.dir, hex.EncodeToString(h[:])+dirCacheExt)
+}
+
+// SetScriptCache sets the cache for compiled Starlark programs of the main script, it replaces the default in-memory cache of each Starlet machine.
+// Module scripts loaded via load() from the filesystems and module scripts of the box are compiled with the cache as well, and executed once like without the cache.
+// It panics if called after execution.
+func (s *Starbox) SetScriptCache(cache ScriptCache) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.hasExec {
+		log.DPanic("cannot set script cache after execution")
+	}
+	s.scriptCache = cache
+}
@@ -0,0 +1,202 @@
+package starbox_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/PureMature/starbox"
+	"go.starlark.net/starlark"
+)
+
+// countCache wraps a script cache and counts the hits and sets.
+type countCache struct {
+	starbox.ScriptCache
+	hits int32
+	sets int32
+}
+
+func (c *countCache) Get(key string) ([]byte, bool) {
+	v, ok := c.ScriptCache.Get(key)
+	if ok {
+		atomic.AddInt32(&c.hits, 1)
+	}
+	return v, ok
+}
+
+func (c *countCache) Set(key string, value []byte) error {
+	atomic.AddInt32(&c.sets, 1)
+	return c.ScriptCache.Set(key, value)
+}
+
+// TestDirCache tests the following:
+// 1. Create an on-disk cache in a temporary directory.
+// 2. Set and get values, and check missing keys.
+// 3. Open the same directory with a new cache instance, and check the values persist.
+// 4. Clear the cache, and check the cached values and temporary files left by interrupted writes are removed.
+func TestDirCache(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "cache")
+	c1, err := starbox.NewDirCache(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c1.Dir() != dir {
+		t.Errorf("expect dir %s, got %s", dir, c1.Dir())
+	}
+	if _, ok := c1.Get("missing"); ok {
+		t.Error("expect missing key")
+	}
+	if err := c1.Set("1:abc", []byte("compiled")); err != nil {
+		t.Fatal(err)
+	}
+
+	c2, err := starbox.NewDirCache(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := c2.Get("1:abc"); !ok || string(v) != "compiled" {
+		t.Errorf("expect cached value, got %q %v", v, ok)
+	}
+	tmp := filepath.Join(dir, "tmp-123")
+	if err := os.WriteFile(tmp, []byte("partial"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := c2.Clear(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c1.Get("1:abc"); ok {
+		t.Error("expect cleared key")
+	}
+	if entries, err := os.ReadDir(dir); err != nil || len(entries) != 0 {
+		t.Errorf("expect empty directory, got %v %v", entries, err)
+	}
+	if _, err := starbox.NewDirCache(""); err == nil {
+		t.Error("expect error for empty dir, got nil")
+	}
+}
+
+// TestSetScriptCache tests the following:
+// 1. Create a Starbox instance with an on-disk cache, and run a script.
+// 2. Check the compiled program is saved.
+// 3. Create another Starbox instance with a new cache on the same directory as another process, and run the same script.
+// 4. Check the compiled program is loaded from the cache and the output is correct.
+func TestSetScriptCache(t *testing.T) {
+	dir := t.TempDir()
+	script := HereDoc(`
+		def mul(a, b):
+			return a * b
+		c = mul(6, 7)
+	`)
+
+	run := func(name string) (*countCache, int64) {
+		dc, err := starbox.NewDirCache(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cc := &countCache{ScriptCache: dc}
+		b := starbox.New(name)
+		b.SetScriptCache(cc)
+		out, err := b.Run(script)
+		if err != nil {
+			t.Fatal(err)
+		}
+		v, _ := out["c"].(int64)
+		return cc, v
+	}
+
+	c1, v1 := run("first")
+	if v1 != 42 || c1.hits != 0 || c1.sets != 1 {
+		t.Errorf("first run: unexpected result %d, hits %d, sets %d", v1, c1.hits, c1.sets)
+	}
+	c2, v2 := run("second")
+	if v2 != 42 || c2.hits != 1 || c2.sets != 0 {
+		t.Errorf("second run: unexpected result %d, hits %d, sets %d", v2, c2.hits, c2.sets)
+	}
+
+	// shared in-memory cache
+	mc := &countCache{ScriptCache: starbox.NewMemoryCache()}
+	for i := 0; i < 3; i++ {
+		b := starbox.New("memory")
+		b.SetScriptCache(mc)
+		if _, err := b.Run(script); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if mc.hits != 2 || mc.sets != 1 {
+		t.Errorf("memory cache: unexpected hits %d, sets %d", mc.hits, mc.sets)
+	}
+}
+
+// TestScriptCacheModules tests the following:
+// 1. Create Starbox instances sharing a cache, with module scripts loading each other and a builtin module.
+// 2. Run a script loading the modules, and check the compiled module scripts are saved.
+// 3. Run with another instance, and check the modules are loaded from the cache.
+// 4. Change a module script, and check it's compiled again.
+// 5. Check modules are executed once for multiple runs of a box, with or without the cache.
+// 6. Check cycles in the load graph are reported.
+func TestScriptCacheModules(t *testing.T) {
+	mc := &countCache{ScriptCache: starbox.NewMemoryCache()}
+	script := HereDoc(`
+		load("lib/calc.star", "double")
+		load("shared", "base")
+		load("math", "pow")
+		x = double(base) + int(pow(2, 2))
+	`)
+	run := func(shared string) int64 {
+		b := starbox.New("modules")
+		b.SetScriptCache(mc)
+		b.AddNamedModules("math")
+		b.AddModuleScript("shared", shared)
+		b.AddModuleScript("lib/calc", "load('shared', 'base')\ndef double(n):\n\treturn n * 2 + base - base\n")
+		out, err := b.Run(script)
+		if err != nil {
+			t.Fatal(err)
+		}
+		v, _ := out["x"].(int64)
+		return v
+	}
+
+	// the main script and two module scripts
+	if v := run("base = 10"); v != 24 || mc.hits != 0 || mc.sets != 3 {
+		t.Errorf("first run: unexpected result %d, hits %d, sets %d", v, mc.hits, mc.sets)
+	}
+	if v := run("base = 10"); v != 24 || mc.hits != 3 || mc.sets != 3 {
+		t.Errorf("second run: unexpected result %d, hits %d, sets %d", v, mc.hits, mc.sets)
+	}
+	if v := run("base = 20"); v != 44 || mc.hits != 5 || mc.sets != 4 {
+		t.Errorf("changed module: unexpected result %d, hits %d, sets %d", v, mc.hits, mc.sets)
+	}
+
+	// modules are executed once for the box like without the cache
+	for _, cache := range []starbox.ScriptCache{mc, nil} {
+		b := starbox.New("rerun")
+		if cache != nil {
+			b.SetScriptCache(cache)
+		}
+		var execs int
+		b.AddBuiltin("tick", func(_ *starlark.Thread, _ *starlark.Builtin, _ starlark.Tuple, _ []starlark.Tuple) (starlark.Value, error) {
+			execs++
+			return starlark.None, nil
+		})
+		b.AddModuleScript("noisy", "tick()\nn = 1\n")
+		for i := 0; i < 2; i++ {
+			if out, err := b.Run("load('noisy', 'n')\nv = n"); err != nil || out["v"] != int64(1) {
+				t.Fatalf("cache %v: unexpected result %v %v", cache != nil, out, err)
+			}
+		}
+		if execs != 1 {
+			t.Errorf("cache %v: expect module executed once, got %d", cache != nil, execs)
+		}
+	}
+
+	// cycles
+	b := starbox.New("cycle")
+	b.SetScriptCache(mc)
+	b.AddModuleScript("a", "load('b', 'y')\nx = 1\n")
+	b.AddModuleScript("b", "load('a', 'x')\ny = 2\n")
+	if _, err := b.Run(`load("a", "x")`); err == nil || !strings.Contains(err.Error(), "cycle in load graph") {
+		t.Errorf("expect cycle error, got %v", err)
+	}
+}
@@ -0,0 +1,554 @@
+// Command starbox runs Starlark scripts, starts a REPL, or checks the syntax of scripts with Starbox.
+//
+// Usage:
+//
+//	starbox run [flags] script.star
+//	starbox repl [flags]
+//	starbox check script.star...
+//	starbox dap [flags]
+//	starbox test [flags] path...
+//	starbox golden [flags] dir...
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/1set/starlet"
+	"github.com/PureMature/starbox"
+	"go.starlark.net/starlark"
+)
+
+const usage = `Usage: starbox <command> [flags] [arguments]
+
+Commands:
+  run      run a script file
+  repl     start an interactive REPL
+  check    check the syntax of script files
+  dap      serve the Debug Adapter Protocol for editors
+  test     run the test functions of test scripts
+  golden   compare the outputs of scripts with golden files
+  help     show this help
+
+Run "starbox <command> -h" for the flags of a command.
+`
+
+// exit codes of the command
+const (
+	exitOK    = 0
+	exitError = 1
+	exitUsage = 2
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run executes the command line arguments, and returns the exit code.
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return exitUsage
+	}
+	cmd, args := args[0], args[1:]
+	switch cmd {
+	case "run":
+		return runScript(args, stdin, stdout, stderr)
+	case "repl":
+		return runREPL(args, stdin, stdout, stderr)
+	case "check":
+		return runCheck(args, stdout, stderr)
+	case "dap":
+		return runDAP(args, stdin, stdout, stderr)
+	case "test":
+		return runTest(args, stdout, stderr)
+	case "golden":
+		return runGolden(args, stdout, stderr)
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(stdout, usage)
+		return exitOK
+	default:
+		fmt.Fprintf(stderr, "unknown command: %s\n\n%s", cmd, usage)
+		return exitUsage
+	}
+}
+
+// boxFlags are the common flags for creating a Starbox instance.
+type boxFlags struct {
+	name    string
+	modules string
+	globals keyValueFlag
+}
+
+func (f *boxFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.name, "name", "", "name of the box, defaults to the script file name")
+	fs.StringVar(&f.modules, "modules", string(starbox.FullModuleSet), `module set name (none, safe, network, full), or comma-separated module names`)
+	fs.Var(&f.globals, "set", "set a global variable as key=value, the value is parsed as JSON if valid or used as a string, can be repeated")
+}
+
+// newBox creates a Starbox instance with the flags, and prints to the writer.
+func (f *boxFlags) newBox(defaultName string, stdout io.Writer) *starbox.Starbox {
+	name := f.name
+	if name == "" {
+		name = defaultName
+	}
+	box := starbox.New(name)
+	box.SetPrintFunc(func(thread *starlark.Thread, msg string) {
+		fmt.Fprintln(stdout, msg)
+	})
+
+	switch modSet := starbox.ModuleSetName(strings.TrimSpace(f.modules)); modSet {
+	case starbox.EmptyModuleSet, starbox.SafeModuleSet, starbox.NetworkModuleSet, starbox.FullModuleSet:
+		box.SetModuleSet(modSet)
+	default:
+		box.SetModuleSet(starbox.EmptyModuleSet)
+		var names []string
+		for _, n := range strings.Split(f.modules, ",") {
+			if n = strings.TrimSpace(n); n != "" {
+				names = append(names, n)
+			}
+		}
+		box.AddNamedModules(names...)
+	}
+
+	if len(f.globals) > 0 {
+		box.AddKeyValues(f.globals.values())
+	}
+	return box
+}
+
+// keyValueFlag is a repeatable flag of key=value pairs.
+type keyValueFlag []string
+
+func (f *keyValueFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *keyValueFlag) Set(s string) error {
+	if k, _, ok := strings.Cut(s, "="); !ok || strings.TrimSpace(k) == "" {
+		return fmt.Errorf("invalid key=value: %q", s)
+	}
+	*f = append(*f, s)
+	return nil
+}
+
+// values returns the pairs as a map, and the values are parsed as JSON if valid.
+func (f keyValueFlag) values() starlet.StringAnyMap {
+	m := make(starlet.StringAnyMap, len(f))
+	for _, s := range f {
+		k, v, _ := strings.Cut(s, "=")
+		var val interface{}
+		if err := json.Unmarshal([]byte(v), &val); err != nil {
+			val = v
+		}
+		m[strings.TrimSpace(k)] = val
+	}
+	return m
+}
+
+// listFlag is a repeatable flag of strings.
+type listFlag []string
+
+func (f *listFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *listFlag) Set(s string) error {
+	*f = append(*f, s)
+	return nil
+}
+
+// runScript implements the run command.
+func runScript(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	var (
+		bf      boxFlags
+		timeout time.Duration
+		asJSON  bool
+		breaks  listFlag
+		profile string
+	)
+	fs := flag.NewFlagSet("run", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	bf.register(fs)
+	fs.DurationVar(&timeout, "timeout", 0, "timeout of the execution, e.g. 10s, zero means no timeout")
+	fs.BoolVar(&asJSON, "json", false, "print the global variables of the script as JSON after execution")
+	fs.StringVar(&profile, "cpuprofile", "", "write the profile of Starlark functions during the execution to the file for go tool pprof")
+	fs.Var(&breaks, "break", `set a breakpoint as "line" of the script or "module:line" and debug in a REPL on stdin, can be repeated`)
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: starbox run [flags] script.star")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return exitUsage
+	}
+
+	path := fs.Arg(0)
+	script, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitError
+	}
+	box := bf.newBox(strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)), stdout)
+	// modules loaded by the script are relative to its directory
+	box.SetFS(os.DirFS(filepath.Dir(path)))
+	if len(breaks) > 0 {
+		d := starbox.NewDebugger(starbox.DebugREPL(stdin, stdout))
+		for _, s := range breaks {
+			b, err := starbox.ParseBreakpoint(s)
+			if err != nil {
+				fmt.Fprintln(stderr, err)
+				return exitUsage
+			}
+			d.SetBreakpoint(b.File, b.Line)
+		}
+		box.SetDebugger(d)
+	}
+	if profile != "" {
+		f, err := os.Create(profile)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return exitError
+		}
+		defer f.Close()
+		box.SetProfile(f)
+	}
+
+	var out starlet.StringAnyMap
+	if timeout > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		out, err = box.RunContext(ctx, string(script))
+		if err != nil && ctx.Err() != nil {
+			err = fmt.Errorf("%w: %v", ctx.Err(), err)
+		}
+	} else {
+		out, err = box.Run(string(script))
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitError
+	}
+
+	if asJSON {
+		if err := writeJSON(stdout, out); err != nil {
+			fmt.Fprintln(stderr, err)
+			return exitError
+		}
+	}
+	return exitOK
+}
+
+// writeJSON writes the values which can be encoded as JSON sorted by keys, and skips the others like functions.
+func writeJSON(w io.Writer, out starlet.StringAnyMap) error {
+	res := make(map[string]json.RawMessage, len(out))
+	for k, v := range out {
+		if b, err := json.Marshal(v); err == nil {
+			res[k] = b
+		}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(res)
+}
+
+// runREPL implements the repl command.
+func runREPL(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	var (
+		bf         boxFlags
+		historyDir string
+		transcript string
+	)
+	fs := flag.NewFlagSet("repl", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	bf.register(fs)
+	fs.StringVar(&historyDir, "history-dir", starbox.DefaultREPLHistoryDir(), "directory of the input history files named after the box, empty to disable")
+	fs.StringVar(&transcript, "transcript", "", "append the inputs and outputs with timestamps to the file, which can be replayed by the run command")
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: starbox repl [flags]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return exitUsage
+	}
+
+	box := bf.newBox("repl", stdout)
+	if wd, err := os.Getwd(); err == nil {
+		box.SetFS(os.DirFS(wd))
+	}
+	opts := []starbox.REPLOption{starbox.REPLHistoryDir(historyDir)}
+	if transcript != "" {
+		f, err := os.OpenFile(transcript, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return exitError
+		}
+		defer f.Close()
+		opts = append(opts, starbox.REPLTranscript(f))
+	}
+	if err := box.REPL(opts...); err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitError
+	}
+	return exitOK
+}
+
+// runDAP implements the dap command, it serves debug sessions over stdio, or over TCP connections one at a time with --listen.
+func runDAP(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	var (
+		bf     boxFlags
+		listen string
+	)
+	fs := flag.NewFlagSet("dap", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	bf.register(fs)
+	fs.StringVar(&listen, "listen", "", "serve debug sessions on the TCP address, e.g. 127.0.0.1:4711, instead of stdio")
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: starbox dap [flags]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return exitUsage
+	}
+
+	// the script prints to the debug console of the editor instead of stdout, which may be the protocol stream
+	launch := func(cfg map[string]interface{}) (*starbox.DAPProgram, error) {
+		path, _ := cfg["program"].(string)
+		if path == "" {
+			return nil, errors.New(`missing "program" in launch configuration`)
+		}
+		box := bf.newBox(strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)), io.Discard)
+		return starbox.NewDAPProgram(box, path)
+	}
+	if listen == "" {
+		if err := starbox.ServeDAP(stdin, stdout, launch); err != nil {
+			fmt.Fprintln(stderr, err)
+			return exitError
+		}
+		return exitOK
+	}
+
+	l, err := net.Listen("tcp", listen)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitError
+	}
+	defer l.Close()
+	fmt.Fprintf(stderr, "listening on %s\n", l.Addr())
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return exitError
+		}
+		if err := starbox.ServeDAP(conn, conn, launch); err != nil {
+			fmt.Fprintln(stderr, err)
+		}
+		conn.Close()
+	}
+}
+
+// runTest implements the test command, the test functions of each script run in fresh boxes created with the flags, and modules are loaded relative to the directory of the script.
+func runTest(args []string, stdout, stderr io.Writer) int {
+	var (
+		bf       boxFlags
+		pattern  string
+		junit    string
+		coverOut string
+		coverHTM string
+	)
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	bf.register(fs)
+	fs.StringVar(&pattern, "run", "", "only run the test functions matching the regular expression")
+	fs.StringVar(&junit, "junit", "", "write the report in the JUnit XML format to the file")
+	fs.StringVar(&coverOut, "coverprofile", "", "write the statement coverage profile of scripts to the file")
+	fs.StringVar(&coverHTM, "coverhtml", "", "write the statement coverage of scripts as an HTML report to the file")
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: starbox test [flags] path...")
+		fmt.Fprintln(stderr, "Directories are searched for files named *_test.star, which define test_* functions.")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return exitUsage
+	}
+
+	var opts []starbox.ScriptTestOption
+	if pattern != "" {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return exitUsage
+		}
+		opts = append(opts, starbox.ScriptTestFilter(re))
+	}
+	files, err := starbox.LoadScriptTestFiles(fs.Args()...)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitError
+	}
+
+	var cov *starbox.Coverage
+	if coverOut != "" || coverHTM != "" {
+		cov = starbox.NewCoverage()
+	}
+	report := &starbox.ScriptTestReport{}
+	for _, file := range files {
+		tpl := bf.newBox(strings.TrimSuffix(filepath.Base(file.Name), filepath.Ext(file.Name)), io.Discard)
+		tpl.SetFS(os.DirFS(filepath.Dir(file.Name)))
+		if cov != nil {
+			tpl.SetCoverage(cov)
+		}
+		r := starbox.RunScriptTests(tpl, []starbox.ScriptTestFile{file}, opts...)
+		report.Results = append(report.Results, r.Results...)
+		report.Duration += r.Duration
+	}
+	if err := report.WriteGoTest(stdout); err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitError
+	}
+	if cov != nil {
+		fmt.Fprintf(stdout, "coverage: %.1f%% of statements\n", cov.Percent())
+	}
+	for _, out := range []struct {
+		path  string
+		write func(io.Writer) error
+	}{
+		{junit, report.WriteJUnit},
+		{coverOut, cov.WriteProfile},
+		{coverHTM, cov.WriteHTML},
+	} {
+		if out.path == "" {
+			continue
+		}
+		if err := writeFile(out.path, out.write); err != nil {
+			fmt.Fprintln(stderr, err)
+			return exitError
+		}
+	}
+	if !report.Passed() {
+		return exitError
+	}
+	return exitOK
+}
+
+// runGolden implements the golden command, the scripts in each directory run with their fixture inputs in fresh boxes created with the flags.
+func runGolden(args []string, stdout, stderr io.Writer) int {
+	var (
+		bf     boxFlags
+		update bool
+		junit  string
+	)
+	fs := flag.NewFlagSet("golden", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	bf.register(fs)
+	fs.BoolVar(&update, "update", false, "rewrite the golden files with the actual outputs and prints")
+	fs.StringVar(&junit, "junit", "", "write the report in the JUnit XML format to the file")
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: starbox golden [flags] dir...")
+		fmt.Fprintln(stderr, "Scripts named name.star run with the globals in name[.case].input.json, and are compared with name[.case].golden.json.")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return exitUsage
+	}
+
+	report := &starbox.ScriptTestReport{}
+	for _, dir := range fs.Args() {
+		r, err := starbox.RunGoldenTests(bf.newBox("golden", io.Discard), dir, starbox.GoldenUpdate(update))
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return exitError
+		}
+		report.Results = append(report.Results, r.Results...)
+		report.Duration += r.Duration
+	}
+	if err := report.WriteGoTest(stdout); err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitError
+	}
+	if junit != "" {
+		if err := writeFile(junit, report.WriteJUnit); err != nil {
+			fmt.Fprintln(stderr, err)
+			return exitError
+		}
+	}
+	if !report.Passed() {
+		return exitError
+	}
+	return exitOK
+}
+
+// writeFile creates the file and writes it with the function.
+func writeFile(path string, write func(io.Writer) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	err = write(f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// runCheck implements the check command.
+func runCheck(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("check", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: starbox check script.star...")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return exitUsage
+	}
+
+	code := exitOK
+	for _, path := range fs.Args() {
+		src, err := os.ReadFile(path)
+		if err == nil {
+			err = starbox.CheckSyntax(path, src)
+		}
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			code = exitError
+			continue
+		}
+		fmt.Fprintf(stdout, "%s: ok\n", path)
+	}
+	return code
+}
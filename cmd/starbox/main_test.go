@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestRun tests the following:
+// 1. Run the commands with various arguments.
+// 2. Check the exit codes, and the outputs to stdout and stderr.
+func TestRun(t *testing.T) {
+	tests := []struct {
+		name   string
+		args   []string
+		code   int
+		stdout string
+		stderr string
+	}{
+		{"no command", nil, exitUsage, "", "Usage: starbox"},
+		{"unknown command", []string{"bad"}, exitUsage, "", "unknown command: bad"},
+		{"help", []string{"help"}, exitOK, "Commands:", ""},
+		{"run no file", []string{"run"}, exitUsage, "", "Usage: starbox run"},
+		{"run missing file", []string{"run", "testdata/missing.star"}, exitError, "", "no such file"},
+		{"run", []string{"run", "--set", "name=Aloha", "--set", "count=21", "testdata/main.star"}, exitOK, "hello Aloha\n", ""},
+		{"run json", []string{"run", "-json", "-set", "name=x", "-set", "count=1", "testdata/main.star"}, exitOK, `"items": [`, ""},
+		{"run bad set", []string{"run", "--set", "novalue", "testdata/main.star"}, exitUsage, "", "invalid key=value"},
+		{"run error", []string{"run", "testdata/main.star"}, exitError, "", "undefined: name"},
+		{"run modules", []string{"run", "--modules", "json, math", "--set", "name=x", "--set", "count=1", "testdata/main.star"}, exitOK, "hello x", ""},
+		{"run bad break", []string{"run", "--break", "lib:x", "testdata/main.star"}, exitUsage, "", "invalid line of breakpoint"},
+		{"run timeout", []string{"run", "--timeout", "50ms", "testdata/loop.star"}, exitError, "", "deadline exceeded"},
+		{"check", []string{"check", "testdata/main.star", "testdata/lib.star"}, exitOK, "testdata/lib.star: ok", ""},
+		{"check broken", []string{"check", "testdata/main.star", "testdata/broken.star"}, exitError, "testdata/main.star: ok", "testdata/broken.star:1:13"},
+		{"dap args", []string{"dap", "extra"}, exitUsage, "", "Usage: starbox dap"},
+		{"test", []string{"test", "--run", "double", "testdata/lib_test.star"}, exitOK, "--- PASS: testdata/lib_test.star/test_double", ""},
+		{"test failed", []string{"test", "testdata"}, exitError, "testdata/lib_test.star:7: assert.eq: 2 != 3", ""},
+		{"test bad run", []string{"test", "--run", "(", "testdata"}, exitUsage, "", "missing closing )"},
+		{"test no path", []string{"test"}, exitUsage, "", "Usage: starbox test"},
+		{"golden", []string{"golden", "testdata/golden"}, exitOK, "--- PASS: testdata/golden/report.star/report.golden.json", ""},
+		{"golden missing dir", []string{"golden", "testdata/missing"}, exitError, "", "no such file"},
+		{"golden no dir", []string{"golden"}, exitUsage, "", "Usage: starbox golden"},
+		{"check no file", []string{"check"}, exitUsage, "", "Usage: starbox check"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			code := run(tt.args, strings.NewReader(""), &stdout, &stderr)
+			if code != tt.code {
+				t.Errorf("expect exit code %d, got %d, stderr: %s", tt.code, code, stderr.String())
+			}
+			if !strings.Contains(stdout.String(), tt.stdout) {
+				t.Errorf("expect stdout contains %q, got %q", tt.stdout, stdout.String())
+			}
+			if !strings.Contains(stderr.String(), tt.stderr) {
+				t.Errorf("expect stderr contains %q, got %q", tt.stderr, stderr.String())
+			}
+		})
+	}
+}
+
+// TestRun_JSON tests the JSON output of the run command.
+func TestRun_JSON(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"run", "-json", "-set", `name="x"`, "-set", "count=21", "testdata/main.star"}, nil, &stdout, &stderr); code != exitOK {
+		t.Fatalf("unexpected exit code %d: %s", code, stderr.String())
+	}
+	expected := "hello x\n{\n  \"items\": [\n    1,\n    2,\n    3\n  ],\n  \"result\": 42\n}\n"
+	if got := stdout.String(); got != expected {
+		t.Errorf("unexpected output: %s", got)
+	}
+}
+
+// TestRun_Break tests the run command pauses at breakpoints and reads debug commands from stdin.
+func TestRun_Break(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	stdin := strings.NewReader("p name\nc\np x\nc\n")
+	if code := run([]string{"run", "--break", "lib:2", "--break", "4", "--set", "name=x", "--set", "count=21", "testdata/main.star"}, stdin, &stdout, &stderr); code != exitOK {
+		t.Fatalf("unexpected exit code %d: %s", code, stderr.String())
+	}
+	expected := "hello x\nPaused at box.star:4 (breakpoint)\n    4  result = double(count)\n(debug) \"x\"\n(debug) " +
+		"Paused at lib.star:2 (breakpoint)\n    2      return x * 2\n(debug) 21.0\n(debug) "
+	if got := stdout.String(); got != expected {
+		t.Errorf("unexpected output: %q", got)
+	}
+}
+
+// TestDAP tests the dap command serves a debug session over stdio, and launches the script with the box flags.
+func TestDAP(t *testing.T) {
+	var in strings.Builder
+	for i, req := range []string{
+		`{"command":"initialize","arguments":{"adapterID":"starbox"}}`,
+		`{"command":"launch","arguments":{"program":"testdata/main.star"}}`,
+		`{"command":"configurationDone"}`,
+		`{"command":"disconnect"}`,
+	} {
+		msg := fmt.Sprintf(`{"seq":%d,"type":"request",%s`, i+1, req[1:])
+		fmt.Fprintf(&in, "Content-Length: %d\r\n\r\n%s", len(msg), msg)
+	}
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"dap", "--set", "name=x", "--set", "count=1"}, strings.NewReader(in.String()), &stdout, &stderr); code != exitOK {
+		t.Fatalf("unexpected exit code %d: %s", code, stderr.String())
+	}
+	got := stdout.String()
+	for _, s := range []string{
+		`"request_seq":1,"success":true,"command":"initialize"`,
+		`"event":"initialized"`,
+		`"request_seq":2,"success":true,"command":"launch"`,
+		`"request_seq":3,"success":true,"command":"configurationDone"`,
+		`"event":"terminated"`,
+		`"request_seq":4,"success":true,"command":"disconnect"`,
+	} {
+		if !strings.Contains(got, s) {
+			t.Errorf("expect output to contain %s, got: %s", s, got)
+		}
+	}
+}
+
+// TestTest_JUnit tests the test command writes the JUnit XML report to the file.
+func TestTest_JUnit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "report.xml")
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"test", "--junit", path, "testdata/lib_test.star"}, strings.NewReader(""), &stdout, &stderr); code != exitError {
+		t.Fatalf("unexpected exit code %d: %s", code, stderr.String())
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := string(data); !strings.Contains(s, `<testsuite name="testdata/lib_test.star" tests="2" failures="1"`) {
+		t.Errorf("unexpected report: %s", s)
+	}
+}
+
+// TestTest_Cover tests the test command writes the coverage profile and the HTML report of the test scripts and their modules.
+func TestTest_Cover(t *testing.T) {
+	dir := t.TempDir()
+	profile, html := filepath.Join(dir, "cover.out"), filepath.Join(dir, "cover.html")
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"test", "--coverprofile", profile, "--coverhtml", html, "--run", "double", "testdata/lib_test.star"}, strings.NewReader(""), &stdout, &stderr); code != exitOK {
+		t.Fatalf("unexpected exit code %d: %s", code, stderr.String())
+	}
+	if !strings.Contains(stdout.String(), "coverage: 66.7% of statements\n") {
+		t.Errorf("unexpected output: %s", stdout.String())
+	}
+	data, err := os.ReadFile(profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := string(data); !strings.HasPrefix(s, "mode: count\n") || !strings.Contains(s, "lib.star:") {
+		t.Errorf("unexpected profile: %s", s)
+	}
+	if data, err = os.ReadFile(html); err != nil {
+		t.Fatal(err)
+	}
+	if s := string(data); !strings.Contains(s, "Coverage: 66.7% of statements") {
+		t.Errorf("unexpected HTML report: %s", s)
+	}
+}
+
+// TestRun_CPUProfile tests the run command writes the profile of the script even if it's cancelled by the timeout.
+func TestRun_CPUProfile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cpu.out")
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"run", "--timeout", "50ms", "--cpuprofile", path, "testdata/loop.star"}, strings.NewReader(""), &stdout, &stderr); code != exitError {
+		t.Fatalf("unexpected exit code %d: %s", code, stderr.String())
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) < 2 || data[0] != 0x1f || data[1] != 0x8b {
+		t.Errorf("expect a gzipped profile, got %d bytes", len(data))
+	}
+}
@@ -0,0 +1,167 @@
+package starbox
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+
+	"go.starlark.net/starlark"
+)
+
+// starlarkKeywords are the keywords of Starlark for completion.
+var starlarkKeywords = []string{
+	"and", "break", "continue", "def", "elif", "else", "for", "if", "in", "lambda", "load", "not", "or", "pass", "return", "while",
+}
+
+// loadPrefixRe matches the input ending with an incomplete module name in load().
+var loadPrefixRe = regexp.MustCompile(`load\(\s*["']([^"']*)$`)
+
+// Completions returns the candidates to complete the last word of the input, e.g. "ma" for global names, "math.fl" for members of modules and structs, or `load("js` for module names.
+// It's backed by the globals, the loaded modules, and the results of previous executions of the box.
+func (s *Starbox) Completions(input string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	globals, modules := s.completionSource()
+	cands, _ := completeInput(input, globals, modules)
+	return cands
+}
+
+// completionSource returns the globals and names of modules to load for completion, it must be called with the lock held.
+func (s *Starbox) completionSource() (starlark.StringDict, []string) {
+	preMods, lazyMods, _ := s.extractModLoads()
+	modules := lazyMods.Keys()
+	for name := range s.scriptMods {
+		modules = append(modules, name)
+	}
+	sort.Strings(modules)
+
+	// after execution, the globals of the machine include everything
+	if s.hasExec {
+		if g := s.mac.GetStarlarkPredeclared(); g != nil {
+			return g, modules
+		}
+	}
+
+	// before execution, collect from the settings
+	globals := make(starlark.StringDict, len(s.globals))
+	_ = preMods.LoadAll(globals)
+	for name, v := range s.globals {
+		if sv, ok := v.(starlark.Value); ok {
+			globals[name] = sv
+		} else {
+			globals[name] = starlark.None
+		}
+	}
+	return globals, modules
+}
+
+// completeInput returns the candidates to complete the last word of the input, and the partial word to complete.
+func completeInput(input string, globals starlark.StringDict, modules []string) ([]string, string) {
+	// module names in load()
+	if m := loadPrefixRe.FindStringSubmatch(input); m != nil {
+		return filterPrefix(modules, m[1]), m[1]
+	}
+
+	// the last word of identifiers and dots
+	i := len(input)
+	for i > 0 {
+		c := rune(input[i-1])
+		if c != '_' && c != '.' && !unicode.IsLetter(c) && !unicode.IsDigit(c) {
+			break
+		}
+		i--
+	}
+	word := input[i:]
+	if word != "" && unicode.IsDigit(rune(word[0])) {
+		return nil, word
+	}
+
+	// global names, builtins and keywords
+	parts := strings.Split(word, ".")
+	if len(parts) == 1 {
+		names := make([]string, 0, len(globals)+len(starlark.Universe)+len(starlarkKeywords))
+		for name := range globals {
+			names = append(names, name)
+		}
+		for name := range starlark.Universe {
+			names = append(names, name)
+		}
+		names = append(names, starlarkKeywords...)
+		return filterPrefix(uniqueStrings(names), word), word
+	}
+
+	// members of values
+	v, ok := globals[parts[0]]
+	if !ok {
+		v, ok = starlark.Universe[parts[0]]
+	}
+	for _, name := range parts[1 : len(parts)-1] {
+		if !ok {
+			break
+		}
+		v, ok = attrOf(v, name)
+	}
+	if !ok {
+		return nil, word
+	}
+	ha, ok := v.(starlark.HasAttrs)
+	if !ok {
+		return nil, word
+	}
+	prefix := strings.Join(parts[:len(parts)-1], ".") + "."
+	members := filterPrefix(ha.AttrNames(), parts[len(parts)-1])
+	for j, name := range members {
+		members[j] = prefix + name
+	}
+	return members, word
+}
+
+// attrOf returns the attribute of the value if it exists.
+func attrOf(v starlark.Value, name string) (starlark.Value, bool) {
+	ha, ok := v.(starlark.HasAttrs)
+	if !ok {
+		return nil, false
+	}
+	av, err := ha.Attr(name)
+	if err != nil || av == nil {
+		return nil, false
+	}
+	return av, true
+}
+
+// filterPrefix returns the sorted names with the prefix, and hides private names starting with an underscore unless the prefix does.
+func filterPrefix(names []string, prefix string) []string {
+	var res []string
+	for _, name := range names {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		if strings.HasPrefix(name, "_") && !strings.HasPrefix(prefix, "_") {
+			continue
+		}
+		res = append(res, name)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// replCompleter completes the input of the terminal REPL.
+type replCompleter struct {
+	sess *replSession
+}
+
+// Do returns the suffixes of the candidates for the input before the cursor, and the length of the last segment of the partial word.
+func (c *replCompleter) Do(line []rune, pos int) ([][]rune, int) {
+	cands, word := completeInput(string(line[:pos]), c.sess.globals, c.sess.modules)
+	last := word
+	if i := strings.LastIndex(word, "."); i >= 0 {
+		last = word[i+1:]
+	}
+	res := make([][]rune, 0, len(cands))
+	for _, cand := range cands {
+		res = append(res, []rune(cand[len(word):]))
+	}
+	return res, len([]rune(last))
+}
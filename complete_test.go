@@ -0,0 +1,59 @@
+package starbox_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/PureMature/starbox"
+	"go.starlark.net/starlark"
+)
+
+// TestCompletions tests the following:
+// 1. Create a new Starbox instance with globals, modules, structs and module scripts.
+// 2. Check the completions of global names, builtins and keywords before execution.
+// 3. Check the completions of members of modules and structs, and module names in load().
+// 4. Run a script and check the completions include the globals defined by the script.
+func TestCompletions(t *testing.T) {
+	b := starbox.New("test")
+	b.AddKeyValue("answer", 42)
+	b.AddNamedModules("math", "json")
+	b.AddStructData("conf", starlark.StringDict{
+		"host":    starlark.String("localhost"),
+		"port":    starlark.MakeInt(8080),
+		"_secret": starlark.String("hidden"),
+	})
+	b.AddModuleScript("util", `def greet(): return "hi"`)
+
+	tests := []struct {
+		input string
+		want  []string
+	}{
+		{`ans`, []string{"answer"}},
+		{`x = an`, []string{"and", "answer", "any"}},
+		{`whi`, []string{"while"}},
+		{`math.fl`, []string{"math.floor"}},
+		{`print(json.enc`, []string{"json.encode"}},
+		{`conf.`, []string{"conf.host", "conf.port"}},
+		{`conf._`, []string{"conf._secret"}},
+		{`conf.host.up`, []string{"conf.host.upper"}},
+		{`load("js`, []string{"json"}},
+		{`load('ut`, []string{"util.star"}},
+		{`unknown.`, nil},
+		{`12`, nil},
+		{`resu`, nil},
+	}
+	check := func(stage string) {
+		for _, tt := range tests {
+			if got := b.Completions(tt.input); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("[%s] Completions(%q) = %v, want %v", stage, tt.input, got, tt.want)
+			}
+		}
+	}
+	check("before")
+
+	if _, err := b.Run(`result = answer + 1`); err != nil {
+		t.Fatal(err)
+	}
+	tests[len(tests)-1].want = []string{"result"}
+	check("after")
+}
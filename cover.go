@@ -0,0 +1,413 @@
+package starbox
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+// coverBuiltinName is the name of the builtin called by instrumented scripts to count the executed statements.
+const coverBuiltinName = "__cover__"
+
+// coverStmt is an executable statement of a script, the position of compound statements like if and for only covers the header.
+type coverStmt struct {
+	line, col       int
+	endLine, endCol int
+	count           int
+}
+
+// coverFile is the source and the statements of a script instrumented for coverage.
+type coverFile struct {
+	src   []byte
+	stmts []*coverStmt
+}
+
+// Coverage collects the statements executed by the main script and module scripts loaded from filesystems in boxes set by SetCoverage(), and reports them in the format of Go cover profiles and HTML.
+// Scripts are instrumented before execution without changing the line numbers, while the columns in error messages may be shifted.
+// It's safe for concurrent use, and it accumulates the counts of the same scripts across runs and boxes, e.g. clones of the box in script tests.
+type Coverage struct {
+	mu    sync.Mutex
+	files map[string]*coverFile
+}
+
+// NewCoverage creates an empty coverage collector.
+func NewCoverage() *Coverage {
+	return &Coverage{files: make(map[string]*coverFile)}
+}
+
+// FileCoverage is the coverage of a script.
+type FileCoverage struct {
+	File       string      // File is the name of the script, e.g. "box.star" for the main script or "lib/util.star" for a module script.
+	Statements int         // Statements is the number of executable statements.
+	Covered    int         // Covered is the number of executed statements.
+	Lines      map[int]int // Lines is the execution count of each line with statements, the count of the most executed statement starting on it.
+}
+
+// Percent returns the percentage of executed statements, it's 100 if there is no statement.
+func (f FileCoverage) Percent() float64 {
+	if f.Statements == 0 {
+		return 100
+	}
+	return float64(f.Covered) * 100 / float64(f.Statements)
+}
+
+// Files returns the coverage of each script sorted by names.
+func (c *Coverage) Files() []FileCoverage {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	res := make([]FileCoverage, 0, len(c.files))
+	for _, name := range c.names() {
+		fc := FileCoverage{File: name, Lines: make(map[int]int)}
+		for _, st := range c.files[name].stmts {
+			fc.Statements++
+			if st.count > 0 {
+				fc.Covered++
+			}
+			if n, ok := fc.Lines[st.line]; !ok || st.count > n {
+				fc.Lines[st.line] = st.count
+			}
+		}
+		res = append(res, fc)
+	}
+	return res
+}
+
+// Percent returns the percentage of executed statements of all scripts, it's 100 if there is no statement.
+func (c *Coverage) Percent() float64 {
+	total := FileCoverage{}
+	for _, f := range c.Files() {
+		total.Statements += f.Statements
+		total.Covered += f.Covered
+	}
+	return total.Percent()
+}
+
+// names returns the sorted names of scripts, it must be called with the lock held.
+func (c *Coverage) names() []string {
+	names := make([]string, 0, len(c.files))
+	for name := range c.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// WriteProfile writes the coverage in the format of Go cover profiles with the count mode, each block is a statement, e.g. "lib/util.star:3.2,3.18 1 5".
+func (c *Coverage) WriteProfile(w io.Writer) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var sb strings.Builder
+	sb.WriteString("mode: count\n")
+	for _, name := range c.names() {
+		for _, st := range c.files[name].stmts {
+			fmt.Fprintf(&sb, "%s:%d.%d,%d.%d 1 %d\n", name, st.line, st.col, st.endLine, st.endCol, st.count)
+		}
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// coverHTMLTemplate is the template of HTML coverage reports.
+var coverHTMLTemplate = template.Must(template.New("cover").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Starbox Coverage</title>
+<style>
+body { font-family: sans-serif; margin: 1em; }
+pre { font-family: monospace; background: #f8f8f8; padding: 0.5em; }
+.cov { background: #c8f0c8; }
+.part { background: #f0f0b0; }
+.uncov { background: #f0c0c0; }
+.num { color: #999; user-select: none; }
+</style>
+</head>
+<body>
+<h1>Coverage: {{printf "%.1f" .Percent}}% of statements</h1>
+<ul>
+{{- range .Files}}
+<li><a href="#{{.ID}}">{{.Name}}</a>: {{printf "%.1f" .Percent}}%</li>
+{{- end}}
+</ul>
+{{- range .Files}}
+<h2 id="{{.ID}}">{{.Name}}: {{printf "%.1f" .Percent}}%</h2>
+<pre>
+{{- range .Lines}}
+<span class="num">{{printf "%4d" .Num}}</span> <span class="{{.Class}}"{{if .Class}} title="{{.Count}}"{{end}}>{{.Text}}</span>
+{{- end}}
+</pre>
+{{- end}}
+</body>
+</html>
+`))
+
+// WriteHTML writes the coverage as an HTML page, lines are highlighted if all, some or none of the statements starting on them are executed.
+func (c *Coverage) WriteHTML(w io.Writer) error {
+	type htmlLine struct {
+		Num   int
+		Text  string
+		Class string
+		Count int
+	}
+	type htmlFile struct {
+		ID      string
+		Name    string
+		Percent float64
+		Lines   []htmlLine
+	}
+	data := struct {
+		Percent float64
+		Files   []htmlFile
+	}{Percent: c.Percent()}
+
+	c.mu.Lock()
+	for i, name := range c.names() {
+		f := c.files[name]
+		type lineStat struct{ min, max int }
+		stats := make(map[int]*lineStat)
+		covered := 0
+		for _, st := range f.stmts {
+			if st.count > 0 {
+				covered++
+			}
+			if s, ok := stats[st.line]; ok {
+				if st.count < s.min {
+					s.min = st.count
+				}
+				if st.count > s.max {
+					s.max = st.count
+				}
+			} else {
+				stats[st.line] = &lineStat{min: st.count, max: st.count}
+			}
+		}
+		hf := htmlFile{ID: "file" + strconv.Itoa(i), Name: name, Percent: FileCoverage{Statements: len(f.stmts), Covered: covered}.Percent()}
+		for j, text := range strings.Split(strings.ReplaceAll(string(f.src), "\r\n", "\n"), "\n") {
+			hl := htmlLine{Num: j + 1, Text: text}
+			if s, ok := stats[j+1]; ok {
+				hl.Count = s.max
+				switch {
+				case s.min > 0:
+					hl.Class = "cov"
+				case s.max > 0:
+					hl.Class = "part"
+				default:
+					hl.Class = "uncov"
+				}
+			}
+			hf.Lines = append(hf.Lines, hl)
+		}
+		data.Files = append(data.Files, hf)
+	}
+	c.mu.Unlock()
+	return coverHTMLTemplate.Execute(w, data)
+}
+
+// instrument returns the script with calls of the cover builtin inserted before statements, and registers the statements of the script.
+// The script is returned as is if it can't be parsed, so the syntax error is reported by the execution, or if it's empty, e.g. to prepare REPL sessions, so the records of the same name are kept.
+// The records are only replaced if the script changes, so the counts of unchanged scripts accumulate.
+func (c *Coverage) instrument(name string, src []byte) []byte {
+	if len(bytes.TrimSpace(src)) == 0 {
+		return src
+	}
+	f, err := scriptFileOptions().Parse(name, src, 0)
+	if err != nil {
+		return src
+	}
+
+	var (
+		stmts   []*coverStmt
+		inserts []coverInsert
+		quoted  = strconv.Quote(name)
+	)
+	add := func(start, end syntax.Position) string {
+		stmts = append(stmts, &coverStmt{line: int(start.Line), col: int(start.Col), endLine: int(end.Line), endCol: int(end.Col)})
+		return fmt.Sprintf("%s(%s, %d", coverBuiltinName, quoted, len(stmts)-1)
+	}
+	var walk func(list []syntax.Stmt)
+	walk = func(list []syntax.Stmt) {
+		for _, stmt := range list {
+			switch st := stmt.(type) {
+			case *syntax.DefStmt:
+				// definitions are not counted like Go functions, but their bodies are
+				walk(st.Body)
+			case *syntax.IfStmt:
+				start, end := st.Cond.Span()
+				call := add(st.If, end)
+				inserts = append(inserts, coverInsert{start, call + ") and ("}, coverInsert{end, ")"})
+				walk(st.True)
+				walk(st.False)
+			case *syntax.WhileStmt:
+				start, end := st.Cond.Span()
+				call := add(st.While, end)
+				inserts = append(inserts, coverInsert{start, call + ") and ("}, coverInsert{end, ")"})
+				walk(st.Body)
+			case *syntax.ForStmt:
+				start, end := st.X.Span()
+				call := add(st.For, end)
+				inserts = append(inserts, coverInsert{start, call + ", ("}, coverInsert{end, "))"})
+				walk(st.Body)
+			case *syntax.LoadStmt:
+				// loads are resolved before the execution
+			case *syntax.BranchStmt:
+				if st.Token != syntax.PASS {
+					start, end := st.Span()
+					inserts = append(inserts, coverInsert{start, add(start, end) + "); "})
+				}
+			case *syntax.ExprStmt:
+				if _, ok := st.X.(*syntax.Literal); ok {
+					// doc strings are not compiled
+					continue
+				}
+				start, end := st.Span()
+				inserts = append(inserts, coverInsert{start, add(start, end) + "); "})
+			default:
+				start, end := st.Span()
+				inserts = append(inserts, coverInsert{start, add(start, end) + "); "})
+			}
+		}
+	}
+	walk(f.Stmts)
+
+	c.mu.Lock()
+	if old, ok := c.files[name]; !ok || !bytes.Equal(old.src, src) {
+		c.files[name] = &coverFile{src: append([]byte(nil), src...), stmts: stmts}
+	}
+	c.mu.Unlock()
+	return applyCoverInserts(src, inserts)
+}
+
+// coverInsert is a text to insert into the script at the position.
+type coverInsert struct {
+	pos  syntax.Position
+	text string
+}
+
+// applyCoverInserts inserts the texts into the script, the columns of positions are counted in runes.
+func applyCoverInserts(src []byte, inserts []coverInsert) []byte {
+	// offsets of the starts of lines
+	lineStarts := []int{0}
+	for i, b := range src {
+		if b == '\n' {
+			lineStarts = append(lineStarts, i+1)
+		}
+	}
+	offsetOf := func(pos syntax.Position) int {
+		off := lineStarts[pos.Line-1]
+		for col := int32(1); col < pos.Col && off < len(src); col++ {
+			_, size := utf8.DecodeRune(src[off:])
+			off += size
+		}
+		return off
+	}
+
+	type at struct {
+		off  int
+		seq  int
+		text string
+	}
+	list := make([]at, len(inserts))
+	for i, ins := range inserts {
+		list[i] = at{off: offsetOf(ins.pos), seq: i, text: ins.text}
+	}
+	// texts at the same offset keep the order of insertion, e.g. a closing parenthesis before the call of the next statement
+	sort.SliceStable(list, func(i, j int) bool {
+		return list[i].off < list[j].off
+	})
+
+	var buf bytes.Buffer
+	last := 0
+	for _, ins := range list {
+		buf.Write(src[last:ins.off])
+		buf.WriteString(ins.text)
+		last = ins.off
+	}
+	buf.Write(src[last:])
+	return buf.Bytes()
+}
+
+// builtin returns the builtin called by instrumented scripts, which counts the statement and returns the optional value or True.
+func (c *Coverage) builtin() *starlark.Builtin {
+	return starlark.NewBuiltin(coverBuiltinName, func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var (
+			name string
+			id   int
+			val  starlark.Value = starlark.True
+		)
+		if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 2, &name, &id, &val); err != nil {
+			return nil, err
+		}
+		c.mu.Lock()
+		if f, ok := c.files[name]; ok && id >= 0 && id < len(f.stmts) {
+			f.stmts[id].count++
+		}
+		c.mu.Unlock()
+		return val, nil
+	})
+}
+
+// coverFS instruments the module scripts read from the filesystem for coverage.
+type coverFS struct {
+	fsys fs.FS
+	c    *Coverage
+}
+
+// Open opens the file, and returns the instrumented content for Starlark scripts.
+func (f *coverFS) Open(name string) (fs.File, error) {
+	file, err := f.fsys.Open(name)
+	if err != nil || !strings.HasSuffix(name, ".star") {
+		return file, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return f.fsys.Open(name)
+	}
+	src, err := io.ReadAll(file)
+	if err != nil {
+		return nil, err
+	}
+	data := f.c.instrument(name, src)
+	return &coverFileReader{Reader: bytes.NewReader(data), info: info, size: int64(len(data))}, nil
+}
+
+// coverFileReader is an instrumented script in memory.
+type coverFileReader struct {
+	*bytes.Reader
+	info fs.FileInfo
+	size int64
+}
+
+func (r *coverFileReader) Stat() (fs.FileInfo, error) {
+	return coverFileInfo{FileInfo: r.info, size: r.size}, nil
+}
+
+func (r *coverFileReader) Close() error {
+	return nil
+}
+
+// coverFileInfo is the file info of an instrumented script with the new size.
+type coverFileInfo struct {
+	fs.FileInfo
+	size int64
+}
+
+func (i coverFileInfo) Size() int64 {
+	return i.size
+}
@@ -0,0 +1,165 @@
+package starbox_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/PureMature/starbox"
+	"go.starlark.net/starlark"
+)
+
+// coverModule is the module script for coverage tests, the line numbers matter.
+var coverModule = HereDoc(`
+	def classify(n):
+		"""Classify the number."""
+		if n > 0:
+			return "positive"
+		elif n < 0:
+			return "negative"
+		else:
+			pass
+		return "zero"
+
+	def total(items):
+		s = 0
+		for x in items:
+			if x == 0: continue
+			s += x
+		if s > 100:
+			s = 100; return s
+		return s
+`)
+
+// TestCoverage tests the following:
+// 1. Set the coverage to a box with a module script, and run a script calling some branches of the module.
+// 2. Check the statements and lines of each script, and the percentages.
+// 3. Check the cover profile and the HTML report.
+// 4. Check the line numbers of errors are kept, and the counts accumulate across runs.
+// 5. Check empty scripts don't replace the records of the main script.
+func TestCoverage(t *testing.T) {
+	cov := starbox.NewCoverage()
+	b := starbox.New("test")
+	b.AddModuleScript("util", coverModule)
+	b.SetCoverage(cov)
+
+	out, err := b.Run(HereDoc(`
+		load("util", "classify", "total")
+		a = classify(1)
+		z = classify(0)
+		t = total([1, 0, 2])
+	`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprintf("%v %v %v", out["a"], out["z"], out["t"]) != "positive zero 3" {
+		t.Errorf("unexpected output: %v", out)
+	}
+
+	files := cov.Files()
+	var got []string
+	for _, f := range files {
+		got = append(got, fmt.Sprintf("%s %d/%d %.1f%% %v", f.File, f.Covered, f.Statements, f.Percent(), f.Lines))
+	}
+	expected := []string{
+		"box.star 3/3 100.0% map[2:1 3:1 4:1]",
+		"util.star 11/14 78.6% map[3:2 4:1 5:1 6:0 9:1 12:1 13:1 14:3 15:2 16:1 17:0 18:1]",
+	}
+	if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("unexpected coverage:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(expected, "\n"))
+	}
+	if p := cov.Percent(); fmt.Sprintf("%.1f", p) != "82.4" {
+		t.Errorf("unexpected total percent: %.1f", p)
+	}
+
+	var buf bytes.Buffer
+	if err := cov.WriteProfile(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{
+		"mode: count\nbox.star:2.1,2.16 1 1\n",
+		"util.star:3.2,3.10 1 2\n",
+		"util.star:14.3,14.12 1 3\n",
+		"util.star:14.14,14.22 1 1\n",
+		"util.star:17.3,17.10 1 0\n",
+		"util.star:17.12,17.20 1 0\n",
+	} {
+		if !strings.Contains(buf.String(), s) {
+			t.Errorf("expect profile to contain %q, got:\n%s", s, buf.String())
+		}
+	}
+
+	buf.Reset()
+	if err := cov.WriteHTML(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{
+		"<h1>Coverage: 82.4% of statements</h1>",
+		`<a href="#file1">util.star</a>: 78.6%`,
+		`<span class="num">   3</span> <span class="cov" title="2">	if n &gt; 0:</span>`,
+		`<span class="num">   6</span> <span class="uncov" title="0">		return &#34;negative&#34;</span>`,
+		`<span class="num">  14</span> <span class="cov" title="3">		if x == 0: continue</span>`,
+		`<span class="num">  17</span> <span class="uncov" title="0">		s = 100; return s</span>`,
+		`<span class="num">   2</span> <span class="">	&#34;&#34;&#34;Classify the number.&#34;&#34;&#34;</span>`,
+	} {
+		if !strings.Contains(buf.String(), s) {
+			t.Errorf("expect HTML to contain %q, got:\n%s", s, buf.String())
+		}
+	}
+
+	// errors keep the line numbers, and counts accumulate
+	_, err = b.Run("load(\"util\", \"classify\")\nx = classify(-1)\ny = 1 // 0\n")
+	var evalErr *starlark.EvalError
+	if !errors.As(err, &evalErr) || !strings.Contains(evalErr.Backtrace(), "box.star:3:") {
+		t.Errorf("expect error at line 3, got %v", err)
+	}
+	for _, f := range cov.Files() {
+		if f.File == "util.star" && (f.Lines[3] != 3 || f.Lines[6] != 1) {
+			t.Errorf("unexpected lines after the second run: %v", f.Lines)
+		}
+		if f.File == "box.star" && (f.Statements != 2 || f.Covered != 2) {
+			t.Errorf("expect the main script to be replaced, got %d statements", f.Statements)
+		}
+	}
+
+	// empty scripts, e.g. to prepare REPL sessions, keep the records
+	nb := starbox.New("empty")
+	nb.SetCoverage(cov)
+	for _, r := range []*starbox.Starbox{b, nb, nb} {
+		if _, err := r.Run(""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, f := range cov.Files() {
+		if f.File == "box.star" && (f.Statements != 2 || f.Covered != 2) {
+			t.Errorf("expect the main script to be kept after empty runs, got %d statements and %d covered", f.Statements, f.Covered)
+		}
+	}
+}
+
+// TestCoverage_ScriptTests tests the coverage of script tests names the test scripts by their names, and accumulates the counts of module scripts.
+func TestCoverage_ScriptTests(t *testing.T) {
+	cov := starbox.NewCoverage()
+	tpl := starbox.New("tpl")
+	tpl.AddModuleScript("util", coverModule)
+	tpl.SetCoverage(cov)
+	report := starbox.RunScriptTests(tpl, []starbox.ScriptTestFile{{Name: "util_test.star", Script: HereDoc(`
+		load("util", "classify")
+		def test_positive():
+			assert.eq(classify(1), "positive")
+		def test_negative():
+			assert.eq(classify(-1), "negative")
+	`)}})
+	if !report.Passed() {
+		t.Fatalf("unexpected failures: %v", report.Results)
+	}
+	var got []string
+	for _, f := range cov.Files() {
+		got = append(got, fmt.Sprintf("%s %d/%d", f.File, f.Covered, f.Statements))
+	}
+	if s := strings.Join(got, ","); s != "util.star 4/14,util_test.star 2/2" {
+		t.Errorf("unexpected coverage: %s", s)
+	}
+}
@@ -2,6 +2,7 @@ package starbox
 
 import (
 	"fmt"
+	"io"
 	"io/fs"
 	"net/http"
 	"strings"
@@ -23,19 +24,30 @@ type FuncMap map[string]StarlarkFunc
 
 // Starbox is a wrapper of starlet.Machine with additional features.
 type Starbox struct {
-	mac        *starlet.Machine
-	mu         sync.RWMutex
-	hasExec    bool
-	execTimes  uint
-	name       string
-	structTag  string
-	printFunc  starlet.PrintFunc
-	globals    starlet.StringAnyMap
-	modSet     ModuleSetName
-	builtMods  []string
-	loadMods   starlet.ModuleLoaderMap
-	scriptMods map[string]string
-	modFS      fs.FS
+	mac         *starlet.Machine
+	mu          sync.RWMutex
+	hasExec     bool
+	execTimes   uint
+	name        string
+	structTag   string
+	printFunc   starlet.PrintFunc
+	globals     starlet.StringAnyMap
+	modSet      ModuleSetName
+	builtMods   []string
+	loadMods    starlet.ModuleLoaderMap
+	scriptMods  map[string]string
+	modFS       []fs.FS
+	archLimits  ArchiveLimits
+	scriptCache ScriptCache
+	docs        map[string]string
+	debugger    *Debugger
+	loader      *moduleLoader
+	mock        *Mock
+	coverage    *Coverage
+	coverName   string
+	profileW    io.Writer
+	profileFn   func(profile []byte)
+	maxSteps    uint64
 }
 
 // New creates a new Starbox instance with default settings.
@@ -43,6 +55,50 @@ func New(name string) *Starbox {
 	return &Starbox{mac: newStarMachine(name), name: name}
 }
 
+// Clone creates a new Starbox instance with the same name and settings, e.g. globals, modules, filesystems, script cache and attached memories, which can be executed independently.
+// The attached memories, mock and coverage are shared with the original instance, while the results of previous executions of the original instance are not copied.
+func (s *Starbox) Clone() *Starbox {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c := New(s.name)
+	c.structTag = s.structTag
+	c.printFunc = s.printFunc
+	c.modSet = s.modSet
+	c.archLimits = s.archLimits
+	c.scriptCache = s.scriptCache
+	c.mock = s.mock
+	c.coverage = s.coverage
+	c.maxSteps = s.maxSteps
+	if s.globals != nil {
+		c.globals = make(starlet.StringAnyMap, len(s.globals))
+		for k, v := range s.globals {
+			c.globals[k] = v
+		}
+	}
+	c.builtMods = append([]string(nil), s.builtMods...)
+	if s.loadMods != nil {
+		c.loadMods = make(starlet.ModuleLoaderMap, len(s.loadMods))
+		for k, v := range s.loadMods {
+			c.loadMods[k] = v
+		}
+	}
+	if s.scriptMods != nil {
+		c.scriptMods = make(map[string]string, len(s.scriptMods))
+		for k, v := range s.scriptMods {
+			c.scriptMods[k] = v
+		}
+	}
+	c.modFS = append([]fs.FS(nil), s.modFS...)
+	if s.docs != nil {
+		c.docs = make(map[string]string, len(s.docs))
+		for k, v := range s.docs {
+			c.docs[k] = v
+		}
+	}
+	return c
+}
+
 func newStarMachine(name string) *starlet.Machine {
 	m := starlet.NewDefault()
 	m.EnableGlobalReassign()
@@ -66,6 +122,79 @@ func (s *Starbox) GetMachine() *starlet.Machine {
 	return s.mac
 }
 
+// SetDebugger attaches the debugger to pause the executions at breakpoints, and adds the builtin breakpoint() to pause at the call site if the name is not taken.
+// The debugger also works for functions called in REPL sessions, and it should be attached to only one box at a time.
+// It panics if called after execution.
+func (s *Starbox) SetDebugger(d *Debugger) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.hasExec {
+		log.DPanic("cannot set debugger after execution")
+	}
+	s.debugger = d
+}
+
+// SetMaxSteps limits the number of Starlark computation steps of each execution, and the execution fails with "too many steps" when it's exceeded, zero means no limit.
+// The limit applies to the main script and functions it calls, and it's kept when a debugger is attached, while module scripts are loaded on other threads without the limit.
+// It panics if called after execution.
+func (s *Starbox) SetMaxSteps(steps uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.hasExec {
+		log.DPanic("cannot set max steps after execution")
+	}
+	s.maxSteps = steps
+}
+
+// SetMock replaces the builtins and module members mocked by the mock with recorded fakes, and adds the "mock" module for scripts to inspect the recorded calls.
+// The targets must be mocked before the first run, while the fixtures can be changed anytime.
+// It panics if called after execution.
+func (s *Starbox) SetMock(m *Mock) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.hasExec {
+		log.DPanic("cannot set mock after execution")
+	}
+	s.mock = m
+}
+
+// SetCoverage makes the box instrument the main script and module scripts loaded from filesystems to collect the executed statements into the coverage, the main script is named "box.star" in reports.
+// It panics if called after execution.
+func (s *Starbox) SetCoverage(c *Coverage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.hasExec {
+		log.DPanic("cannot set coverage after execution")
+	}
+	s.coverage = c
+}
+
+// SetProfile makes each execution of scripts write a profile of the Starlark call stacks sampled during it to the writer, in the gzipped pprof format with function names and file:line positions for "go tool pprof".
+// The profiler measures wall time, and it's global to the process, so profiled executions of all boxes are serialized, and other Starlark threads running at the same time are sampled as well.
+// Each execution writes a complete profile, so profiles of multiple executions written to the same writer are concatenated and invalid, set a fresh writer before each execution or use SetProfileFunc() instead.
+// The writer can be changed or set to nil to disable profiling between executions, it replaces the function set by SetProfileFunc(), and it's not copied to clones.
+func (s *Starbox) SetProfile(w io.Writer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.profileW = w
+	s.profileFn = nil
+}
+
+// SetProfileFunc makes each execution of scripts profiled like SetProfile(), and the function is called with the gzipped pprof profile of each execution after it finishes, even if it fails.
+// The function can be changed or set to nil to disable profiling between executions, it replaces the writer set by SetProfile(), and it's not copied to clones.
+func (s *Starbox) SetProfileFunc(fn func(profile []byte)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.profileFn = fn
+	s.profileW = nil
+}
+
 // SetStructTag sets the custom tag of Go struct fields for Starlark.
 // It panics if called after execution.
 func (s *Starbox) SetStructTag(tag string) {
@@ -90,8 +219,8 @@ func (s *Starbox) SetPrintFunc(printFunc starlet.PrintFunc) {
 	s.printFunc = printFunc
 }
 
-// SetFS sets the virtual filesystem for module scripts.
-// If it's not nil, it'll override all the scripts added by AddModuleScript().
+// SetFS sets the virtual filesystem for module scripts, and replaces all the filesystem layers added before.
+// Scripts added by AddModuleScript() shadow the files with the same path in the filesystem, so a base library can be patched module by module.
 // It panics if called after execution.
 func (s *Starbox) SetFS(hfs fs.FS) {
 	s.mu.Lock()
@@ -100,7 +229,26 @@ func (s *Starbox) SetFS(hfs fs.FS) {
 	if s.hasExec {
 		log.DPanic("cannot set filesystem after execution")
 	}
-	s.modFS = hfs
+	if hfs == nil {
+		s.modFS = nil
+	} else {
+		s.modFS = []fs.FS{hfs}
+	}
+}
+
+// AddFS adds a virtual filesystem layer for module scripts on top of the layers set by SetFS() or AddFS() before.
+// Files in the layer added later shadow the files with the same path in the earlier layers, and scripts added by AddModuleScript() shadow all the layers.
+// It panics if called after execution.
+func (s *Starbox) AddFS(hfs fs.FS) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.hasExec {
+		log.DPanic("cannot add filesystem after execution")
+	}
+	if hfs != nil {
+		s.modFS = append(s.modFS, hfs)
+	}
 }
 
 // SetModuleSet sets the module set to be loaded before execution.
@@ -198,6 +346,18 @@ func (s *Starbox) AddBuiltin(name string, starFunc StarlarkFunc) {
 	s.globals[name] = sb
 }
 
+// AddBuiltinDoc adds the documentation of a builtin function by its name, e.g. "fetch" for AddBuiltin() or "module.func" for AddModuleFunctions(), which is shown by help() in REPL sessions.
+// Unlike other settings, it can be called after execution, and takes effect in the next REPL session.
+func (s *Starbox) AddBuiltinDoc(name, doc string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.docs == nil {
+		s.docs = make(map[string]string)
+	}
+	s.docs[name] = doc
+}
+
 // AddNamedModules adds builtin modules by name to the preload and lazyload registry.
 // It will not load the modules until the first run.
 // It panics if called after execution.
@@ -303,6 +463,7 @@ func (s *Starbox) AddStructData(structName string, structData starlark.StringDic
 
 // AddModuleScript creates a module with given module script in virtual filesystem, and adds it to the preload and lazyload registry.
 // The given module script can be accessed in script via load("module_name", "key1") or load("module_name.star", "key1") if module name has no ".star" suffix.
+// The module name can contain directories like "lib/util", and the module script shadows the file with the same path in filesystems set by SetFS() or AddFS().
 // It panics if called after execution.
 func (s *Starbox) AddModuleScript(moduleName, moduleScript string) {
 	s.mu.Lock()
@@ -358,7 +519,9 @@ func NewMemory() *dataconv.SharedDict {
 }
 
 // AttachMemory adds a shared dictionary to the global environment before execution.
-func (s *Starbox) AttachMemory(name string, memory *dataconv.SharedDict) {
+// Changes made by the script can be observed via Subscribe() or SubscribeFunc(), and options like MemoryReadOnly() and MemoryNamespace() restrict what the script can see and change.
+// Besides the methods of the shared dictionary, scripts can call update_key(key, fn, default=None) on the memory to atomically replace the value of the key with the result of fn.
+func (s *Starbox) AttachMemory(name string, memory *dataconv.SharedDict, opts ...MemoryOption) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -368,11 +531,11 @@ func (s *Starbox) AttachMemory(name string, memory *dataconv.SharedDict) {
 	if s.globals == nil {
 		s.globals = make(starlet.StringAnyMap)
 	}
-	s.globals[name] = memory
+	s.globals[name] = newMemoryView(memory, s.name, opts...)
 }
 
 // CreateMemory creates a new shared dictionary for la mémoire collective with the given name, and adds it to the global environment before execution.
-func (s *Starbox) CreateMemory(name string) *dataconv.SharedDict {
+func (s *Starbox) CreateMemory(name string, opts ...MemoryOption) *dataconv.SharedDict {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -383,6 +546,6 @@ func (s *Starbox) CreateMemory(name string) *dataconv.SharedDict {
 		s.globals = make(starlet.StringAnyMap)
 	}
 	memory := dataconv.NewNamedSharedDict(memoryTypeName)
-	s.globals[name] = memory
+	s.globals[name] = newMemoryView(memory, s.name, opts...)
 	return memory
 }
@@ -157,7 +157,7 @@ func TestSetPrintFunc(t *testing.T) {
 // 2. Create a new Starbox instance.
 // 3. Set the virtual filesystem, and add a module script.
 // 4. Run a script that uses the virtual filesystem.
-// 5. Check the output -- the virtual filesystem should override the module script.
+// 5. Check the output -- the module script should shadow the file in virtual filesystem.
 // 6. Rerun the script with the same virtual filesystem.
 // 7. Check the output -- the virtual filesystem should persist.
 func TestSetFS(t *testing.T) {
@@ -196,7 +196,7 @@ func TestSetFS(t *testing.T) {
 		t.Errorf("expect 1, got %d", len(out))
 		return
 	}
-	if es := int64(30); out["c"] != es {
+	if es := int64(300); out["c"] != es {
 		t.Errorf("expect %d, got %v", es, out["c"])
 		return
 	}
@@ -218,7 +218,7 @@ func TestSetFS(t *testing.T) {
 		t.Errorf("expect 1, got %d", len(out))
 		return
 	}
-	if es := int64(200); out["d"] != es {
+	if es := int64(20000); out["d"] != es {
 		t.Errorf("expect %d, got %v", es, out["d"])
 		return
 	}
@@ -841,3 +841,44 @@ func TestCollectiveMemory(t *testing.T) {
 		return
 	}
 }
+
+// TestClone tests the following:
+// 1. Create a new Starbox instance with globals, modules, module scripts and memory, and run a script.
+// 2. Clone the instance, and check the clone can run with the same settings but without the results of previous executions.
+// 3. Check the memory is shared, and changes of settings of the clone don't affect the original.
+func TestClone(t *testing.T) {
+	b := starbox.New("test")
+	b.AddKeyValue("base", 10)
+	b.AddNamedModules("math")
+	b.AddModuleScript("util", `def twice(n): return n * 2`)
+	mem := b.CreateMemory("memory")
+	if _, err := b.Run(`prev = base + 1`); err != nil {
+		t.Fatal(err)
+	}
+
+	c := b.Clone()
+	if s := c.String(); s != "🥡Box{name:test,run:0}" {
+		t.Errorf("unexpected clone: %s", s)
+	}
+	c.AddKeyValue("extra", 1)
+	res, err := c.Run(HereDoc(`
+		load("util", "twice")
+		x = twice(base) + math.floor(1.5) + extra
+		memory["from_clone"] = x
+	`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res["x"] != int64(22) {
+		t.Errorf("unexpected output of clone: %v", res)
+	}
+	if v, ok, _ := mem.Get(starlark.String("from_clone")); !ok || v != starlark.MakeInt(22) {
+		t.Errorf("expect memory shared by clone, got %v", v)
+	}
+	if _, err := c.Run(`prev`); err == nil {
+		t.Error("expect globals of previous executions not copied, got nil error")
+	}
+	if _, err := b.Run(`extra`); err == nil {
+		t.Error("expect settings of clone not affecting original, got nil error")
+	}
+}
@@ -0,0 +1,792 @@
+package starbox
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"go.starlark.net/starlark"
+)
+
+const (
+	// dapThreadID is the only thread reported to the client of the Debug Adapter Protocol.
+	dapThreadID = 1
+	// dapMaxValueLength is the maximum length of values shown in the variables view of the client.
+	dapMaxValueLength = 256
+)
+
+// DAPProgram is a script to debug in a box for a debug session of the Debug Adapter Protocol.
+type DAPProgram struct {
+	Box    *Starbox // Box is the box to run the script, it must not have been executed, and its debugger and print function are set by the session.
+	Script string   // Script is the content of the script to run.
+	Path   string   // Path is the path of the script on disk for the client, it's optional.
+	Dir    string   // Dir is the directory on disk of module scripts set by SetFS(), module scripts found there with the same content are shown by paths, and others like scripts added by AddModuleScript() are served by source references.
+}
+
+// NewDAPProgram reads the script file at the path, and makes the box load module scripts from the directory of the script.
+func NewDAPProgram(box *Starbox, path string) (*DAPProgram, error) {
+	if box == nil {
+		return nil, errors.New("nil box for program")
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	script, err := os.ReadFile(abs)
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Dir(abs)
+	box.SetFS(os.DirFS(dir))
+	return &DAPProgram{Box: box, Script: string(script), Path: abs, Dir: dir}, nil
+}
+
+// DAPLaunchFunc creates the program to debug for the launch request of the client, the arguments are the launch configuration, e.g. "program" for the path of the script.
+type DAPLaunchFunc func(args map[string]interface{}) (*DAPProgram, error)
+
+// DefaultDAPLaunch creates a box with the full module set for the script at the path of "program" in the launch configuration.
+func DefaultDAPLaunch(args map[string]interface{}) (*DAPProgram, error) {
+	path, _ := args["program"].(string)
+	if path == "" {
+		return nil, errors.New(`missing "program" in launch configuration`)
+	}
+	box := New(strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)))
+	box.SetModuleSet(FullModuleSet)
+	return NewDAPProgram(box, path)
+}
+
+// ServeDAP serves a debug session of the Debug Adapter Protocol over the streams, e.g. stdio or a network connection, so editors like VS Code can launch a script in a box, set breakpoints, step, and view variables.
+// The launch function creates the program for the launch request, and DefaultDAPLaunch() is used if it's nil.
+// It returns when the client disconnects or the input ends, and the running script is stopped.
+func ServeDAP(in io.Reader, out io.Writer, launch DAPLaunchFunc) error {
+	if in == nil || out == nil {
+		return errors.New("nil input or output for DAP")
+	}
+	if launch == nil {
+		launch = DefaultDAPLaunch
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sess := &dapSession{
+		rd:      bufio.NewReader(in),
+		out:     out,
+		launch:  launch,
+		ctx:     ctx,
+		cancel:  cancel,
+		cmds:    make(chan dapCommand),
+		done:    make(chan struct{}),
+		srcRefs: make(map[string]int),
+		bps:     make(map[string][]int),
+		srcBps:  make(map[string]*dapBreakpoints),
+	}
+	return sess.serve()
+}
+
+// dapRequest is a request from the client.
+type dapRequest struct {
+	Seq       int             `json:"seq"`
+	Type      string          `json:"type"`
+	Command   string          `json:"command"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+// dapResponse is a response to a request of the client.
+type dapResponse struct {
+	Seq        int         `json:"seq"`
+	Type       string      `json:"type"`
+	RequestSeq int         `json:"request_seq"`
+	Success    bool        `json:"success"`
+	Command    string      `json:"command"`
+	Message    string      `json:"message,omitempty"`
+	Body       interface{} `json:"body,omitempty"`
+}
+
+// dapEvent is an event sent to the client.
+type dapEvent struct {
+	Seq   int         `json:"seq"`
+	Type  string      `json:"type"`
+	Event string      `json:"event"`
+	Body  interface{} `json:"body,omitempty"`
+}
+
+// dapSource is a source in the messages of the protocol.
+type dapSource struct {
+	Name             string `json:"name,omitempty"`
+	Path             string `json:"path,omitempty"`
+	SourceReference  int    `json:"sourceReference,omitempty"`
+	PresentationHint string `json:"presentationHint,omitempty"`
+}
+
+// dapVariable is a variable in the variables view of the client.
+type dapVariable struct {
+	Name               string `json:"name"`
+	Value              string `json:"value"`
+	Type               string `json:"type,omitempty"`
+	VariablesReference int    `json:"variablesReference"`
+}
+
+// dapCommand is sent to the paused script, either to run the function with the state in the goroutine of the script, or to resume with the action.
+type dapCommand struct {
+	fn     func(st *DebugState)
+	action DebugAction
+	done   chan struct{}
+}
+
+// dapBreakpoints is the breakpoints of a source set by the client, which are kept until the source is set again, and applied to the debugger once the program is launched.
+type dapBreakpoints struct {
+	source dapSource
+	lines  []int
+	ids    []int
+}
+
+// errDAPNotPaused is returned for requests which need the script to be paused.
+var errDAPNotPaused = errors.New("script is not paused")
+
+// dapSession is a debug session of the Debug Adapter Protocol.
+type dapSession struct {
+	rd     *bufio.Reader
+	out    io.Writer
+	wmu    sync.Mutex
+	seq    int
+	launch DAPLaunchFunc
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	prog        *DAPProgram
+	dbg         *Debugger
+	stopOnEntry bool
+	configured  bool
+	started     bool
+	cmds        chan dapCommand // commands to the paused script
+	done        chan struct{}   // closed when the script finishes
+
+	mu       sync.Mutex
+	paused   bool
+	srcRefs  map[string]int             // source references of scripts without paths
+	srcFiles []string                   // file names of source references, the reference is the index plus one
+	bps      map[string][]int           // lines of breakpoints set to the debugger by file names in the box
+	srcBps   map[string]*dapBreakpoints // breakpoints set by the client by sources
+	nextBpID int
+	after    []func() // functions to run after the response of the current request is sent
+
+	// variable references, only accessed in the goroutine of the paused script
+	refs []interface{}
+}
+
+// serve reads and handles requests until the client disconnects or the input ends.
+func (s *dapSession) serve() error {
+	defer s.stop()
+	for {
+		req, err := s.readRequest()
+		if err != nil {
+			if err == io.EOF || s.ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		if req.Type != "request" {
+			continue
+		}
+		body, err := s.handle(req)
+		s.respond(req, body, err)
+		for _, fn := range s.after {
+			fn()
+		}
+		s.after = nil
+		if req.Command == "disconnect" {
+			return nil
+		}
+	}
+}
+
+// stop cancels the running script and waits for it to finish.
+func (s *dapSession) stop() {
+	s.cancel()
+	if s.started {
+		<-s.done
+	}
+}
+
+// readRequest reads a message with the Content-Length header.
+func (s *dapSession) readRequest() (*dapRequest, error) {
+	length := -1
+	for {
+		line, err := s.rd.ReadString('\n')
+		if err != nil {
+			if err == io.EOF && line == "" {
+				return nil, io.EOF
+			}
+			return nil, err
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			if length >= 0 {
+				break
+			}
+			continue
+		}
+		if k, v, ok := strings.Cut(line, ":"); ok && strings.EqualFold(strings.TrimSpace(k), "Content-Length") {
+			if length, err = strconv.Atoi(strings.TrimSpace(v)); err != nil || length < 0 {
+				return nil, fmt.Errorf("invalid content length: %q", v)
+			}
+		}
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(s.rd, data); err != nil {
+		return nil, err
+	}
+	var req dapRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		return nil, err
+	}
+	return &req, nil
+}
+
+// send writes a message with the Content-Length header, and sets its sequence number.
+func (s *dapSession) send(setSeq func(seq int) interface{}) {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+
+	s.seq++
+	data, err := json.Marshal(setSeq(s.seq))
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(s.out, "Content-Length: %d\r\n\r\n%s", len(data), data)
+}
+
+// respond sends the response of the request with the body or the error.
+func (s *dapSession) respond(req *dapRequest, body interface{}, err error) {
+	s.send(func(seq int) interface{} {
+		resp := dapResponse{Seq: seq, Type: "response", RequestSeq: req.Seq, Success: err == nil, Command: req.Command, Body: body}
+		if err != nil {
+			resp.Message = err.Error()
+			resp.Body = nil
+		}
+		return resp
+	})
+}
+
+// sendEvent sends the event with the body.
+func (s *dapSession) sendEvent(event string, body interface{}) {
+	s.send(func(seq int) interface{} {
+		return dapEvent{Seq: seq, Type: "event", Event: event, Body: body}
+	})
+}
+
+// handle handles the request, and returns the body of the response or the error.
+func (s *dapSession) handle(req *dapRequest) (interface{}, error) {
+	var args map[string]interface{}
+	if len(req.Arguments) > 0 {
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			return nil, err
+		}
+	}
+	switch req.Command {
+	case "initialize":
+		s.afterResponse(func() {
+			s.sendEvent("initialized", nil)
+		})
+		return map[string]interface{}{
+			"supportsConfigurationDoneRequest": true,
+			"supportsEvaluateForHovers":        true,
+			"supportsTerminateRequest":         true,
+		}, nil
+	case "launch":
+		return nil, s.onLaunch(args)
+	case "setBreakpoints":
+		return s.onSetBreakpoints(req.Arguments)
+	case "setExceptionBreakpoints":
+		return map[string]interface{}{"breakpoints": []interface{}{}}, nil
+	case "configurationDone":
+		return nil, s.onConfigurationDone()
+	case "threads":
+		return map[string]interface{}{"threads": []interface{}{map[string]interface{}{"id": dapThreadID, "name": "main"}}}, nil
+	case "continue":
+		return map[string]interface{}{"allThreadsContinued": true}, s.resume(DebugContinue)
+	case "next":
+		return nil, s.resume(DebugStepOver)
+	case "stepIn":
+		return nil, s.resume(DebugStepInto)
+	case "stepOut":
+		return nil, s.resume(DebugStepOut)
+	case "pause":
+		if s.dbg == nil {
+			return nil, errors.New("no program launched")
+		}
+		s.dbg.Pause()
+		return nil, nil
+	case "stackTrace":
+		return s.onStackTrace()
+	case "scopes":
+		return s.onScopes(intArg(args, "frameId"))
+	case "variables":
+		return s.onVariables(intArg(args, "variablesReference"))
+	case "evaluate":
+		expr, _ := args["expression"].(string)
+		return s.onEvaluate(expr, intArg(args, "frameId"))
+	case "source":
+		return s.onSource(args)
+	case "terminate", "disconnect":
+		s.stop()
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unsupported request: %s", req.Command)
+	}
+}
+
+// afterResponse runs the function after the response of the current request is sent, e.g. to send events which must follow the response.
+func (s *dapSession) afterResponse(fn func()) {
+	s.after = append(s.after, fn)
+}
+
+// intArg returns the integer argument of the request, JSON numbers are decoded as floats.
+func intArg(args map[string]interface{}, key string) int {
+	if f, ok := args[key].(float64); ok {
+		return int(f)
+	}
+	return 0
+}
+
+// onLaunch creates the program with the launch function, and attaches the debugger, the script runs after the configuration is done.
+func (s *dapSession) onLaunch(args map[string]interface{}) error {
+	if s.prog != nil {
+		return errors.New("program already launched")
+	}
+	prog, err := s.launch(args)
+	if err != nil {
+		return err
+	}
+	if prog == nil || prog.Box == nil {
+		return errors.New("no box to launch")
+	}
+	s.prog = prog
+	s.stopOnEntry, _ = args["stopOnEntry"].(bool)
+	s.dbg = NewDebugger(s.onPause)
+	prog.Box.SetDebugger(s.dbg)
+	prog.Box.SetPrintFunc(func(_ *starlark.Thread, msg string) {
+		s.sendEvent("output", map[string]interface{}{"category": "stdout", "output": msg + "\n"})
+	})
+
+	// breakpoints set before the launch are applied now, and the client is notified of their states
+	keys := make([]string, 0, len(s.srcBps))
+	for k := range s.srcBps {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var changed []map[string]interface{}
+	for _, k := range keys {
+		changed = append(changed, s.applyBreakpoints(s.srcBps[k])...)
+	}
+	s.afterResponse(func() {
+		for _, b := range changed {
+			s.sendEvent("breakpoint", map[string]interface{}{"reason": "changed", "breakpoint": b})
+		}
+	})
+	if s.configured {
+		s.run()
+	}
+	return nil
+}
+
+// onConfigurationDone starts running the script, or marks the configuration as done to run it once the program is launched.
+func (s *dapSession) onConfigurationDone() error {
+	s.configured = true
+	if s.prog != nil {
+		s.run()
+	}
+	return nil
+}
+
+// run starts running the script in a goroutine if it's not started.
+func (s *dapSession) run() {
+	if s.started {
+		return
+	}
+	s.started = true
+	if s.stopOnEntry {
+		s.dbg.requestPause("entry")
+	}
+	go func() {
+		defer close(s.done)
+		_, err := s.prog.Box.RunContext(s.ctx, s.prog.Script)
+		code := 0
+		if err != nil {
+			code = 1
+			s.sendEvent("output", map[string]interface{}{"category": "stderr", "output": err.Error() + "\n"})
+		}
+		s.sendEvent("exited", map[string]interface{}{"exitCode": code})
+		s.sendEvent("terminated", nil)
+	}()
+}
+
+// onPause is the handler of the debugger, it notifies the client, and runs the commands from the client until the script is resumed.
+func (s *dapSession) onPause(st *DebugState) DebugAction {
+	s.refs = nil
+	s.setPaused(true)
+	defer s.setPaused(false)
+
+	s.sendEvent("stopped", map[string]interface{}{
+		"reason":            st.Reason,
+		"threadId":          dapThreadID,
+		"allThreadsStopped": true,
+	})
+	for {
+		select {
+		case cmd := <-s.cmds:
+			if cmd.fn == nil {
+				return cmd.action
+			}
+			cmd.fn(st)
+			close(cmd.done)
+		case <-s.ctx.Done():
+			return DebugStop
+		}
+	}
+}
+
+func (s *dapSession) setPaused(paused bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.paused = paused
+}
+
+func (s *dapSession) isPaused() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.paused
+}
+
+// whilePaused runs the function with the state in the goroutine of the paused script, and waits for it to return.
+func (s *dapSession) whilePaused(fn func(st *DebugState)) error {
+	if !s.isPaused() {
+		return errDAPNotPaused
+	}
+	cmd := dapCommand{fn: fn, done: make(chan struct{})}
+	select {
+	case s.cmds <- cmd:
+		<-cmd.done
+		return nil
+	case <-s.done:
+		return errDAPNotPaused
+	}
+}
+
+// resume resumes the paused script with the action.
+func (s *dapSession) resume(action DebugAction) error {
+	if !s.isPaused() {
+		return errDAPNotPaused
+	}
+	select {
+	case s.cmds <- dapCommand{action: action}:
+		return nil
+	case <-s.done:
+		return errDAPNotPaused
+	}
+}
+
+// onStackTrace returns the frames of the paused script, the frame ID is the depth plus one.
+func (s *dapSession) onStackTrace() (interface{}, error) {
+	var frames []map[string]interface{}
+	err := s.whilePaused(func(st *DebugState) {
+		for i, f := range st.Stack() {
+			fr := map[string]interface{}{
+				"id":     i + 1,
+				"name":   f.Name,
+				"line":   f.Line,
+				"column": f.Column,
+			}
+			if src := s.source(f.File); src != nil {
+				fr["source"] = src
+			}
+			frames = append(frames, fr)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"stackFrames": frames, "totalFrames": len(frames)}, nil
+}
+
+// onScopes returns the scopes of parameters and globals of the frame, other local variables are not available as DebugState.Locals() explains.
+func (s *dapSession) onScopes(frameID int) (interface{}, error) {
+	var scopes []map[string]interface{}
+	err := s.whilePaused(func(st *DebugState) {
+		depth := frameID - 1
+		scopes = append(scopes, map[string]interface{}{
+			"name":               "Parameters",
+			"presentationHint":   "arguments",
+			"variablesReference": s.newRef(st.Locals(depth)),
+		}, map[string]interface{}{
+			"name":               "Globals",
+			"variablesReference": s.newRef(scriptGlobals(st, depth)),
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"scopes": scopes}, nil
+}
+
+// onVariables returns the variables of the scope or the members of the value of the reference.
+func (s *dapSession) onVariables(ref int) (interface{}, error) {
+	vars := []dapVariable{}
+	err := s.whilePaused(func(_ *DebugState) {
+		if ref <= 0 || ref > len(s.refs) {
+			return
+		}
+		switch x := s.refs[ref-1].(type) {
+		case starlark.StringDict:
+			for _, name := range x.Keys() {
+				vars = append(vars, s.variable(name, x[name]))
+			}
+		case starlark.Value:
+			vars = append(vars, s.members(x)...)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"variables": vars}, nil
+}
+
+// onEvaluate evaluates the expression in the frame of the paused script.
+func (s *dapSession) onEvaluate(expr string, frameID int) (interface{}, error) {
+	var (
+		res     dapVariable
+		evalErr error
+	)
+	if frameID <= 0 {
+		frameID = 1
+	}
+	err := s.whilePaused(func(st *DebugState) {
+		var v starlark.Value
+		if v, evalErr = st.Eval(expr, frameID-1); evalErr == nil {
+			res = s.variable(expr, v)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if evalErr != nil {
+		return nil, evalErr
+	}
+	return map[string]interface{}{"result": res.Value, "type": res.Type, "variablesReference": res.VariablesReference}, nil
+}
+
+// onSource returns the content of the script of the source reference.
+func (s *dapSession) onSource(args map[string]interface{}) (interface{}, error) {
+	ref := intArg(args, "sourceReference")
+	if src, ok := args["source"].(map[string]interface{}); ok && ref == 0 {
+		ref = intArg(src, "sourceReference")
+	}
+	s.mu.Lock()
+	var file string
+	if ref > 0 && ref <= len(s.srcFiles) {
+		file = s.srcFiles[ref-1]
+	}
+	s.mu.Unlock()
+	if file == "" || s.dbg == nil {
+		return nil, fmt.Errorf("unknown source reference: %d", ref)
+	}
+	content, ok := s.dbg.Source(file)
+	if !ok {
+		return nil, fmt.Errorf("source not available: %s", file)
+	}
+	return map[string]interface{}{"content": content, "mimeType": "text/x-starlark"}, nil
+}
+
+// onSetBreakpoints replaces the breakpoints of the source, they are applied to the debugger once the program is launched if it's not yet.
+func (s *dapSession) onSetBreakpoints(raw json.RawMessage) (interface{}, error) {
+	var args struct {
+		Source      dapSource `json:"source"`
+		Breakpoints []struct {
+			Line int `json:"line"`
+		} `json:"breakpoints"`
+		Lines []int `json:"lines"`
+	}
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+	lines := args.Lines
+	if len(args.Breakpoints) > 0 {
+		lines = lines[:0]
+		for _, b := range args.Breakpoints {
+			lines = append(lines, b.Line)
+		}
+	}
+
+	key := args.Source.Path
+	if args.Source.SourceReference > 0 {
+		key = "#" + strconv.Itoa(args.Source.SourceReference)
+	}
+	b := &dapBreakpoints{source: args.Source, lines: lines}
+	for range lines {
+		s.nextBpID++
+		b.ids = append(b.ids, s.nextBpID)
+	}
+	s.srcBps[key] = b
+
+	if s.dbg == nil {
+		res := make([]map[string]interface{}, 0, len(lines))
+		for i, line := range lines {
+			res = append(res, map[string]interface{}{"id": b.ids[i], "verified": false, "line": line, "message": "pending launch"})
+		}
+		return map[string]interface{}{"breakpoints": res}, nil
+	}
+	return map[string]interface{}{"breakpoints": s.applyBreakpoints(b)}, nil
+}
+
+// applyBreakpoints sets the breakpoints of the source to the debugger, and returns their states for the client.
+func (s *dapSession) applyBreakpoints(b *dapBreakpoints) []map[string]interface{} {
+	file := s.fileOf(b.source)
+	res := make([]map[string]interface{}, 0, len(b.lines))
+	if file == "" {
+		for i, line := range b.lines {
+			res = append(res, map[string]interface{}{"id": b.ids[i], "verified": false, "line": line, "message": "source is not in the box"})
+		}
+		return res
+	}
+	for _, line := range s.bps[file] {
+		s.dbg.ClearBreakpoint(file, line)
+	}
+	s.bps[file] = b.lines
+	for i, line := range b.lines {
+		s.dbg.SetBreakpoint(file, line)
+		res = append(res, map[string]interface{}{"id": b.ids[i], "verified": true, "line": line})
+	}
+	return res
+}
+
+// fileOf maps the source from the client to the file name of the script in the box, or an empty string if it's not in the box.
+func (s *dapSession) fileOf(src dapSource) string {
+	if src.SourceReference > 0 {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if src.SourceReference <= len(s.srcFiles) {
+			return s.srcFiles[src.SourceReference-1]
+		}
+		return ""
+	}
+	if src.Path == "" {
+		return ""
+	}
+	p := filepath.Clean(src.Path)
+	if s.prog.Path != "" && p == filepath.Clean(s.prog.Path) {
+		return mainScriptName
+	}
+	if s.prog.Dir != "" {
+		if rel, err := filepath.Rel(s.prog.Dir, p); err == nil && !strings.HasPrefix(rel, "..") && !filepath.IsAbs(rel) {
+			return normalizeScriptName(filepath.ToSlash(rel))
+		}
+	}
+	return ""
+}
+
+// source maps the file name of the script in the box to the source for the client, scripts on disk with the same content are shown by paths, and others by source references.
+func (s *dapSession) source(file string) *dapSource {
+	if strings.HasPrefix(file, "<") {
+		return nil
+	}
+	content, known := s.dbg.Source(file)
+	if file == mainScriptName && s.prog.Path != "" {
+		return &dapSource{Name: filepath.Base(s.prog.Path), Path: s.prog.Path}
+	}
+	if s.prog.Dir != "" {
+		p := filepath.Join(s.prog.Dir, filepath.FromSlash(file))
+		if data, err := os.ReadFile(p); err == nil && (!known || strings.ReplaceAll(string(data), "\r\n", "\n") == content) {
+			return &dapSource{Name: filepath.Base(p), Path: p}
+		}
+	}
+	if !known {
+		return &dapSource{Name: file, PresentationHint: "deemphasize"}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ref, ok := s.srcRefs[file]
+	if !ok {
+		s.srcFiles = append(s.srcFiles, file)
+		ref = len(s.srcFiles)
+		s.srcRefs[file] = ref
+	}
+	return &dapSource{Name: file, SourceReference: ref}
+}
+
+// newRef returns a new reference of the scope or value for the variables request, it's valid until the script is resumed.
+func (s *dapSession) newRef(v interface{}) int {
+	s.refs = append(s.refs, v)
+	return len(s.refs)
+}
+
+// variable returns the variable of the value, and a reference if it has members.
+func (s *dapSession) variable(name string, v starlark.Value) dapVariable {
+	val := v.String()
+	if len(val) > dapMaxValueLength {
+		val = val[:dapMaxValueLength] + "..."
+	}
+	dv := dapVariable{Name: name, Value: val, Type: v.Type()}
+	switch x := v.(type) {
+	case starlark.String, starlark.Bytes:
+	case starlark.Indexable, *starlark.Dict, *starlark.Set:
+		if l, ok := x.(starlark.Sequence); !ok || l.Len() > 0 {
+			dv.VariablesReference = s.newRef(v)
+		}
+	case starlark.HasAttrs:
+		if len(x.AttrNames()) > 0 {
+			dv.VariablesReference = s.newRef(v)
+		}
+	}
+	return dv
+}
+
+// members returns the items of lists, tuples and sets, the entries of dicts, or the attributes of other values.
+func (s *dapSession) members(v starlark.Value) []dapVariable {
+	var vars []dapVariable
+	switch x := v.(type) {
+	case *starlark.Dict:
+		for _, kv := range x.Items() {
+			vars = append(vars, s.variable(kv[0].String(), kv[1]))
+		}
+	case starlark.Indexable:
+		for i := 0; i < x.Len(); i++ {
+			vars = append(vars, s.variable("["+strconv.Itoa(i)+"]", x.Index(i)))
+		}
+	case starlark.Iterable:
+		iter := x.Iterate()
+		defer iter.Done()
+		var item starlark.Value
+		for i := 0; iter.Next(&item); i++ {
+			vars = append(vars, s.variable("["+strconv.Itoa(i)+"]", item))
+		}
+	case starlark.HasAttrs:
+		names := x.AttrNames()
+		sort.Strings(names)
+		for _, name := range names {
+			if av, ok := attrOf(x, name); ok {
+				vars = append(vars, s.variable(name, av))
+			}
+		}
+	}
+	return vars
+}
+
+// scriptGlobals returns the global variables of the script of the frame, without the predeclared names of the box.
+func scriptGlobals(st *DebugState, depth int) starlark.StringDict {
+	if depth < 0 || depth >= st.thread.CallStackDepth() {
+		return nil
+	}
+	if fn, ok := st.thread.DebugFrame(depth).Callable().(*starlark.Function); ok {
+		return fn.Globals()
+	}
+	return nil
+}
@@ -0,0 +1,347 @@
+package starbox_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/PureMature/starbox"
+)
+
+// dapClient is a minimal client of the Debug Adapter Protocol for tests.
+type dapClient struct {
+	t    *testing.T
+	w    io.Writer
+	seq  int
+	msgs chan map[string]interface{}
+	evts []map[string]interface{}
+}
+
+// newDAPClient starts a debug session with the launch function, and returns the client and a channel of the result of the session.
+func newDAPClient(t *testing.T, launch starbox.DAPLaunchFunc) (*dapClient, chan error) {
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	c := &dapClient{t: t, w: inW, msgs: make(chan map[string]interface{}, 100)}
+	go func() {
+		rd := bufio.NewReader(outR)
+		for {
+			var length int
+			for {
+				line, err := rd.ReadString('\n')
+				if err != nil {
+					close(c.msgs)
+					return
+				}
+				if line = strings.TrimSpace(line); line == "" {
+					break
+				}
+				length, _ = strconv.Atoi(strings.TrimPrefix(line, "Content-Length: "))
+			}
+			data := make([]byte, length)
+			if _, err := io.ReadFull(rd, data); err != nil {
+				close(c.msgs)
+				return
+			}
+			var msg map[string]interface{}
+			if err := json.Unmarshal(data, &msg); err != nil {
+				t.Errorf("invalid message: %s", data)
+			}
+			c.msgs <- msg
+		}
+	}()
+	errc := make(chan error, 1)
+	go func() {
+		err := starbox.ServeDAP(inR, outW, launch)
+		outW.Close()
+		errc <- err
+	}()
+	return c, errc
+}
+
+// next returns the next message from the server.
+func (c *dapClient) next() map[string]interface{} {
+	select {
+	case msg, ok := <-c.msgs:
+		if !ok {
+			c.t.Fatal("session ended")
+		}
+		return msg
+	case <-time.After(5 * time.Second):
+		c.t.Fatal("timeout waiting for message")
+	}
+	return nil
+}
+
+// request sends the request, and returns the response, events received before it are kept for event().
+func (c *dapClient) request(cmd string, args interface{}) map[string]interface{} {
+	c.seq++
+	data, _ := json.Marshal(map[string]interface{}{"seq": c.seq, "type": "request", "command": cmd, "arguments": args})
+	fmt.Fprintf(c.w, "Content-Length: %d\r\n\r\n%s", len(data), data)
+	for {
+		msg := c.next()
+		if msg["type"] == "response" && int(msg["request_seq"].(float64)) == c.seq {
+			return msg
+		}
+		c.evts = append(c.evts, msg)
+	}
+}
+
+// body sends the request, and returns the body of the successful response.
+func (c *dapClient) body(cmd string, args interface{}) map[string]interface{} {
+	resp := c.request(cmd, args)
+	if resp["success"] != true {
+		c.t.Fatalf("request %s failed: %v", cmd, resp["message"])
+	}
+	body, _ := resp["body"].(map[string]interface{})
+	return body
+}
+
+// event returns the body of the next event with the name, and skips other events.
+func (c *dapClient) event(name string) map[string]interface{} {
+	for {
+		var msg map[string]interface{}
+		if len(c.evts) > 0 {
+			msg, c.evts = c.evts[0], c.evts[1:]
+		} else {
+			msg = c.next()
+		}
+		if msg["type"] == "event" && msg["event"] == name {
+			body, _ := msg["body"].(map[string]interface{})
+			return body
+		}
+	}
+}
+
+// topFrame returns the name, line and source of the top frame of the paused script.
+func (c *dapClient) topFrame() (string, int, map[string]interface{}) {
+	frames := c.body("stackTrace", map[string]interface{}{"threadId": 1})["stackFrames"].([]interface{})
+	f := frames[0].(map[string]interface{})
+	src, _ := f["source"].(map[string]interface{})
+	return f["name"].(string), int(f["line"].(float64)), src
+}
+
+// variables returns the variables of the reference as name=value pairs, with a trailing + for expandable values.
+func (c *dapClient) variables(ref interface{}) string {
+	var res []string
+	for _, v := range c.body("variables", map[string]interface{}{"variablesReference": ref})["variables"].([]interface{}) {
+		m := v.(map[string]interface{})
+		s := m["name"].(string) + "=" + m["value"].(string)
+		if m["variablesReference"].(float64) > 0 {
+			s += "+"
+		}
+		res = append(res, s)
+	}
+	return strings.Join(res, ",")
+}
+
+// TestServeDAP tests the following:
+// 1. Start a debug session, initialize and launch a script file with a module script added by AddModuleScript().
+// 2. Set a breakpoint in the script file, and check the session stops at it with the path of the script.
+// 3. Step into the module script, check it's mapped to a source reference, and get its content.
+// 4. Check the scopes, variables, expandable values and expressions of the paused frame.
+// 5. Set a breakpoint in the module script by the source reference, and check the session stops at it.
+// 6. Continue to the end, and check the output, exit code and termination of the session.
+func TestServeDAP(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "main.star")
+	if err := os.WriteFile(path, []byte(debugScript), 0600); err != nil {
+		t.Fatal(err)
+	}
+	c, errc := newDAPClient(t, func(args map[string]interface{}) (*starbox.DAPProgram, error) {
+		b := starbox.New("test")
+		b.AddModuleScript("util", debugModule)
+		return starbox.NewDAPProgram(b, args["program"].(string))
+	})
+
+	if body := c.body("initialize", map[string]interface{}{"adapterID": "starbox"}); body["supportsConfigurationDoneRequest"] != true {
+		t.Errorf("unexpected capabilities: %v", body)
+	}
+	c.event("initialized")
+	if resp := c.request("stackTrace", nil); resp["success"] != false {
+		t.Errorf("expect stack trace to fail before launch: %v", resp)
+	}
+	if resp := c.request("unknown", nil); resp["success"] != false || resp["message"] != "unsupported request: unknown" {
+		t.Errorf("unexpected response of unknown request: %v", resp)
+	}
+	c.body("launch", map[string]interface{}{"program": path})
+	bps := c.body("setBreakpoints", map[string]interface{}{
+		"source":      map[string]interface{}{"path": path},
+		"breakpoints": []interface{}{map[string]interface{}{"line": 8}},
+	})["breakpoints"].([]interface{})
+	if len(bps) != 1 || bps[0].(map[string]interface{})["verified"] != true {
+		t.Errorf("unexpected breakpoints: %v", bps)
+	}
+	c.body("configurationDone", nil)
+
+	// stop at the breakpoint in the script file
+	if body := c.event("stopped"); body["reason"] != "breakpoint" {
+		t.Errorf("unexpected stopped event: %v", body)
+	}
+	if name, line, src := c.topFrame(); name != "<toplevel>" || line != 8 || src["path"] != path {
+		t.Errorf("unexpected top frame: %s %d %v", name, line, src)
+	}
+
+	// step into the module script
+	c.body("stepIn", map[string]interface{}{"threadId": 1})
+	if body := c.event("stopped"); body["reason"] != "step" {
+		t.Errorf("unexpected stopped event: %v", body)
+	}
+	name, line, src := c.topFrame()
+	if name != "twice" || line != 3 || src["name"] != "util.star" || src["sourceReference"] != float64(1) {
+		t.Errorf("unexpected top frame: %s %d %v", name, line, src)
+	}
+	if content := c.body("source", map[string]interface{}{"sourceReference": 1})["content"]; content != debugModule {
+		t.Errorf("unexpected source content: %q", content)
+	}
+
+	// inspect the frame
+	scopes := c.body("scopes", map[string]interface{}{"frameId": 1})["scopes"].([]interface{})
+	if len(scopes) != 2 {
+		t.Fatalf("unexpected scopes: %v", scopes)
+	}
+	if got := c.variables(scopes[0].(map[string]interface{})["variablesReference"]); got != "n=3" {
+		t.Errorf("unexpected locals: %s", got)
+	}
+	if got := c.variables(scopes[1].(map[string]interface{})["variablesReference"]); got != "factor=2,twice=<function twice>" {
+		t.Errorf("unexpected globals: %s", got)
+	}
+	if res := c.body("evaluate", map[string]interface{}{"expression": "n * factor", "frameId": 1}); res["result"] != "6" || res["type"] != "int" {
+		t.Errorf("unexpected evaluation: %v", res)
+	}
+	res := c.body("evaluate", map[string]interface{}{"expression": `[n, {"k": (1, 2)}]`, "frameId": 1})
+	if got := c.variables(res["variablesReference"]); got != "[0]=3,[1]={\"k\": (1, 2)}+" {
+		t.Errorf("unexpected list items: %s", got)
+	}
+	if resp := c.request("evaluate", map[string]interface{}{"expression": "missing", "frameId": 1}); resp["success"] != false {
+		t.Errorf("expect evaluation to fail: %v", resp)
+	}
+
+	// stop at the breakpoint in the module script
+	c.body("setBreakpoints", map[string]interface{}{
+		"source":      map[string]interface{}{"name": "util.star", "sourceReference": 1},
+		"breakpoints": []interface{}{map[string]interface{}{"line": 4}},
+	})
+	c.body("setBreakpoints", map[string]interface{}{
+		"source":      map[string]interface{}{"path": path},
+		"breakpoints": []interface{}{},
+	})
+	c.body("continue", map[string]interface{}{"threadId": 1})
+	if body := c.event("stopped"); body["reason"] != "breakpoint" {
+		t.Errorf("unexpected stopped event: %v", body)
+	}
+	if name, line, _ := c.topFrame(); name != "twice" || line != 4 {
+		t.Errorf("unexpected top frame: %s %d", name, line)
+	}
+
+	// run to the end
+	c.body("continue", map[string]interface{}{"threadId": 1})
+	if body := c.event("output"); body["output"] != "6\n" {
+		t.Errorf("unexpected output: %v", body)
+	}
+	if body := c.event("exited"); body["exitCode"] != float64(0) {
+		t.Errorf("unexpected exit: %v", body)
+	}
+	c.event("terminated")
+	c.body("disconnect", nil)
+	if err := <-errc; err != nil {
+		t.Errorf("unexpected session error: %v", err)
+	}
+}
+
+// TestServeDAP_StopOnEntry tests the following:
+// 1. Launch a script with stopOnEntry, and check the session stops at the entry.
+// 2. Disconnect while the script is paused, and check the script is stopped with an error.
+// 3. Check the launch fails for a missing program with the default launch function.
+func TestServeDAP_StopOnEntry(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "main.star")
+	if err := os.WriteFile(path, []byte("x = 1\ny = 2\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	c, errc := newDAPClient(t, nil)
+	c.body("initialize", nil)
+	if resp := c.request("launch", map[string]interface{}{}); resp["success"] != false || !strings.Contains(resp["message"].(string), "program") {
+		t.Errorf("expect launch to fail without program: %v", resp)
+	}
+	c.body("launch", map[string]interface{}{"program": path, "stopOnEntry": true})
+	c.body("configurationDone", nil)
+	if body := c.event("stopped"); body["reason"] != "entry" {
+		t.Errorf("unexpected stopped event: %v", body)
+	}
+	if name, line, src := c.topFrame(); name != "<toplevel>" || line != 1 || src["path"] != path {
+		t.Errorf("unexpected top frame: %s %d %v", name, line, src)
+	}
+	c.body("disconnect", nil)
+	if err := <-errc; err != nil {
+		t.Errorf("unexpected session error: %v", err)
+	}
+}
+
+// TestServeDAP_BreakpointsBeforeLaunch tests the following:
+// 1. Check the initialized event is sent after the response of the initialize request.
+// 2. Set breakpoints before the launch, and check they are pending.
+// 3. Launch the script, and check the breakpoints are verified by the breakpoint events, except the one of an unknown source.
+// 4. Finish the configuration, and check the session stops at the breakpoint.
+func TestServeDAP_BreakpointsBeforeLaunch(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "main.star")
+	if err := os.WriteFile(path, []byte(debugScript), 0600); err != nil {
+		t.Fatal(err)
+	}
+	c, errc := newDAPClient(t, func(args map[string]interface{}) (*starbox.DAPProgram, error) {
+		b := starbox.New("test")
+		b.AddModuleScript("util", debugModule)
+		return starbox.NewDAPProgram(b, args["program"].(string))
+	})
+
+	c.body("initialize", nil)
+	if len(c.evts) != 0 {
+		t.Errorf("unexpected events before the initialize response: %v", c.evts)
+	}
+	if msg := c.next(); msg["type"] != "event" || msg["event"] != "initialized" {
+		t.Errorf("expect initialized event after the response, got %v", msg)
+	}
+
+	bps := c.body("setBreakpoints", map[string]interface{}{
+		"source":      map[string]interface{}{"path": path},
+		"breakpoints": []interface{}{map[string]interface{}{"line": 8}},
+	})["breakpoints"].([]interface{})
+	if len(bps) != 1 || bps[0].(map[string]interface{})["verified"] != false || bps[0].(map[string]interface{})["id"] != float64(1) {
+		t.Errorf("unexpected pending breakpoints: %v", bps)
+	}
+	c.body("setBreakpoints", map[string]interface{}{
+		"source":      map[string]interface{}{"path": filepath.Join(t.TempDir(), "other.star")},
+		"breakpoints": []interface{}{map[string]interface{}{"line": 1}},
+	})
+
+	c.body("launch", map[string]interface{}{"program": path})
+	states := map[float64]interface{}{}
+	for i := 0; i < 2; i++ {
+		b := c.event("breakpoint")["breakpoint"].(map[string]interface{})
+		states[b["id"].(float64)] = b["verified"]
+	}
+	if states[1] != true || states[2] != false {
+		t.Errorf("unexpected states of breakpoints after launch: %v", states)
+	}
+
+	c.body("configurationDone", nil)
+	if body := c.event("stopped"); body["reason"] != "breakpoint" {
+		t.Errorf("unexpected stopped event: %v", body)
+	}
+	if name, line, src := c.topFrame(); name != "<toplevel>" || line != 8 || src["path"] != path {
+		t.Errorf("unexpected top frame: %s %d %v", name, line, src)
+	}
+	c.body("continue", map[string]interface{}{"threadId": 1})
+	c.event("terminated")
+	c.body("disconnect", nil)
+	if err := <-errc; err != nil {
+		t.Errorf("unexpected session error: %v", err)
+	}
+}
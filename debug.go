@@ -0,0 +1,492 @@
+package starbox
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"go.starlark.net/starlark"
+)
+
+const (
+	// debugBreakpointBuiltinName is the name of the builtin to pause the script at the call site when a debugger is attached.
+	debugBreakpointBuiltinName = "breakpoint"
+	// debugDepthKey is the thread local key of the call stack depth of the thread loading a module script.
+	debugDepthKey = "starbox_debug_depth"
+	// mainScriptName is the file name of scripts executed by the box.
+	mainScriptName = "box.star"
+)
+
+// DebugAction is the action to take after the debugger pauses the execution.
+type DebugAction int
+
+const (
+	// DebugContinue resumes the execution until the next breakpoint.
+	DebugContinue DebugAction = iota
+	// DebugStepOver resumes the execution until the next line in the current function or its callers.
+	DebugStepOver
+	// DebugStepInto resumes the execution until the next line, including lines in called functions and loaded module scripts.
+	DebugStepInto
+	// DebugStepOut resumes the execution until the next line after the current function returns.
+	DebugStepOut
+	// DebugStop cancels the execution, and the script fails with an error.
+	DebugStop
+)
+
+// String returns the name of the action.
+func (a DebugAction) String() string {
+	switch a {
+	case DebugContinue:
+		return "continue"
+	case DebugStepOver:
+		return "step over"
+	case DebugStepInto:
+		return "step into"
+	case DebugStepOut:
+		return "step out"
+	case DebugStop:
+		return "stop"
+	default:
+		return "unknown action " + strconv.Itoa(int(a))
+	}
+}
+
+// Reasons of pausing the execution in DebugState.
+const (
+	DebugReasonBreakpoint = "breakpoint" // the execution reaches a line with a breakpoint
+	DebugReasonStep       = "step"       // the execution reaches the next line after a step action
+	DebugReasonPause      = "pause"      // the execution is paused by Pause() or the breakpoint() builtin
+)
+
+// DebugHandler is called in the goroutine of the script when the debugger pauses the execution, and returns the action to resume it.
+// The execution is blocked until it returns, so it can wait for commands from other goroutines, e.g. a debug adapter.
+type DebugHandler func(st *DebugState) DebugAction
+
+// Breakpoint is a line in a script, the file is "box.star" for scripts executed by the box, or the path of module scripts like "lib/util.star".
+type Breakpoint struct {
+	File string // File is the file name of the script.
+	Line int    // Line is the 1-based line number.
+}
+
+// String returns the breakpoint as "file:line".
+func (b Breakpoint) String() string {
+	return b.File + ":" + strconv.Itoa(b.Line)
+}
+
+// ParseBreakpoint parses a breakpoint in the form of "file:line" or "line" for the script executed by the box, and ".star" is appended to the file name if missing, e.g. "util:3" for "util.star:3".
+func ParseBreakpoint(s string) (Breakpoint, error) {
+	file, ln := mainScriptName, strings.TrimSpace(s)
+	if i := strings.LastIndex(ln, ":"); i >= 0 {
+		file, ln = ln[:i], ln[i+1:]
+	}
+	line, err := strconv.Atoi(ln)
+	if err != nil || line <= 0 {
+		return Breakpoint{}, fmt.Errorf("invalid line of breakpoint: %q", s)
+	}
+	file = normalizeScriptName(file)
+	if file == "" {
+		return Breakpoint{}, fmt.Errorf("invalid file of breakpoint: %q", s)
+	}
+	return Breakpoint{File: file, Line: line}, nil
+}
+
+// normalizeScriptName cleans the path of the script, and appends ".star" if missing, like the module loader of Starlet.
+func normalizeScriptName(name string) string {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return ""
+	}
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	if !strings.HasSuffix(name, ".star") {
+		name += ".star"
+	}
+	return name
+}
+
+// StackFrame is a frame of the call stack of the paused execution.
+type StackFrame struct {
+	Name   string // Name is the name of the function, "<toplevel>" for the top level of scripts.
+	File   string // File is the file name of the script, "<builtin>" for builtin functions.
+	Line   int    // Line is the current line in the frame, zero for builtin functions.
+	Column int    // Column is the current column in the frame, zero for builtin functions.
+}
+
+// String returns the frame as "name at file:line".
+func (f StackFrame) String() string {
+	if f.Line <= 0 {
+		return f.Name + " at " + f.File
+	}
+	return fmt.Sprintf("%s at %s:%d", f.Name, f.File, f.Line)
+}
+
+// DebugState is the state of the paused execution passed to DebugHandler, it's only valid until the handler returns.
+type DebugState struct {
+	Reason string // Reason is why the execution is paused, e.g. DebugReasonBreakpoint.
+	File   string // File is the file name of the current line.
+	Line   int    // Line is the current line.
+
+	d      *Debugger
+	thread *starlark.Thread
+}
+
+// Stack returns the call stack of the paused execution, the innermost frame first, and the depth of frames is the index in it.
+func (st *DebugState) Stack() []StackFrame {
+	n := st.thread.CallStackDepth()
+	frames := make([]StackFrame, n)
+	for i := range frames {
+		fr := st.thread.DebugFrame(i)
+		pos := fr.Position()
+		frames[i] = StackFrame{
+			Name:   fr.Callable().Name(),
+			File:   pos.Filename(),
+			Line:   int(pos.Line),
+			Column: int(pos.Col),
+		}
+	}
+	return frames
+}
+
+// Locals returns only the parameters of the function of the frame at the depth, since Starlark doesn't expose the names of other local variables, and parameters shared with nested functions are left out as well, it returns nil for builtin functions or invalid depths.
+func (st *DebugState) Locals(depth int) starlark.StringDict {
+	if depth < 0 || depth >= st.thread.CallStackDepth() {
+		return nil
+	}
+	fr := st.thread.DebugFrame(depth)
+	fn, ok := fr.Callable().(*starlark.Function)
+	if !ok {
+		return nil
+	}
+	res := make(starlark.StringDict, fn.NumParams())
+	for i := 0; i < fn.NumParams(); i++ {
+		// parameters shared with nested functions are kept in cells, which are not accessible
+		if v := fr.Local(i); v != nil && v.Type() != "cell" {
+			name, _ := fn.Param(i)
+			res[name] = v
+		}
+	}
+	return res
+}
+
+// Globals returns the global variables of the script of the frame at the depth, including the predeclared names of the box.
+func (st *DebugState) Globals(depth int) starlark.StringDict {
+	res := make(starlark.StringDict, len(st.d.predeclared))
+	for k, v := range st.d.predeclared {
+		res[k] = v
+	}
+	if depth >= 0 && depth < st.thread.CallStackDepth() {
+		if fn, ok := st.thread.DebugFrame(depth).Callable().(*starlark.Function); ok {
+			for k, v := range fn.Globals() {
+				res[k] = v
+			}
+		}
+	}
+	return res
+}
+
+// Eval evaluates the expression with the globals and the parameters of the frame at the depth, and returns the result, other local variables of the frame are not available like in Locals().
+func (st *DebugState) Eval(expr string, depth int) (starlark.Value, error) {
+	if depth < 0 || depth >= st.thread.CallStackDepth() {
+		return nil, fmt.Errorf("invalid frame depth: %d", depth)
+	}
+	env := st.Globals(depth)
+	for k, v := range st.Locals(depth) {
+		env[k] = v
+	}
+	return starlark.EvalOptions(scriptFileOptions(), st.thread, "<debug>", expr, env)
+}
+
+// debugMode is the stepping mode of the debugger.
+type debugMode int32
+
+const (
+	debugModeRun debugMode = iota
+	debugModeStepOver
+	debugModeStepInto
+	debugModeStepOut
+)
+
+// debugLine is a line executed in a frame of the call stack.
+type debugLine struct {
+	file string
+	line int
+}
+
+// Debugger pauses the execution of scripts at breakpoints or after steps, and calls the handler to inspect the state and decide how to resume.
+// It's attached to a box by SetDebugger() before execution, and breakpoints can be changed at any time, even while the script is running.
+type Debugger struct {
+	handler DebugHandler
+
+	mu          sync.Mutex
+	breakpoints map[Breakpoint]struct{}
+	sources     map[string][]string
+	mode        debugMode
+	stepDepth   int
+	lines       []debugLine // the last line of each frame of the call stack
+	pauseReason string
+
+	// flags to skip the checks of each step quickly
+	numBreaks int32
+	stepping  int32
+	pausing   int32
+	busy      int32
+
+	// the step limit of the thread of the box, since the debugger takes over the limit of the thread
+	maxSteps uint64
+
+	// set when attached to the thread of a box
+	predeclared starlark.StringDict
+}
+
+// NewDebugger creates a debugger which calls the handler when the execution pauses, a nil handler always continues the execution.
+func NewDebugger(handler DebugHandler) *Debugger {
+	return &Debugger{
+		handler:     handler,
+		breakpoints: make(map[Breakpoint]struct{}),
+		sources:     make(map[string][]string),
+	}
+}
+
+// SetBreakpoint adds a breakpoint at the line of the file, the file name is normalized like ParseBreakpoint().
+func (d *Debugger) SetBreakpoint(file string, line int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.breakpoints[Breakpoint{File: normalizeScriptName(file), Line: line}] = struct{}{}
+	atomic.StoreInt32(&d.numBreaks, int32(len(d.breakpoints)))
+}
+
+// ClearBreakpoint removes the breakpoint at the line of the file.
+func (d *Debugger) ClearBreakpoint(file string, line int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	delete(d.breakpoints, Breakpoint{File: normalizeScriptName(file), Line: line})
+	atomic.StoreInt32(&d.numBreaks, int32(len(d.breakpoints)))
+}
+
+// ClearAllBreakpoints removes all the breakpoints.
+func (d *Debugger) ClearAllBreakpoints() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.breakpoints = make(map[Breakpoint]struct{})
+	atomic.StoreInt32(&d.numBreaks, 0)
+}
+
+// Breakpoints returns the breakpoints sorted by file and line.
+func (d *Debugger) Breakpoints() []Breakpoint {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	res := make([]Breakpoint, 0, len(d.breakpoints))
+	for b := range d.breakpoints {
+		res = append(res, b)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].File != res[j].File {
+			return res[i].File < res[j].File
+		}
+		return res[i].Line < res[j].Line
+	})
+	return res
+}
+
+// Pause requests to pause the running script at the next line, it can be called from any goroutine.
+func (d *Debugger) Pause() {
+	d.requestPause(DebugReasonPause)
+}
+
+// SourceLine returns the line of the script executed by the box or a module script loaded while the debugger is attached.
+func (d *Debugger) SourceLine(file string, line int) (string, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	lines := d.sources[file]
+	if line <= 0 || line > len(lines) {
+		return "", false
+	}
+	return lines[line-1], true
+}
+
+// Source returns the content of the script executed by the box or a module script loaded while the debugger is attached, with line endings normalized.
+func (d *Debugger) Source(file string) (string, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	lines, ok := d.sources[file]
+	return strings.Join(lines, "\n"), ok
+}
+
+// setMaxSteps sets the number of steps of the thread of the box to fail the execution like the step limit of threads, zero means no limit.
+func (d *Debugger) setMaxSteps(max uint64) {
+	atomic.StoreUint64(&d.maxSteps, max)
+}
+
+// requestPause makes the debugger pause at the next line with the reason.
+func (d *Debugger) requestPause(reason string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.pauseReason = reason
+	atomic.StoreInt32(&d.pausing, 1)
+}
+
+// setSource keeps the lines of the script for SourceLine().
+func (d *Debugger) setSource(file string, src []byte) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.sources[file] = strings.Split(strings.ReplaceAll(string(src), "\r\n", "\n"), "\n")
+}
+
+// reset clears the stepping state before each execution.
+func (d *Debugger) reset() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.mode = debugModeRun
+	d.lines = nil
+	atomic.StoreInt32(&d.stepping, 0)
+}
+
+// attach installs the hooks into the thread of the box, and into the threads loading module scripts by the loader of the box to debug them.
+func (d *Debugger) attach(thread *starlark.Thread, loader *moduleLoader) {
+	d.predeclared = loader.predeclared
+	d.hook(thread)
+	loader.hook = d.hookLoad
+	predeclared := loader.predeclared
+	if _, ok := predeclared[debugBreakpointBuiltinName]; !ok {
+		predeclared[debugBreakpointBuiltinName] = starlark.NewBuiltin(debugBreakpointBuiltinName, func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 0); err != nil {
+				return nil, err
+			}
+			d.requestPause(DebugReasonPause)
+			return starlark.None, nil
+		})
+	}
+}
+
+// hook makes the thread call the debugger before each step.
+func (d *Debugger) hook(thread *starlark.Thread) {
+	thread.OnMaxSteps = d.onStep
+	thread.SetMaxExecutionSteps(1)
+}
+
+// hookLoad keeps the source of the module script, and hooks the thread loading it, which counts the depth from the loading thread.
+func (d *Debugger) hookLoad(parent, child *starlark.Thread, name string, src []byte) {
+	d.setSource(name, src)
+	child.SetLocal(debugDepthKey, d.depthOf(parent))
+	d.hook(child)
+}
+
+// depthOf returns the call stack depth of the thread, including the depth of threads loading the module script.
+func (d *Debugger) depthOf(thread *starlark.Thread) int {
+	depth := thread.CallStackDepth()
+	if base, ok := thread.Local(debugDepthKey).(int); ok {
+		depth += base
+	}
+	return depth
+}
+
+// onStep is called before each step of the execution, it pauses the execution when a new line is reached and a breakpoint or the stepping mode matches.
+func (d *Debugger) onStep(thread *starlark.Thread) {
+	// threads loading module scripts have the depth key, and they have no step limit like without the debugger
+	if max := atomic.LoadUint64(&d.maxSteps); max > 0 && thread.ExecutionSteps() >= max && thread.Local(debugDepthKey) == nil {
+		thread.Cancel("too many steps")
+		return
+	}
+	if atomic.LoadInt32(&d.busy) != 0 {
+		return
+	}
+	if atomic.LoadInt32(&d.numBreaks) == 0 && atomic.LoadInt32(&d.stepping) == 0 && atomic.LoadInt32(&d.pausing) == 0 {
+		return
+	}
+
+	// the position is of the last step, so a line is reached after its first step, which usually only loads a value
+	pos := thread.DebugFrame(0).Position()
+	cur := debugLine{file: pos.Filename(), line: int(pos.Line)}
+	depth := d.depthOf(thread)
+
+	d.mu.Lock()
+	var reason string
+	switch newLine := d.enterLine(depth, cur); {
+	case atomic.LoadInt32(&d.pausing) != 0:
+		reason = d.pauseReason
+	case !newLine:
+	case d.mode == debugModeStepInto,
+		d.mode == debugModeStepOver && depth <= d.stepDepth,
+		d.mode == debugModeStepOut && depth < d.stepDepth:
+		reason = DebugReasonStep
+	default:
+		if _, ok := d.breakpoints[Breakpoint{File: cur.file, Line: cur.line}]; ok {
+			reason = DebugReasonBreakpoint
+		}
+	}
+	d.mu.Unlock()
+
+	if reason != "" {
+		d.pause(thread, reason, cur, depth)
+	}
+}
+
+// enterLine records the line of the frame at the depth, and returns true if the frame reaches a new line, it must be called with the lock held.
+func (d *Debugger) enterLine(depth int, cur debugLine) bool {
+	if depth <= 0 {
+		return false
+	}
+	if depth > len(d.lines) {
+		// a new frame is entered, frames of builtins in between have no lines
+		for len(d.lines) < depth-1 {
+			d.lines = append(d.lines, debugLine{})
+		}
+		d.lines = append(d.lines, cur)
+		return true
+	}
+	// frames deeper than it have returned
+	d.lines = d.lines[:depth]
+	if d.lines[depth-1] == cur {
+		return false
+	}
+	d.lines[depth-1] = cur
+	return true
+}
+
+// pause calls the handler with the state, and applies the action it returns.
+func (d *Debugger) pause(thread *starlark.Thread, reason string, cur debugLine, depth int) {
+	atomic.StoreInt32(&d.pausing, 0)
+	action := DebugContinue
+	if d.handler != nil {
+		atomic.StoreInt32(&d.busy, 1)
+		func() {
+			defer atomic.StoreInt32(&d.busy, 0)
+			action = d.handler(&DebugState{Reason: reason, File: cur.file, Line: cur.line, d: d, thread: thread})
+		}()
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.stepDepth = depth
+	switch action {
+	case DebugStepOver:
+		d.mode = debugModeStepOver
+	case DebugStepInto:
+		d.mode = debugModeStepInto
+	case DebugStepOut:
+		d.mode = debugModeStepOut
+	case DebugStop:
+		d.mode = debugModeRun
+		thread.Cancel("stopped by debugger")
+	default:
+		d.mode = debugModeRun
+	}
+	if d.mode == debugModeRun {
+		atomic.StoreInt32(&d.stepping, 0)
+	} else {
+		atomic.StoreInt32(&d.stepping, 1)
+	}
+}
@@ -0,0 +1,330 @@
+package starbox_test
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/PureMature/starbox"
+	"go.starlark.net/starlark"
+)
+
+// debugScript is the script for debugger tests, the line numbers matter.
+var debugScript = HereDoc(`
+	load("util", "twice")
+	def add(a, b):
+		c = a + b
+		return c
+
+	x = 1
+	y = add(x, 2)
+	z = twice(y)
+	print(z)
+`)
+
+// debugModule is the module script for debugger tests, the line numbers matter.
+var debugModule = HereDoc(`
+	factor = 2
+	def twice(n):
+		res = n * factor
+		return res
+`)
+
+// newDebugBox creates a box with the module script and the debugger.
+func newDebugBox(d *starbox.Debugger) *starbox.Starbox {
+	b := starbox.New("test")
+	b.AddModuleScript("util", debugModule)
+	b.SetPrintFunc(func(_ *starlark.Thread, msg string) {})
+	b.SetDebugger(d)
+	return b
+}
+
+// TestDebugger tests the following:
+// 1. Create a debugger with a handler which records the states and returns the actions in order.
+// 2. Set a breakpoint in a function of the script, and run the script.
+// 3. Check the locations, call stacks, locals and evaluated expressions of each pause after stepping over, out and into.
+// 4. Check the script finishes with the correct output after continuing.
+func TestDebugger(t *testing.T) {
+	var (
+		logs    []string
+		actions = []starbox.DebugAction{starbox.DebugStepOver, starbox.DebugStepOut, starbox.DebugStepInto, starbox.DebugContinue}
+	)
+	d := starbox.NewDebugger(func(st *starbox.DebugState) starbox.DebugAction {
+		var names []string
+		for _, f := range st.Stack() {
+			names = append(names, f.Name)
+		}
+		var locals []string
+		for k, v := range st.Locals(0) {
+			locals = append(locals, k+"="+v.String())
+		}
+		sort.Strings(locals)
+		logs = append(logs, fmt.Sprintf("%s:%d %s [%s] {%s}", st.File, st.Line, st.Reason, strings.Join(names, ","), strings.Join(locals, ",")))
+		if v, err := st.Eval("x * 100", len(names)-1); err != nil {
+			t.Errorf("eval: %v", err)
+		} else if v.String() != "100" {
+			t.Errorf("unexpected eval result: %v", v)
+		}
+		act := actions[0]
+		actions = actions[1:]
+		return act
+	})
+	d.SetBreakpoint("box.star", 3)
+	b := newDebugBox(d)
+
+	out, err := b.Run(debugScript)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out["z"] != int64(6) {
+		t.Errorf("unexpected output: %v", out)
+	}
+	expected := []string{
+		"box.star:3 breakpoint [add,<toplevel>] {a=1,b=2}",
+		"box.star:4 step [add,<toplevel>] {a=1,b=2}",
+		"box.star:8 step [<toplevel>] {}",
+		"util.star:3 step [twice,<toplevel>] {n=3}",
+	}
+	if got := strings.Join(logs, "\n"); got != strings.Join(expected, "\n") {
+		t.Errorf("unexpected pauses:\n%s\nwant:\n%s", got, strings.Join(expected, "\n"))
+	}
+}
+
+// TestDebugger_ModuleAndStop tests the following:
+// 1. Set breakpoints at the top level and in a function of the module script, and parse a breakpoint.
+// 2. Check the execution pauses while loading the module and when the function is called.
+// 3. Stop the execution at the second pause, and check the script fails.
+func TestDebugger_ModuleAndStop(t *testing.T) {
+	var logs []string
+	d := starbox.NewDebugger(func(st *starbox.DebugState) starbox.DebugAction {
+		logs = append(logs, fmt.Sprintf("%s:%d %s", st.File, st.Line, st.Reason))
+		if len(logs) > 1 {
+			return starbox.DebugStop
+		}
+		return starbox.DebugContinue
+	})
+	bp, err := starbox.ParseBreakpoint("util:3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.SetBreakpoint(bp.File, bp.Line)
+	d.SetBreakpoint("./util.star", 1)
+	d.SetBreakpoint("box.star", 100)
+	d.ClearBreakpoint("box.star", 100)
+	if got := fmt.Sprint(d.Breakpoints()); got != "[util.star:1 util.star:3]" {
+		t.Errorf("unexpected breakpoints: %s", got)
+	}
+	b := newDebugBox(d)
+
+	if _, err := b.Run(debugScript); err == nil || !strings.Contains(err.Error(), "stopped by debugger") {
+		t.Errorf("expect error of stopped execution, got %v", err)
+	}
+	if got := strings.Join(logs, ","); got != "util.star:1 breakpoint,util.star:3 breakpoint" {
+		t.Errorf("unexpected pauses: %s", got)
+	}
+}
+
+// TestDebugger_Load tests the following:
+// 1. Load the same module script from multiple threads concurrently with the debugger attached, and check it's loaded once without false cycles.
+// 2. Change the module file and run again, and check the loaded module is kept like without the debugger.
+// 3. Check cycles in the load graph are still reported.
+func TestDebugger_Load(t *testing.T) {
+	dir := t.TempDir()
+	writeGoldenFiles(t, dir, map[string]string{
+		"util.star":  "print('loading')\nfactor = 2\n",
+		"ping.star":  "load('pong', 'y')\nx = 1\n",
+		"pong.star":  "load('ping', 'x')\ny = 2\n",
+		"multi.star": "load('util', 'factor')\nf = factor\n",
+	})
+
+	var (
+		mu     sync.Mutex
+		prints []string
+	)
+	b := starbox.New("test")
+	b.SetFS(os.DirFS(dir))
+	b.SetPrintFunc(func(_ *starlark.Thread, msg string) {
+		mu.Lock()
+		defer mu.Unlock()
+		prints = append(prints, msg)
+	})
+	b.AddBuiltin("load_all", func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var module string
+		if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "module", &module); err != nil {
+			return nil, err
+		}
+		var (
+			wg   sync.WaitGroup
+			res  = make([]starlark.Value, 4)
+			errs = make([]error, len(res))
+		)
+		for i := range res {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				th := &starlark.Thread{Name: "worker", Print: thread.Print, Load: thread.Load}
+				g, err := th.Load(th, module)
+				if err == nil {
+					res[i] = g["f"]
+				}
+				errs[i] = err
+			}(i)
+		}
+		wg.Wait()
+		for _, err := range errs {
+			if err != nil {
+				return nil, err
+			}
+		}
+		return starlark.NewList(res), nil
+	})
+	b.SetDebugger(starbox.NewDebugger(nil))
+
+	out, err := b.Run(`r = load_all("multi")`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(out["r"]); got != "[2 2 2 2]" || len(prints) != 1 {
+		t.Errorf("unexpected result %s, prints %v", got, prints)
+	}
+
+	// the loaded modules are kept across runs like without the debugger
+	writeGoldenFiles(t, dir, map[string]string{"util.star": "factor = 3\n"})
+	if out, err = b.Run("load('util', 'factor')\nv = factor"); err != nil || out["v"] != int64(2) || len(prints) != 1 {
+		t.Errorf("expect module kept, got %v %v, prints %v", out, err, prints)
+	}
+
+	if _, err := b.Run(`load("ping", "x")`); err == nil || !strings.Contains(err.Error(), "cycle in load graph") {
+		t.Errorf("expect cycle error, got %v", err)
+	}
+}
+
+// TestDebugger_MaxSteps tests the following:
+// 1. Create boxes with the step limit, with and without a debugger pausing at a breakpoint.
+// 2. Run a short script several times, and check each run has the full budget of steps.
+// 3. Run a long loop, and check it fails with too many steps, with or without the debugger.
+func TestDebugger_MaxSteps(t *testing.T) {
+	for _, debug := range []bool{false, true} {
+		b := starbox.New("test")
+		b.SetMaxSteps(5000)
+		pauses := 0
+		if debug {
+			d := starbox.NewDebugger(func(st *starbox.DebugState) starbox.DebugAction {
+				pauses++
+				return starbox.DebugContinue
+			})
+			d.SetBreakpoint("box.star", 1)
+			b.SetDebugger(d)
+		}
+
+		for i := 0; i < 3; i++ {
+			if out, err := b.Run("x = len([i for i in range(200) if i % 2])"); err != nil || out["x"] != int64(100) {
+				t.Errorf("debug %v: unexpected result of run %d: %v %v", debug, i, out, err)
+			}
+		}
+		if debug && pauses != 3 {
+			t.Errorf("expect 3 pauses, got %d", pauses)
+		}
+
+		_, err := b.Run(HereDoc(`
+			def loop():
+				n = 0
+				for i in range(100000):
+					n += i
+				return n
+			x = loop()
+		`))
+		if err == nil || !strings.Contains(err.Error(), "too many steps") {
+			t.Errorf("debug %v: expect too many steps, got %v", debug, err)
+		}
+	}
+}
+
+// TestParseBreakpoint tests valid and invalid breakpoints.
+func TestParseBreakpoint(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    string
+		wantErr bool
+	}{
+		{"12", "box.star:12", false},
+		{"lib/util:3", "lib/util.star:3", false},
+		{"/lib/../util.star:7", "util.star:7", false},
+		{"util:0", "", true},
+		{"util:x", "", true},
+		{":3", "", true},
+	}
+	for _, tt := range tests {
+		b, err := starbox.ParseBreakpoint(tt.s)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseBreakpoint(%q) error = %v, wantErr %v", tt.s, err, tt.wantErr)
+			continue
+		}
+		if err == nil && b.String() != tt.want {
+			t.Errorf("ParseBreakpoint(%q) = %s, want %s", tt.s, b, tt.want)
+		}
+	}
+}
+
+// TestDebugREPL tests the following:
+// 1. Attach a debugger with the debug REPL, and pause by the breakpoint() builtin in a function called in a REPL session.
+// 2. Check the commands of the debug REPL: stack, frame, locals, list, print, expressions and breakpoints.
+// 3. Check the execution continues after the commands, and the result is printed by the REPL session.
+func TestDebugREPL(t *testing.T) {
+	cmds := HereDoc(`
+		bt
+		locals
+		p n * 2
+		n+0
+		list
+		frame 1
+		frame 9
+		break util:4
+		b
+		c
+		res
+		c
+	`)
+	var dout bytes.Buffer
+	d := starbox.NewDebugger(starbox.DebugREPL(strings.NewReader(cmds), &dout))
+	b := newDebugBox(d)
+	if _, err := b.Run(HereDoc(`
+		load("util", "twice")
+		def check(n):
+			breakpoint()
+			return twice(n)
+	`)); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := b.REPLWith(strings.NewReader("check(21)\n"), &out, starbox.REPLPrompt("", "")); err != nil {
+		t.Fatal(err)
+	}
+	if got := out.String(); got != "42\n" {
+		t.Errorf("unexpected REPL output: %q", got)
+	}
+	got := dout.String()
+	for _, s := range []string{
+		"Paused at box.star:3 (pause)\n    3  \tbreakpoint()\n",
+		"(debug) * #0 check at box.star:3\n  #1 <expr> at <stdin>:1\n",
+		"(debug) n = 21\n",
+		"(debug) 42\n",
+		"(debug) 21\n",
+		"(debug)     1  load(\"util\", \"twice\")\n    2  def check(n):\n>   3  \tbreakpoint()\n    4  \treturn twice(n)\n",
+		"(debug) #1 <expr> at <stdin>:1\n",
+		`(debug) invalid frame: "9"`,
+		"(debug) breakpoint at util.star:4\n",
+		"(debug) util.star:4\n",
+		"Paused at util.star:4 (breakpoint)\n    4  \treturn res\n",
+		"(debug) 42\n(debug) ",
+	} {
+		if !strings.Contains(got, s) {
+			t.Errorf("expect debug output contains %q, got:\n%s", s, got)
+		}
+	}
+}
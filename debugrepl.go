@@ -0,0 +1,149 @@
+package starbox
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultDebugPrompt is the prompt of the debug REPL created by DebugREPL().
+const DefaultDebugPrompt = "(debug) "
+
+// debugREPLHelp is the usage of commands in the debug REPL.
+const debugREPLHelp = `Commands:
+  c, continue        resume until the next breakpoint
+  n, next            step over to the next line
+  s, step            step into the next line, including called functions
+  o, out             step out of the current function
+  q, quit            stop the execution
+  bt, stack          show the call stack
+  f, frame N         select the frame at depth N for parameters and expressions
+  l, locals          show the parameters of the selected frame
+  list               show the source around the current line
+  b, break FILE:LINE add a breakpoint, or list breakpoints without argument
+  clear FILE:LINE    remove a breakpoint
+  p, print EXPR      evaluate the expression in the selected frame
+  h, help            show this help
+Other input is evaluated as an expression in the selected frame.`
+
+// DebugREPL returns a handler which starts a debug REPL on the streams when the execution pauses, to inspect the call stack, parameters of functions and expressions, and step or resume the execution.
+// The input is shared by all pauses, and the execution continues when the input ends.
+func DebugREPL(in io.Reader, out io.Writer) DebugHandler {
+	rd := &streamReader{in: bufio.NewReader(in), out: out}
+	return func(st *DebugState) DebugAction {
+		return debugREPL(st, rd, out)
+	}
+}
+
+// debugREPL runs the commands of the debug REPL until the execution is resumed.
+func debugREPL(st *DebugState, rd lineReader, out io.Writer) DebugAction {
+	d := st.d
+	fmt.Fprintf(out, "Paused at %s:%d (%s)\n", st.File, st.Line, st.Reason)
+	if src, ok := d.SourceLine(st.File, st.Line); ok {
+		fmt.Fprintf(out, "%5d  %s\n", st.Line, src)
+	}
+
+	frame := 0
+	for {
+		line, err := rd.ReadLine(DefaultDebugPrompt)
+		if err != nil {
+			return DebugContinue
+		}
+		cmd, arg := splitCommand(string(line))
+		switch cmd {
+		case "":
+			continue
+		case "c", "continue":
+			return DebugContinue
+		case "n", "next":
+			return DebugStepOver
+		case "s", "step":
+			return DebugStepInto
+		case "o", "out":
+			return DebugStepOut
+		case "q", "quit":
+			return DebugStop
+		case "h", "help":
+			fmt.Fprintln(out, debugREPLHelp)
+		case "bt", "stack":
+			for i, f := range st.Stack() {
+				mark := " "
+				if i == frame {
+					mark = "*"
+				}
+				fmt.Fprintf(out, "%s #%d %s\n", mark, i, f)
+			}
+		case "f", "frame":
+			n, err := strconv.Atoi(arg)
+			if err != nil || n < 0 || n >= len(st.Stack()) {
+				fmt.Fprintf(out, "invalid frame: %q\n", arg)
+				continue
+			}
+			frame = n
+			fmt.Fprintf(out, "#%d %s\n", n, st.Stack()[n])
+		case "l", "locals":
+			locals := st.Locals(frame)
+			names := make([]string, 0, len(locals))
+			for name := range locals {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				fmt.Fprintf(out, "%s = %s\n", name, locals[name])
+			}
+		case "list":
+			f := st.Stack()[frame]
+			for i := f.Line - 3; i <= f.Line+3; i++ {
+				if src, ok := d.SourceLine(f.File, i); ok {
+					mark := " "
+					if i == f.Line {
+						mark = ">"
+					}
+					fmt.Fprintf(out, "%s%4d  %s\n", mark, i, src)
+				}
+			}
+		case "b", "break":
+			if arg == "" {
+				for _, b := range d.Breakpoints() {
+					fmt.Fprintln(out, b)
+				}
+				continue
+			}
+			if b, err := ParseBreakpoint(arg); err != nil {
+				fmt.Fprintln(out, err)
+			} else {
+				d.SetBreakpoint(b.File, b.Line)
+				fmt.Fprintf(out, "breakpoint at %s\n", b)
+			}
+		case "clear":
+			if b, err := ParseBreakpoint(arg); err != nil {
+				fmt.Fprintln(out, err)
+			} else {
+				d.ClearBreakpoint(b.File, b.Line)
+			}
+		case "p", "print":
+			printEval(st, arg, frame, out)
+		default:
+			printEval(st, strings.TrimSpace(string(line)), frame, out)
+		}
+	}
+}
+
+// splitCommand splits the input line into the command and the argument, the command is empty for blank lines.
+func splitCommand(line string) (string, string) {
+	line = strings.TrimSpace(line)
+	cmd, arg, _ := strings.Cut(line, " ")
+	return cmd, strings.TrimSpace(arg)
+}
+
+// printEval evaluates the expression in the frame, and prints the result or error.
+func printEval(st *DebugState, expr string, frame int, out io.Writer) {
+	if v, err := st.Eval(expr, frame); err != nil {
+		fmt.Fprintln(out, err)
+	} else {
+		fmt.Fprintln(out, v)
+	}
+}
@@ -1,11 +1,14 @@
 package starbox
 
 import (
+	"context"
+	"fmt"
+	"os"
 	"sort"
 	"time"
 
 	"github.com/1set/starlet"
-	"github.com/psanford/memfs"
+	"go.starlark.net/syntax"
 )
 
 // Run executes a script and returns the converted output.
@@ -21,7 +24,7 @@ func (s *Starbox) Run(script string) (starlet.StringAnyMap, error) {
 	// run
 	s.hasExec = true
 	s.execTimes++
-	return s.mac.Run()
+	return s.profileRun(s.mac.Run)
 }
 
 // RunTimeout executes a script and returns the converted output.
@@ -37,24 +40,42 @@ func (s *Starbox) RunTimeout(script string, timeout time.Duration) (starlet.Stri
 	// run
 	s.hasExec = true
 	s.execTimes++
-	return s.mac.RunWithTimeout(timeout, nil)
+	return s.profileRun(func() (starlet.StringAnyMap, error) {
+		return s.mac.RunWithTimeout(timeout, nil)
+	})
 }
 
-// REPL starts a REPL session.
-func (s *Starbox) REPL() error {
+// RunContext executes a script within the context and returns the converted output, the execution is cancelled when the context is done.
+func (s *Starbox) RunContext(ctx context.Context, script string) (starlet.StringAnyMap, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	// prepare environment -- no need to set script content
-	if err := s.prepareEnv(""); err != nil {
-		return err
+	// prepare environment
+	if err := s.prepareEnv(script); err != nil {
+		return nil, err
 	}
 
 	// run
 	s.hasExec = true
 	s.execTimes++
-	s.mac.REPL()
-	return nil
+	return s.profileRun(func() (starlet.StringAnyMap, error) {
+		return s.mac.RunWithContext(ctx, nil)
+	})
+}
+
+// REPL starts a REPL session on the terminal with line editing, tab completion of globals, modules and members, and the help() builtin.
+// The input history is kept per box name only if REPLHistoryDir() sets the directory, e.g. DefaultREPLHistoryDir(), and REPLTranscript() records the session.
+func (s *Starbox) REPL(opts ...REPLOption) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// prepare environment and thread
+	if err := s.warmUp(); err != nil {
+		return err
+	}
+
+	// run
+	return s.terminalREPL(opts...)
 }
 
 // RunInspect executes a script and then REPL with result and returns the converted output.
@@ -70,10 +91,10 @@ func (s *Starbox) RunInspect(script string) (starlet.StringAnyMap, error) {
 	// run script
 	s.hasExec = true
 	s.execTimes++
-	out, err := s.mac.Run()
+	out, err := s.profileRun(s.mac.Run)
 
 	// repl
-	s.mac.REPL()
+	s.inspect()
 	return out, err
 }
 
@@ -94,15 +115,22 @@ func (s *Starbox) RunInspectIf(script string, cond InspectCondFunc) (starlet.Str
 	// run script
 	s.hasExec = true
 	s.execTimes++
-	out, err := s.mac.Run()
+	out, err := s.profileRun(s.mac.Run)
 
 	// repl
 	if cond(out, err) {
-		s.mac.REPL()
+		s.inspect()
 	}
 	return out, err
 }
 
+// inspect starts a REPL session on the terminal after execution, and prints the error if it fails to start.
+func (s *Starbox) inspect() {
+	if err := s.terminalREPL(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+}
+
 // Reset creates an new Starlet machine and keeps the settings.
 func (s *Starbox) Reset() {
 	s.mu.Lock()
@@ -110,26 +138,57 @@ func (s *Starbox) Reset() {
 
 	//s.mac.Reset()
 	s.mac = newStarMachine(s.name)
+	s.loader = nil
 	s.hasExec = false
 }
 
 func (s *Starbox) prepareEnv(script string) (err error) {
+	// reset the debugger for each run, and keep the script for source lines
+	if s.debugger != nil {
+		s.debugger.reset()
+		if script != "" {
+			s.debugger.setSource(mainScriptName, []byte(script))
+		}
+	}
+
+	// instrument the script for coverage
+	src := []byte(script)
+	if s.coverage != nil {
+		name := s.coverName
+		if name == "" {
+			name = mainScriptName
+		}
+		src = s.coverage.instrument(name, src)
+	}
+
 	// if it's not the first run, set the script content only
 	if s.hasExec {
-		s.mac.SetScriptContent([]byte(script))
+		s.mac.SetScriptContent(src)
+		s.limitSteps()
 		return nil
 	}
 
-	// set custom tag and print function
+	// set custom tag, print function and script cache
 	if s.structTag != "" {
 		s.mac.SetCustomTag(s.structTag)
 	}
 	if s.printFunc != nil {
 		s.mac.SetPrintFunc(s.printFunc)
 	}
+	if s.scriptCache != nil {
+		s.mac.SetScriptCache(s.scriptCache)
+	}
 
-	// set variables
-	s.mac.SetGlobals(s.globals)
+	// set variables, and the builtin to count statements for coverage
+	globals := s.globals
+	if s.coverage != nil {
+		globals = make(starlet.StringAnyMap, len(s.globals)+1)
+		for k, v := range s.globals {
+			globals[k] = v
+		}
+		globals[coverBuiltinName] = s.coverage.builtin()
+	}
+	s.mac.SetGlobals(globals)
 
 	// extract module loaders
 	preMods, lazyMods, err := s.extractModLoads()
@@ -143,25 +202,48 @@ func (s *Starbox) prepareEnv(script string) (err error) {
 		s.mac.SetLazyloadModules(lazyMods)
 	}
 
-	// prepare script modules
-	if len(s.scriptMods) > 0 && s.modFS == nil {
-		rootFS := memfs.New()
-		for fp, scr := range s.scriptMods {
-			// TODO: support directory/file.star later
-			if err := rootFS.WriteFile(fp, []byte(scr), 0644); err != nil {
-				return err
-			}
-		}
-		s.modFS = rootFS
+	// prepare script modules and filesystems
+	modFS, err := s.buildModuleFS()
+	if err != nil {
+		return err
+	}
+
+	if s.coverage != nil && modFS != nil {
+		modFS = &coverFS{fsys: modFS, c: s.coverage}
 	}
 
 	// set script
-	s.mac.SetScript("box.star", []byte(script), s.modFS)
+	s.mac.SetScript(mainScriptName, src, modFS)
+
+	// load module scripts with the script cache, and attach the debugger to the threads, the thread is also prepared for the step limit
+	if (s.scriptCache != nil && modFS != nil) || s.debugger != nil || s.maxSteps > 0 {
+		if err := s.attachLoader(src, lazyMods, modFS); err != nil {
+			return err
+		}
+	}
+	if s.debugger != nil {
+		s.debugger.attach(s.mac.GetStarlarkThread(), s.loader)
+	}
+	s.limitSteps()
 
 	// all is done
 	return nil
 }
 
+// limitSteps sets the step limit of the thread for the next execution, or passes it to the debugger which takes over the limit of the thread to pause at each step.
+func (s *Starbox) limitSteps() {
+	if s.maxSteps == 0 {
+		return
+	}
+	thread := s.mac.GetStarlarkThread()
+	max := thread.ExecutionSteps() + s.maxSteps
+	if s.debugger != nil {
+		s.debugger.setMaxSteps(max)
+	} else {
+		thread.SetMaxExecutionSteps(max)
+	}
+}
+
 func (s *Starbox) extractModLoads() (preMods starlet.ModuleLoaderList, lazyMods starlet.ModuleLoaderMap, err error) {
 	// get modules by name: local module set + individual names for starlet
 	var modNames []string
@@ -212,10 +294,31 @@ func (s *Starbox) extractModLoads() (preMods starlet.ModuleLoaderList, lazyMods
 		}
 	}
 
+	// replace mocked builtins and module members with fakes
+	if s.mock != nil {
+		preMods, lazyMods = s.mock.wrapLoaders(preMods, lazyMods)
+	}
+
 	// result
 	return preMods, lazyMods, nil
 }
 
+// CheckSyntax parses the script with the same options as execution, and returns the syntax error if any, it doesn't resolve names or run the script.
+func CheckSyntax(filename string, script []byte) error {
+	_, err := scriptFileOptions().Parse(filename, script, 0)
+	return err
+}
+
+// scriptFileOptions returns the file options for parsing scripts, it's the same as the options used by the Starlet machine.
+func scriptFileOptions() *syntax.FileOptions {
+	return &syntax.FileOptions{
+		Set:             true,
+		GlobalReassign:  true,
+		TopLevelControl: true,
+		While:           true,
+	}
+}
+
 func uniqueStrings(ss []string) []string {
 	if len(ss) < 2 {
 		return ss
@@ -0,0 +1,184 @@
+package starbox
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+
+	"github.com/psanford/memfs"
+)
+
+// LayeredFS is a read-only filesystem stacking multiple fs.FS layers, files in upper layers shadow files with the same path in lower layers.
+// Directories are merged across all layers when listed via ReadDir() or the directory files opened by Open(), so fs.WalkDir() and fs.Glob() see all the layers.
+type LayeredFS struct {
+	layers []fs.FS
+}
+
+var (
+	_ fs.FS        = (*LayeredFS)(nil)
+	_ fs.ReadDirFS = (*LayeredFS)(nil)
+	_ fs.StatFS    = (*LayeredFS)(nil)
+)
+
+// NewLayeredFS creates a layered filesystem with the given layers, the first layer is the top one and shadows the rest. Nil layers are ignored.
+func NewLayeredFS(layers ...fs.FS) *LayeredFS {
+	l := &LayeredFS{}
+	for _, f := range layers {
+		if f != nil {
+			l.layers = append(l.layers, f)
+		}
+	}
+	return l
+}
+
+// Layers returns the filesystem layers from top to bottom.
+func (l *LayeredFS) Layers() []fs.FS {
+	return append([]fs.FS(nil), l.layers...)
+}
+
+// Open opens the named file from the top-most layer that contains it, and a directory opened lists the entries merged across all layers.
+func (l *LayeredFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	for _, f := range l.layers {
+		fh, err := f.Open(name)
+		if err == nil {
+			if fi, err := fh.Stat(); err == nil && fi.IsDir() && len(l.layers) > 1 {
+				return &layeredDir{File: fh, l: l, name: name}, nil
+			}
+			return fh, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
+
+// Stat returns the file info of the named file from the top-most layer that contains it.
+func (l *LayeredFS) Stat(name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrInvalid}
+	}
+	for _, f := range l.layers {
+		fi, err := fs.Stat(f, name)
+		if err == nil {
+			return fi, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+	return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+}
+
+// ReadDir reads the named directory from all layers and returns the merged entries sorted by filename.
+// For entries with the same name, the one from the upper layer wins.
+func (l *LayeredFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	var (
+		found   bool
+		entries = make(map[string]fs.DirEntry)
+	)
+	for _, f := range l.layers {
+		des, err := fs.ReadDir(f, name)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+		found = true
+		for _, de := range des {
+			if _, ok := entries[de.Name()]; !ok {
+				entries[de.Name()] = de
+			}
+		}
+	}
+	if !found {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+
+	list := make([]fs.DirEntry, 0, len(entries))
+	for _, de := range entries {
+		list = append(list, de)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name() < list[j].Name()
+	})
+	return list, nil
+}
+
+// layeredDir is a directory opened from LayeredFS, it keeps the directory of the top-most layer for Stat() and Close(), and reads the entries merged across all layers.
+type layeredDir struct {
+	fs.File
+	l       *LayeredFS
+	name    string
+	entries []fs.DirEntry
+	offset  int
+}
+
+var _ fs.ReadDirFile = (*layeredDir)(nil)
+
+// ReadDir reads the merged entries of the directory in order, like fs.ReadDirFile.
+func (d *layeredDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if d.entries == nil {
+		list, err := d.l.ReadDir(d.name)
+		if err != nil {
+			return nil, err
+		}
+		d.entries = list
+	}
+
+	rest := d.entries[d.offset:]
+	if n <= 0 {
+		d.offset = len(d.entries)
+		return rest, nil
+	}
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(rest) {
+		n = len(rest)
+	}
+	d.offset += n
+	return rest[:n], nil
+}
+
+// buildModuleFS composes the virtual filesystem for module scripts: scripts added by AddModuleScript() on the top, and then layers added by SetFS() and AddFS() in reverse order.
+// It returns nil if there is neither module script nor filesystem.
+func (s *Starbox) buildModuleFS() (fs.FS, error) {
+	var layers []fs.FS
+
+	// in-memory module scripts shadow all other layers
+	if len(s.scriptMods) > 0 {
+		rootFS := memfs.New()
+		for fp, scr := range s.scriptMods {
+			if dir := path.Dir(fp); dir != "." {
+				if err := rootFS.MkdirAll(dir, 0755); err != nil {
+					return nil, err
+				}
+			}
+			if err := rootFS.WriteFile(fp, []byte(scr), 0644); err != nil {
+				return nil, err
+			}
+		}
+		layers = append(layers, rootFS)
+	}
+
+	// layers added later shadow the earlier ones
+	for i := len(s.modFS) - 1; i >= 0; i-- {
+		layers = append(layers, s.modFS[i])
+	}
+
+	switch len(layers) {
+	case 0:
+		return nil, nil
+	case 1:
+		return layers[0], nil
+	default:
+		return NewLayeredFS(layers...), nil
+	}
+}
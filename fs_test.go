@@ -0,0 +1,148 @@
+package starbox_test
+
+import (
+	"io"
+	"io/fs"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/PureMature/starbox"
+)
+
+// TestLayeredFS tests the following:
+// 1. Create a layered filesystem with two layers.
+// 2. Check the file in the upper layer shadows the lower one.
+// 3. Check the files only in the lower layer are accessible.
+// 4. Check the directory entries are merged, via ReadDir() and the directories opened by Open() for fs.WalkDir() and fs.Glob().
+func TestLayeredFS(t *testing.T) {
+	upper := fstest.MapFS{
+		"a.star":     {Data: []byte("a = 1")},
+		"lib/b.star": {Data: []byte("b = 1")},
+	}
+	lower := fstest.MapFS{
+		"a.star":     {Data: []byte("a = 2")},
+		"c.star":     {Data: []byte("c = 2")},
+		"lib/d.star": {Data: []byte("d = 2")},
+	}
+	lfs := starbox.NewLayeredFS(upper, nil, lower)
+	if n := len(lfs.Layers()); n != 2 {
+		t.Errorf("expect 2 layers, got %d", n)
+	}
+
+	tests := []struct {
+		name    string
+		want    string
+		wantErr bool
+	}{
+		{"a.star", "a = 1", false},
+		{"c.star", "c = 2", false},
+		{"lib/b.star", "b = 1", false},
+		{"lib/d.star", "d = 2", false},
+		{"e.star", "", true},
+		{"../a.star", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := fs.ReadFile(lfs, tt.name)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("expect error %v, got %v", tt.wantErr, err)
+				return
+			}
+			if string(b) != tt.want {
+				t.Errorf("expect %q, got %q", tt.want, string(b))
+			}
+		})
+	}
+
+	des, err := fs.ReadDir(lfs, "lib")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	var names []string
+	for _, de := range des {
+		names = append(names, de.Name())
+	}
+	if len(names) != 2 || names[0] != "b.star" || names[1] != "d.star" {
+		t.Errorf("unexpected dir entries: %v", names)
+	}
+	if _, err := fs.ReadDir(lfs, "none"); err == nil {
+		t.Error("expect error for missing dir, got nil")
+	}
+
+	// hide ReadDir() of the filesystem, so the directories are read via Open()
+	opened := struct{ fs.FS }{lfs}
+	var walked []string
+	if err := fs.WalkDir(opened, ".", func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			walked = append(walked, path)
+		}
+		return err
+	}); err != nil {
+		t.Error(err)
+	}
+	if got := strings.Join(walked, ","); got != "a.star,c.star,lib/b.star,lib/d.star" {
+		t.Errorf("unexpected walked files: %s", got)
+	}
+	if matches, err := fs.Glob(opened, "lib/*.star"); err != nil || strings.Join(matches, ",") != "lib/b.star,lib/d.star" {
+		t.Errorf("unexpected glob matches: %v %v", matches, err)
+	}
+
+	// read the entries of the directory in batches
+	f, err := lfs.Open("lib")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	dir, ok := f.(fs.ReadDirFile)
+	if !ok {
+		t.Fatalf("expect directory file, got %T", f)
+	}
+	if fi, err := dir.Stat(); err != nil || !fi.IsDir() || fi.Name() != "lib" {
+		t.Errorf("unexpected directory info: %v %v", fi, err)
+	}
+	for i, want := range []string{"b.star", "d.star"} {
+		if des, err := dir.ReadDir(1); err != nil || len(des) != 1 || des[0].Name() != want {
+			t.Errorf("#%d: unexpected entries: %v %v", i, des, err)
+		}
+	}
+	if des, err := dir.ReadDir(1); err != io.EOF || len(des) != 0 {
+		t.Errorf("expect EOF, got %v %v", des, err)
+	}
+}
+
+// TestAddFS tests the following:
+// 1. Create a new Starbox instance with a base filesystem and a patch filesystem.
+// 2. Add a module script in a sub-directory to shadow the files.
+// 3. Run a script that loads modules from all layers.
+// 4. Check the output.
+func TestAddFS(t *testing.T) {
+	base := fstest.MapFS{
+		"lib/x.star": {Data: []byte("x = 1")},
+		"lib/y.star": {Data: []byte("y = 1")},
+		"lib/z.star": {Data: []byte("z = 1")},
+	}
+	patch := fstest.MapFS{
+		"lib/y.star": {Data: []byte("y = 20")},
+		"lib/z.star": {Data: []byte("z = 20")},
+	}
+
+	b := starbox.New("test")
+	b.SetFS(base)
+	b.AddFS(patch)
+	b.AddModuleScript("lib/z", "z = 300")
+	out, err := b.Run(HereDoc(`
+		load("lib/x.star", "x")
+		load("lib/y.star", "y")
+		load("lib/z.star", "z")
+		s = x + y + z
+	`))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if es := int64(321); out["s"] != es {
+		t.Errorf("expect %d, got %v", es, out["s"])
+	}
+}
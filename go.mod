@@ -6,6 +6,7 @@ require (
 	bitbucket.org/ai69/amoy v0.2.3
 	bitbucket.org/neiku/hlog v0.1.2
 	github.com/1set/starlet v0.0.12
+	github.com/chzyer/readline v0.0.0-20180603132655-2972be24d48e
 	github.com/psanford/memfs v0.0.0-20230130182539-4dbf7e3e865e
 	go.starlark.net v0.0.0-20240123142251-f86470692795
 	go.uber.org/zap v1.24.0
@@ -17,7 +18,6 @@ require (
 	github.com/1set/starlight v0.0.9 // indirect
 	github.com/avast/retry-go v3.0.0+incompatible // indirect
 	github.com/aymanbagabas/go-osc52 v1.0.3 // indirect
-	github.com/chzyer/readline v0.0.0-20180603132655-2972be24d48e // indirect
 	github.com/google/uuid v1.6.0 // indirect
 	github.com/lucasb-eyer/go-colorful v1.2.0 // indirect
 	github.com/mattn/go-isatty v0.0.16 // indirect
@@ -0,0 +1,364 @@
+package starbox
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/1set/starlet"
+	"github.com/1set/starlet/dataconv"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+const (
+	// goldenScriptExt is the file extension of scripts run by golden tests.
+	goldenScriptExt = ".star"
+	// goldenInputSuffix is the file name suffix of fixture inputs of scripts.
+	goldenInputSuffix = ".input.json"
+	// goldenFileSuffix is the file name suffix of golden files of scripts.
+	goldenFileSuffix = ".golden.json"
+)
+
+// GoldenOption configures the golden test runner of RunGoldenTests().
+type GoldenOption func(cfg *goldenConfig)
+
+// goldenConfig is the configuration of the golden test runner.
+type goldenConfig struct {
+	update bool
+}
+
+// GoldenUpdate makes the runner rewrite the golden files with the actual outputs and print logs instead of comparing them, e.g. after intended changes of scripts.
+func GoldenUpdate(update bool) GoldenOption {
+	return func(cfg *goldenConfig) {
+		cfg.update = update
+	}
+}
+
+// goldenSnapshot is the content of a golden file, the converted outputs and the printed messages of a script.
+type goldenSnapshot struct {
+	Output map[string]interface{} `json:"output"`
+	Prints []string               `json:"prints,omitempty"`
+}
+
+// goldenCase is a run of a script with a fixture input, and the golden file of it.
+type goldenCase struct {
+	input  string
+	golden string
+}
+
+// RunGoldenTests runs the scripts in the directory with their fixture inputs, and compares the outputs and printed messages with the golden files, or rewrites the golden files in the update mode set by GoldenUpdate().
+// For a script "name.star", each fixture "name.input.json" or "name.<case>.input.json" is a JSON object of globals, and the golden file of it is "name.golden.json" or "name.<case>.golden.json", the script runs once without inputs if there is no fixture.
+// Fixtures belong to the script with the longest matching name, e.g. "a.b.input.json" is for "a.b.star" if it exists, otherwise it's the case "b" of "a.star".
+// Golden files are JSON objects with "output" for the global variables converted like JSON, except functions, unchanged inputs and values that can't be converted, and "prints" for the printed messages.
+// Each run is a fresh clone of the template box, which loads modules relative to the directory on top of the filesystems of the template, and is reported as a test named by the golden file of the script, so the report can be written like RunScriptTests(), and the mock and coverage of the template work the same way.
+// Test scripts named with the "_test.star" suffix are skipped, and modules should be put in subdirectories to avoid being run.
+func RunGoldenTests(template *Starbox, dir string, opts ...GoldenOption) (*ScriptTestReport, error) {
+	cfg := goldenConfig{}
+	for _, opt := range opts {
+		if opt != nil {
+			opt(&cfg)
+		}
+	}
+	if template == nil {
+		template = New("golden")
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string]bool, len(entries))
+	for _, e := range entries {
+		if !e.IsDir() {
+			files[e.Name()] = true
+		}
+	}
+
+	start := time.Now()
+	report := &ScriptTestReport{}
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, goldenScriptExt) || strings.HasSuffix(name, scriptTestFileSuffix) {
+			continue
+		}
+		script, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		for _, gc := range goldenCases(strings.TrimSuffix(name, goldenScriptExt), files) {
+			report.Results = append(report.Results, runGoldenTest(template, dir, name, string(script), gc, cfg.update))
+		}
+	}
+	report.Duration = time.Since(start)
+	return report, nil
+}
+
+// goldenCases returns the fixture inputs of the script found in the files sorted by names, or a case without input if there is none.
+func goldenCases(base string, files map[string]bool) []goldenCase {
+	var cases []goldenCase
+	for name := range files {
+		if !strings.HasSuffix(name, goldenInputSuffix) {
+			continue
+		}
+		c := strings.TrimSuffix(name, goldenInputSuffix)
+		if c == base || (strings.HasPrefix(c, base+".") && !goldenLongerScript(base, c, files)) {
+			cases = append(cases, goldenCase{input: name, golden: c + goldenFileSuffix})
+		}
+	}
+	if len(cases) == 0 {
+		return []goldenCase{{golden: base + goldenFileSuffix}}
+	}
+	sort.Slice(cases, func(i, j int) bool {
+		return cases[i].input < cases[j].input
+	})
+	return cases
+}
+
+// goldenLongerScript returns true if the fixture input of the case belongs to a script with a longer name than the base, e.g. "a.b.input.json" belongs to "a.b.star" instead of the case "b" of "a.star".
+func goldenLongerScript(base, c string, files map[string]bool) bool {
+	for i := len(base) + 1; i < len(c); i++ {
+		if c[i] == '.' && files[c[:i]+goldenScriptExt] {
+			return true
+		}
+	}
+	return files[c+goldenScriptExt]
+}
+
+// runGoldenTest runs the script with the fixture input in a clone of the template, and compares the snapshot with the golden file, or rewrites it in the update mode.
+func runGoldenTest(template *Starbox, dir, name, script string, gc goldenCase, update bool) *ScriptTestResult {
+	file := filepath.Join(dir, name)
+	start := time.Now()
+	fail := func(format string, args ...interface{}) *ScriptTestResult {
+		err := fmt.Errorf(format, args...)
+		return &ScriptTestResult{File: file, Name: gc.golden, Err: err, Message: err.Error(), Duration: time.Since(start)}
+	}
+
+	var inputs map[string]interface{}
+	globals := make(starlet.StringAnyMap)
+	if gc.input != "" {
+		data, err := os.ReadFile(filepath.Join(dir, gc.input))
+		if err != nil {
+			return fail("read input: %w", err)
+		}
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		if err := dec.Decode(&inputs); err != nil {
+			return fail("parse input %s: %w", gc.input, err)
+		}
+		for k, v := range inputs {
+			inputs[k] = goldenInput(v)
+			sv, err := dataconv.Marshal(inputs[k])
+			if err != nil {
+				return fail("convert input %s: %w", k, err)
+			}
+			globals[k] = sv
+		}
+	}
+
+	var prints []string
+	box := template.Clone()
+	box.AddFS(os.DirFS(dir))
+	box.AddKeyValues(globals)
+	if box.mock != nil {
+		box.mock.Reset()
+	}
+	box.coverName = file
+	box.SetPrintFunc(func(_ *starlark.Thread, msg string) {
+		prints = append(prints, msg)
+	})
+	out, err := box.Run(script)
+	if err != nil {
+		res := newScriptTestResult(file, gc.golden, err, strings.Join(prints, "\n"), time.Since(start))
+		if !strings.HasPrefix(res.Message, file+":") {
+			res.Message = file + ": " + res.Message
+		}
+		return res
+	}
+
+	actual, err := goldenJSON(&goldenSnapshot{Output: goldenOutput(out, inputs), Prints: prints})
+	if err != nil {
+		return fail("convert output: %w", err)
+	}
+	path := filepath.Join(dir, gc.golden)
+	if update {
+		if err := os.WriteFile(path, actual, 0644); err != nil {
+			return fail("update golden: %w", err)
+		}
+		return &ScriptTestResult{File: file, Name: gc.golden, Duration: time.Since(start)}
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return fail("missing golden file %s, run in the update mode to create it", path)
+	} else if err != nil {
+		return fail("read golden: %w", err)
+	}
+	var snap goldenSnapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return fail("parse golden %s: %w", path, err)
+	}
+	expected, err := goldenJSON(&snap)
+	if err != nil {
+		return fail("parse golden %s: %w", path, err)
+	}
+	if !bytes.Equal(expected, actual) {
+		return fail("mismatch with golden file %s (-golden +actual):\n%s", path, goldenDiff(string(expected), string(actual)))
+	}
+	return &ScriptTestResult{File: file, Name: gc.golden, Duration: time.Since(start)}
+}
+
+// goldenInput converts the numbers decoded from fixture inputs to int if they are integers, or float64 otherwise, so scripts get integers like in JSON, and they are converted to Starlark values by dataconv.Marshal() before execution.
+func goldenInput(v interface{}) interface{} {
+	switch x := v.(type) {
+	case json.Number:
+		if n, err := strconv.Atoi(x.String()); err == nil {
+			return n
+		}
+		f, _ := x.Float64()
+		return f
+	case map[string]interface{}:
+		for k, e := range x {
+			x[k] = goldenInput(e)
+		}
+	case []interface{}:
+		for i, e := range x {
+			x[i] = goldenInput(e)
+		}
+	}
+	return v
+}
+
+// goldenOutput converts the output of the script for golden files, and drops functions, modules, unchanged inputs and values that can't be converted.
+func goldenOutput(out starlet.StringAnyMap, inputs map[string]interface{}) map[string]interface{} {
+	res := make(map[string]interface{}, len(out))
+	for k, v := range out {
+		gv, ok := goldenValue(v)
+		if !ok {
+			continue
+		}
+		if in, found := inputs[k]; found {
+			a, err1 := json.Marshal(in)
+			b, err2 := json.Marshal(gv)
+			if err1 == nil && err2 == nil && bytes.Equal(a, b) {
+				continue
+			}
+		}
+		res[k] = gv
+	}
+	return res
+}
+
+// goldenValue converts the value to one that can be encoded as JSON, e.g. maps with non-string keys and sets, and returns false for functions and modules.
+func goldenValue(v interface{}) (interface{}, bool) {
+	switch x := v.(type) {
+	case starlark.Callable, *starlarkstruct.Module:
+		return nil, false
+	case starlark.Value:
+		return x.String(), true
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(x))
+		for k, e := range x {
+			if gv, ok := goldenValue(e); ok {
+				m[fmt.Sprint(k)] = gv
+			}
+		}
+		return m, true
+	case map[interface{}]bool:
+		// sets are converted to sorted lists
+		l := make([]interface{}, 0, len(x))
+		for k := range x {
+			if gv, ok := goldenValue(k); ok {
+				l = append(l, gv)
+			}
+		}
+		sort.Slice(l, func(i, j int) bool {
+			return fmt.Sprint(l[i]) < fmt.Sprint(l[j])
+		})
+		return l, true
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(x))
+		for k, e := range x {
+			if gv, ok := goldenValue(e); ok {
+				m[k] = gv
+			}
+		}
+		return m, true
+	case []interface{}:
+		l := make([]interface{}, 0, len(x))
+		for _, e := range x {
+			if gv, ok := goldenValue(e); ok {
+				l = append(l, gv)
+			}
+		}
+		return l, true
+	}
+	if _, err := json.Marshal(v); err != nil {
+		return nil, false
+	}
+	return v, true
+}
+
+// goldenJSON encodes the snapshot as indented JSON with sorted keys, and the values are normalized by decoding the JSON, so numbers compare equal regardless of Go types.
+func goldenJSON(snap *goldenSnapshot) ([]byte, error) {
+	data, err := json.Marshal(snap.Output)
+	if err != nil {
+		return nil, err
+	}
+	var output map[string]interface{}
+	if err := json.Unmarshal(data, &output); err != nil {
+		return nil, err
+	}
+	if output == nil {
+		output = map[string]interface{}{}
+	}
+	data, err = json.MarshalIndent(&goldenSnapshot{Output: output, Prints: snap.Prints}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// goldenDiff returns the lines removed from the expected text with "-" and added in the actual text with "+", based on the longest common subsequence of lines.
+func goldenDiff(expected, actual string) string {
+	a := strings.Split(strings.TrimSuffix(expected, "\n"), "\n")
+	b := strings.Split(strings.TrimSuffix(actual, "\n"), "\n")
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var sb strings.Builder
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			i++
+			j++
+		case j < len(b) && (i == len(a) || lcs[i][j+1] > lcs[i+1][j]):
+			fmt.Fprintf(&sb, "+%s\n", b[j])
+			j++
+		default:
+			fmt.Fprintf(&sb, "-%s\n", a[i])
+			i++
+		}
+	}
+	return strings.TrimSuffix(sb.String(), "\n")
+}
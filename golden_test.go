@@ -0,0 +1,223 @@
+package starbox_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/PureMature/starbox"
+)
+
+// writeGoldenFiles writes the files into the directory for golden tests.
+func writeGoldenFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// TestRunGoldenTests tests the following:
+// 1. Run scripts with and without fixture inputs in the update mode, and check the golden files are created with outputs and prints.
+// 2. Run again to compare with the golden files, and check they pass.
+// 3. Change a script and check the mismatch is reported with a diff, and missing golden files and failures of scripts are reported.
+// 4. Update the golden files and check they pass again.
+func TestRunGoldenTests(t *testing.T) {
+	dir := t.TempDir()
+	writeGoldenFiles(t, dir, map[string]string{
+		"scale.star": HereDoc(`
+			load("sums", "sum")
+			load("lib/util.star", "twice")
+			def helper():
+				pass
+			print("scaling", len(items))
+			doubled = [twice(x) for x in items]
+			total = sum(doubled) if items else 0
+			scaled = [x * factor for x in items]
+			tags = {1: "one"}
+			uniq = set(["b", "a"])
+		`),
+		"scale.input.json":       `{"items": [1, 2, 3], "factor": 2}`,
+		"scale.empty.input.json": `{"items": [], "factor": 1.5}`,
+		"hello.star":             `greeting = "hello"`,
+		"lib/util.star":          "def twice(n):\n\treturn n * 2\n",
+		"skip_test.star":         `fail("should not run")`,
+		"notes.txt":              "not a script",
+	})
+	tpl := starbox.New("golden")
+	tpl.AddModuleScript("sums", "def sum(l):\n\tt = 0\n\tfor x in l:\n\t\tt += x\n\treturn t\n")
+
+	// create goldens
+	report, err := starbox.RunGoldenTests(tpl, dir, starbox.GoldenUpdate(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, res := range report.Results {
+		names = append(names, filepath.Base(res.File)+"/"+res.Name)
+		if !res.Passed() {
+			t.Errorf("unexpected failure of %s: %s", res.Name, res.Message)
+		}
+	}
+	if got := strings.Join(names, ","); got != "hello.star/hello.golden.json,scale.star/scale.empty.golden.json,scale.star/scale.golden.json" {
+		t.Errorf("unexpected results: %s", got)
+	}
+	expected := HereDoc(`
+		{
+		  "output": {
+		    "doubled": [
+		      2,
+		      4,
+		      6
+		    ],
+		    "scaled": [
+		      2,
+		      4,
+		      6
+		    ],
+		    "tags": {
+		      "1": "one"
+		    },
+		    "total": 12,
+		    "uniq": [
+		      "a",
+		      "b"
+		    ]
+		  },
+		  "prints": [
+		    "scaling 3"
+		  ]
+		}
+	`)
+	if got := string(mustRead(t, filepath.Join(dir, "scale.golden.json"))); got != expected {
+		t.Errorf("unexpected golden file:\n%s\nwant:\n%s", got, expected)
+	}
+	if got := string(mustRead(t, filepath.Join(dir, "scale.empty.golden.json"))); !strings.Contains(got, `"total": 0`) || strings.Contains(got, "factor") {
+		t.Errorf("unexpected golden file of empty input:\n%s", got)
+	}
+	if got := string(mustRead(t, filepath.Join(dir, "hello.golden.json"))); got != "{\n  \"output\": {\n    \"greeting\": \"hello\"\n  }\n}\n" {
+		t.Errorf("unexpected golden file of no input:\n%s", got)
+	}
+
+	// compare, the format of golden files doesn't matter
+	writeGoldenFiles(t, dir, map[string]string{"hello.golden.json": `{"output":{"greeting":"hello"}}`})
+	report, err = starbox.RunGoldenTests(tpl, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Passed() || len(report.Results) != 3 {
+		t.Errorf("unexpected failures: %v", report.Results)
+	}
+
+	// mismatch, missing golden and failure
+	writeGoldenFiles(t, dir, map[string]string{
+		"hello.star":  "greeting = \"hi\"\nprint(greeting)\n",
+		"new.star":    "x = 1\n",
+		"broken.star": "x = 1\ny = x // 0\n",
+	})
+	report, err = starbox.RunGoldenTests(tpl, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, res := range report.Results {
+		got = append(got, res.Name+" "+strings.ReplaceAll(res.Message, dir+string(filepath.Separator), ""))
+	}
+	expectedResults := []string{
+		"broken.golden.json broken.star:2: floored division by zero",
+		"hello.golden.json mismatch with golden file hello.golden.json (-golden +actual):\n-    \"greeting\": \"hello\"\n-  }\n+    \"greeting\": \"hi\"\n+  },\n+  \"prints\": [\n+    \"hi\"\n+  ]",
+		"new.golden.json missing golden file new.golden.json, run in the update mode to create it",
+		"scale.empty.golden.json ",
+		"scale.golden.json ",
+	}
+	if strings.Join(got, "\n") != strings.Join(expectedResults, "\n") {
+		t.Errorf("unexpected results:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(expectedResults, "\n"))
+	}
+	if report.Failed() != 3 {
+		t.Errorf("unexpected failed count: %d", report.Failed())
+	}
+
+	// update again
+	if err := os.Remove(filepath.Join(dir, "broken.star")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := starbox.RunGoldenTests(tpl, dir, starbox.GoldenUpdate(true)); err != nil {
+		t.Fatal(err)
+	}
+	if report, err = starbox.RunGoldenTests(tpl, dir); err != nil || !report.Passed() {
+		t.Errorf("unexpected failures after update: %v %v", err, report)
+	}
+	if _, err := starbox.RunGoldenTests(nil, filepath.Join(dir, "missing")); err == nil {
+		t.Error("expect error for missing directory")
+	}
+}
+
+// TestRunGoldenTests_DottedNames tests the fixture inputs belong to the script with the longest matching name, and each of them runs only once.
+func TestRunGoldenTests_DottedNames(t *testing.T) {
+	dir := t.TempDir()
+	writeGoldenFiles(t, dir, map[string]string{
+		"a.star":           "v = x * 2\n",
+		"a.b.star":         "w = x + 1\n",
+		"a.b.input.json":   `{"x": 1}`,
+		"a.b.c.input.json": `{"x": 2}`,
+		"a.c.input.json":   `{"x": 5}`,
+	})
+	report, err := starbox.RunGoldenTests(nil, dir, starbox.GoldenUpdate(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, res := range report.Results {
+		names = append(names, filepath.Base(res.File)+"/"+res.Name)
+		if !res.Passed() {
+			t.Errorf("unexpected failure of %s: %s", res.Name, res.Message)
+		}
+	}
+	if got := strings.Join(names, ","); got != "a.b.star/a.b.c.golden.json,a.b.star/a.b.golden.json,a.star/a.c.golden.json" {
+		t.Errorf("unexpected results: %s", got)
+	}
+	if got := string(mustRead(t, filepath.Join(dir, "a.b.golden.json"))); !strings.Contains(got, `"w": 2`) {
+		t.Errorf("unexpected golden file of a.b:\n%s", got)
+	}
+}
+
+// TestRunGoldenTests_TemplateFS tests the scripts load modules from the directory and the filesystems added to the template, and the directory shadows the template.
+func TestRunGoldenTests_TemplateFS(t *testing.T) {
+	dir := t.TempDir()
+	writeGoldenFiles(t, dir, map[string]string{
+		"main.star":      "load('lib/base', 'base')\nload('lib/local', 'local')\nv = base + local\n",
+		"lib/local.star": "local = 2\n",
+	})
+	tpl := starbox.New("golden")
+	tpl.AddFS(fstest.MapFS{
+		"lib/base.star":  {Data: []byte("base = 10\n")},
+		"lib/local.star": {Data: []byte("local = 100\n")},
+	})
+	report, err := starbox.RunGoldenTests(tpl, dir, starbox.GoldenUpdate(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Results) != 1 || !report.Results[0].Passed() {
+		t.Fatalf("unexpected results: %+v", report.Results)
+	}
+	if got := string(mustRead(t, filepath.Join(dir, "main.golden.json"))); !strings.Contains(got, `"v": 12`) {
+		t.Errorf("unexpected golden file:\n%s", got)
+	}
+}
+
+// mustRead reads the file or fails the test.
+func mustRead(t *testing.T, path string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
@@ -0,0 +1,248 @@
+package starbox
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strings"
+
+	"github.com/1set/starlet"
+	"go.starlark.net/syntax"
+)
+
+// ModuleKind describes where a module in the import graph comes from.
+type ModuleKind string
+
+const (
+	// ModuleKindScript represents the main script or a module script in the virtual filesystem.
+	ModuleKindScript ModuleKind = "script"
+	// ModuleKindBuiltin represents a builtin module added by module set or by name.
+	ModuleKindBuiltin ModuleKind = "builtin"
+	// ModuleKindCustom represents a custom module added by module loader, data or functions.
+	ModuleKindCustom ModuleKind = "custom"
+	// ModuleKindMissing represents a module that can't be resolved or parsed.
+	ModuleKindMissing ModuleKind = "missing"
+)
+
+// ImportNode is a module in the import graph.
+type ImportNode struct {
+	Name  string     `json:"name"`            // Name is the module name, or the file path for scripts.
+	Kind  ModuleKind `json:"kind"`            // Kind is the kind of the module.
+	Error string     `json:"error,omitempty"` // Error is the reason why the module is missing.
+}
+
+// ImportEdge is a load() statement in the import graph.
+type ImportEdge struct {
+	From string `json:"from"` // From is the name of the script that loads the module.
+	To   string `json:"to"`   // To is the name of the loaded module.
+	Pos  string `json:"pos"`  // Pos is the position of the load() statement.
+}
+
+// ImportGraph is the load() dependency graph of a script, including module scripts loading other modules.
+type ImportGraph struct {
+	Root   string        `json:"root"`             // Root is the name of the main script.
+	Nodes  []*ImportNode `json:"nodes"`            // Nodes is the list of modules sorted by name.
+	Edges  []*ImportEdge `json:"edges"`            // Edges is the list of load() statements in discovery order.
+	Cycles [][]string    `json:"cycles,omitempty"` // Cycles is the list of load cycles, each starts and ends with the same module.
+}
+
+// ImportGraph parses the given script and module scripts it loads recursively without running them, and returns the load() dependency graph resolved against the module registry and virtual filesystem of the box.
+// It returns an error if the main script can't be parsed or the module registry is invalid, missing modules are reported as nodes instead.
+func (s *Starbox) ImportGraph(script string) (*ImportGraph, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// registry of modules
+	_, lazyMods, err := s.extractModLoads()
+	if err != nil {
+		return nil, err
+	}
+	modFS, err := s.buildModuleFS()
+	if err != nil {
+		return nil, err
+	}
+
+	// parse the main script
+	root := mainScriptName
+	f, err := scriptFileOptions().Parse(root, script, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	// walk the graph
+	w := &importWalker{
+		box:     s,
+		lazy:    lazyMods,
+		modFS:   modFS,
+		nodes:   map[string]*ImportNode{root: {Name: root, Kind: ModuleKindScript}},
+		visited: map[string]int{},
+		graph:   &ImportGraph{Root: root},
+	}
+	w.walk(root, f)
+
+	// sort nodes for stable output
+	for _, n := range w.nodes {
+		w.graph.Nodes = append(w.graph.Nodes, n)
+	}
+	sort.Slice(w.graph.Nodes, func(i, j int) bool {
+		return w.graph.Nodes[i].Name < w.graph.Nodes[j].Name
+	})
+	return w.graph, nil
+}
+
+// importWalker traverses the load() statements with depth-first search and detects cycles.
+type importWalker struct {
+	box     *Starbox
+	lazy    starlet.ModuleLoaderMap
+	modFS   fs.FS
+	nodes   map[string]*ImportNode
+	visited map[string]int // 1 for in progress, 2 for done
+	stack   []string
+	graph   *ImportGraph
+}
+
+func (w *importWalker) walk(name string, f *syntax.File) {
+	w.visited[name] = 1
+	w.stack = append(w.stack, name)
+	defer func() {
+		w.stack = w.stack[:len(w.stack)-1]
+		w.visited[name] = 2
+	}()
+
+	for _, stmt := range f.Stmts {
+		ld, ok := stmt.(*syntax.LoadStmt)
+		if !ok {
+			continue
+		}
+		to, sf := w.resolve(ld.ModuleName())
+		w.graph.Edges = append(w.graph.Edges, &ImportEdge{From: name, To: to, Pos: ld.Load.String()})
+
+		switch w.visited[to] {
+		case 1:
+			// back edge: found a cycle
+			for i, n := range w.stack {
+				if n == to {
+					cycle := append(append([]string{}, w.stack[i:]...), to)
+					w.graph.Cycles = append(w.graph.Cycles, cycle)
+					break
+				}
+			}
+		case 0:
+			if sf != nil {
+				w.walk(to, sf)
+			} else {
+				w.visited[to] = 2
+			}
+		}
+	}
+}
+
+// resolve finds the module by name like Starlet does: module loaders first, and then script files in the virtual filesystem.
+// It returns the node name and the parsed file if it's a module script that is not parsed yet.
+func (w *importWalker) resolve(module string) (string, *syntax.File) {
+	// module loaders
+	if _, ok := w.lazy[module]; ok {
+		if _, ok := w.nodes[module]; !ok {
+			kind := ModuleKindBuiltin
+			if _, custom := w.box.loadMods[module]; custom {
+				kind = ModuleKindCustom
+			}
+			w.nodes[module] = &ImportNode{Name: module, Kind: kind}
+		}
+		return module, nil
+	}
+
+	// module scripts
+	name := module
+	if !strings.HasSuffix(name, ".star") {
+		name += ".star"
+	}
+	if _, ok := w.nodes[name]; ok {
+		return name, nil
+	}
+	node := &ImportNode{Name: name, Kind: ModuleKindScript}
+	w.nodes[name] = node
+
+	var (
+		src []byte
+		err error
+	)
+	if w.modFS == nil {
+		err = errors.New("no file system given")
+	} else {
+		src, err = fs.ReadFile(w.modFS, name)
+	}
+	if err != nil {
+		node.Kind = ModuleKindMissing
+		node.Error = err.Error()
+		return name, nil
+	}
+	f, err := scriptFileOptions().Parse(name, src, 0)
+	if err != nil {
+		node.Kind = ModuleKindMissing
+		node.Error = err.Error()
+		return name, nil
+	}
+	return name, f
+}
+
+// Importers returns the names of scripts that load the given module directly, sorted by name.
+func (g *ImportGraph) Importers(module string) []string {
+	set := make(map[string]struct{})
+	for _, e := range g.Edges {
+		if e.To == module {
+			set[e.From] = struct{}{}
+		}
+	}
+	names := make([]string, 0, len(set))
+	for n := range set {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Missing returns the nodes of modules that can't be resolved or parsed.
+func (g *ImportGraph) Missing() []*ImportNode {
+	var ns []*ImportNode
+	for _, n := range g.Nodes {
+		if n.Kind == ModuleKindMissing {
+			ns = append(ns, n)
+		}
+	}
+	return ns
+}
+
+// JSON returns the import graph encoded as indented JSON.
+func (g *ImportGraph) JSON() ([]byte, error) {
+	return json.MarshalIndent(g, "", "  ")
+}
+
+// DOT returns the import graph in Graphviz DOT language.
+func (g *ImportGraph) DOT() string {
+	var sb strings.Builder
+	sb.WriteString("digraph imports {\n")
+	sb.WriteString("\tnode [shape=box];\n")
+	for _, n := range g.Nodes {
+		attrs := []string{fmt.Sprintf("label=%q", n.Name)}
+		switch n.Kind {
+		case ModuleKindBuiltin:
+			attrs = append(attrs, `shape=ellipse`)
+		case ModuleKindCustom:
+			attrs = append(attrs, `shape=ellipse`, `style=dashed`)
+		case ModuleKindMissing:
+			attrs = append(attrs, `color=red`, fmt.Sprintf("tooltip=%q", n.Error))
+		}
+		if n.Name == g.Root {
+			attrs = append(attrs, `style=bold`)
+		}
+		fmt.Fprintf(&sb, "\t%q [%s];\n", n.Name, strings.Join(attrs, ", "))
+	}
+	for _, e := range g.Edges {
+		fmt.Fprintf(&sb, "\t%q -> %q [tooltip=%q];\n", e.From, e.To, e.Pos)
+	}
+	sb.WriteString("}\n")
+	return sb.String()
+}
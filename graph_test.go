@@ -0,0 +1,105 @@
+package starbox_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/PureMature/starbox"
+	"go.starlark.net/starlark"
+)
+
+// TestImportGraph tests the following:
+// 1. Create a new Starbox instance with builtin modules, custom modules and module scripts.
+// 2. Build the import graph of a script.
+// 3. Check the nodes, edges, cycles and missing modules.
+// 4. Render the graph as DOT and JSON.
+func TestImportGraph(t *testing.T) {
+	b := starbox.New("test")
+	b.AddNamedModules("json")
+	b.AddModuleData("conf", starlark.StringDict{"debug": starlark.True})
+	b.AddModuleScript("lib/a", `load("lib/b", "y"); load("json", "encode"); x = 1`)
+	b.AddModuleScript("lib/b", `load("lib/c.star", "z"); y = 2`)
+	b.AddModuleScript("lib/c", `load("lib/a", "x"); load("absent", "w"); z = 3`)
+	b.AddModuleScript("bad", `def (`)
+
+	g, err := b.ImportGraph(HereDoc(`
+		load("lib/a", "x")
+		load("conf", "debug")
+		load("bad", "v")
+	`))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	kinds := make(map[string]starbox.ModuleKind)
+	for _, n := range g.Nodes {
+		kinds[n.Name] = n.Kind
+	}
+	expected := map[string]starbox.ModuleKind{
+		"box.star":    starbox.ModuleKindScript,
+		"lib/a.star":  starbox.ModuleKindScript,
+		"lib/b.star":  starbox.ModuleKindScript,
+		"lib/c.star":  starbox.ModuleKindScript,
+		"json":        starbox.ModuleKindBuiltin,
+		"conf":        starbox.ModuleKindCustom,
+		"absent.star": starbox.ModuleKindMissing,
+		"bad.star":    starbox.ModuleKindMissing,
+	}
+	if len(kinds) != len(expected) {
+		t.Errorf("expect %d nodes, got %d: %v", len(expected), len(kinds), kinds)
+	}
+	for n, k := range expected {
+		if kinds[n] != k {
+			t.Errorf("expect node %s of kind %s, got %s", n, k, kinds[n])
+		}
+	}
+	if len(g.Edges) != 8 {
+		t.Errorf("expect 8 edges, got %d", len(g.Edges))
+	}
+	if len(g.Missing()) != 2 {
+		t.Errorf("expect 2 missing, got %v", g.Missing())
+	}
+	if im := g.Importers("absent.star"); len(im) != 1 || im[0] != "lib/c.star" {
+		t.Errorf("unexpected importers: %v", im)
+	}
+
+	// cycle: a -> b -> c -> a
+	if len(g.Cycles) != 1 {
+		t.Errorf("expect 1 cycle, got %v", g.Cycles)
+	} else if c := strings.Join(g.Cycles[0], " -> "); c != "lib/a.star -> lib/b.star -> lib/c.star -> lib/a.star" {
+		t.Errorf("unexpected cycle: %s", c)
+	}
+
+	// render
+	dot := g.DOT()
+	if !strings.HasPrefix(dot, "digraph imports {") || !strings.Contains(dot, `"lib/c.star" -> "absent.star"`) {
+		t.Errorf("unexpected dot: %s", dot)
+	}
+	js, err := g.JSON()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	var decoded starbox.ImportGraph
+	if err := json.Unmarshal(js, &decoded); err != nil {
+		t.Error(err)
+		return
+	}
+	if decoded.Root != "box.star" || len(decoded.Nodes) != len(g.Nodes) {
+		t.Errorf("unexpected json: %s", js)
+	}
+}
+
+// TestImportGraph_Error tests the import graph fails for invalid script or module set.
+func TestImportGraph_Error(t *testing.T) {
+	b := starbox.New("test")
+	if _, err := b.ImportGraph(`load(`); err == nil {
+		t.Error("expect error for invalid script, got nil")
+	}
+	b.SetModuleSet("missing")
+	if _, err := b.ImportGraph(`a = 1`); err == nil {
+		t.Error("expect error for invalid module set, got nil")
+	}
+}
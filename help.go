@@ -0,0 +1,141 @@
+package starbox
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+// helpBuiltinName is the name of the builtin to print help in REPL sessions.
+const helpBuiltinName = "help"
+
+// helpBuiltin returns the builtin help(x) for REPL sessions, which prints the documentation of the value, it must be called with the lock held.
+func (s *Starbox) helpBuiltin() *starlark.Builtin {
+	docs := make(map[string]string, len(s.docs))
+	for k, v := range s.docs {
+		docs[k] = v
+	}
+	return starlark.NewBuiltin(helpBuiltinName, func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var v starlark.Value
+		if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 0, &v); err != nil {
+			return nil, err
+		}
+		printHelp(thread, helpText(v, docs))
+		return starlark.None, nil
+	})
+}
+
+// printHelp prints the text with the print function of the thread.
+func printHelp(thread *starlark.Thread, text string) {
+	if thread.Print != nil {
+		thread.Print(thread, text)
+	} else {
+		fmt.Println(text)
+	}
+}
+
+// helpText returns the documentation of the value, or the usage and documented builtins if the value is nil.
+func helpText(v starlark.Value, docs map[string]string) string {
+	// for names of globals or documented builtins
+	if s, ok := v.(starlark.String); ok {
+		name := string(s)
+		if doc, ok := docs[name]; ok {
+			return name + "\n\n" + doc
+		}
+		return fmt.Sprintf("no documentation for %q", name)
+	}
+
+	var sb strings.Builder
+	switch x := v.(type) {
+	case nil:
+		sb.WriteString(`Type help(x) to show the documentation of x, e.g. help(len), or help("name") for a documented builtin.`)
+		if len(docs) > 0 {
+			sb.WriteString("\n\nDocumented builtins:\n")
+			names := make([]string, 0, len(docs))
+			for name := range docs {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				sb.WriteString("  " + name + "\n")
+			}
+		}
+	case *starlark.Function:
+		// *args and **kwargs are at the end of parameters
+		params := make([]string, x.NumParams())
+		for i := range params {
+			params[i], _ = x.Param(i)
+		}
+		if n := len(params); x.HasKwargs() {
+			params[n-1] = "**" + params[n-1]
+			if x.HasVarargs() {
+				params[n-2] = "*" + params[n-2]
+			}
+		} else if x.HasVarargs() {
+			params[n-1] = "*" + params[n-1]
+		}
+		fmt.Fprintf(&sb, "def %s(%s)", x.Name(), strings.Join(params, ", "))
+		if doc := strings.TrimSpace(x.Doc()); doc != "" {
+			sb.WriteString("\n\n" + doc)
+		}
+	case *starlark.Builtin:
+		fmt.Fprintf(&sb, "builtin %s", x.Name())
+		if x.Receiver() != nil {
+			fmt.Fprintf(&sb, " of %s", x.Receiver().Type())
+		}
+		if doc, ok := docs[x.Name()]; ok {
+			sb.WriteString("\n\n" + doc)
+		} else {
+			sb.WriteString("\n\nno documentation")
+		}
+	case starlark.HasAttrs:
+		fmt.Fprintf(&sb, "%s %s", x.Type(), helpName(x))
+		names := x.AttrNames()
+		if len(names) > 0 {
+			sb.WriteString("\n\nMembers:")
+		}
+		for _, name := range names {
+			av, _ := attrOf(x, name)
+			fmt.Fprintf(&sb, "\n  %s", name)
+			if av == nil {
+				continue
+			}
+			if b, ok := av.(*starlark.Builtin); ok {
+				if doc, ok := docs[b.Name()]; ok {
+					fmt.Fprintf(&sb, ": %s", firstLine(doc))
+				}
+			} else if f, ok := av.(*starlark.Function); ok && f.Doc() != "" {
+				fmt.Fprintf(&sb, ": %s", firstLine(f.Doc()))
+			} else {
+				fmt.Fprintf(&sb, " (%s)", av.Type())
+			}
+		}
+	default:
+		fmt.Fprintf(&sb, "%s: %s", x.Type(), x.String())
+	}
+	return strings.TrimRight(sb.String(), "\n")
+}
+
+// helpName returns the name of modules or structs for help.
+func helpName(v starlark.Value) string {
+	if m, ok := v.(*starlarkstruct.Module); ok {
+		return m.Name
+	}
+	s := v.String()
+	if len(s) > 60 {
+		s = s[:57] + "..."
+	}
+	return s
+}
+
+// firstLine returns the first non-empty line of the text.
+func firstLine(s string) string {
+	s = strings.TrimSpace(s)
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		return strings.TrimSpace(s[:i])
+	}
+	return s
+}
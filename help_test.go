@@ -0,0 +1,63 @@
+package starbox_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/PureMature/starbox"
+	"go.starlark.net/starlark"
+)
+
+// TestREPLHelp tests the following:
+// 1. Create a new Starbox instance with builtins, module functions and their documentation.
+// 2. Start a REPL session and call help() with no argument, builtins, modules, Starlark functions, strings and other values.
+// 3. Check the documentation in the output.
+// 4. Check the help builtin is not left in globals after the session.
+func TestREPLHelp(t *testing.T) {
+	noop := func(thread *starlark.Thread, bt *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		return starlark.None, nil
+	}
+	b := starbox.New("test")
+	b.AddBuiltin("fetch", noop)
+	b.AddBuiltinDoc("fetch", "fetch(url) downloads the content of the url.")
+	b.AddModuleFunctions("tool", starbox.FuncMap{"ping": noop, "pong": noop})
+	b.AddBuiltinDoc("tool.ping", "ping(host) checks the host.\nIt returns True if alive.")
+
+	input := HereDoc(`
+		help()
+		help(fetch)
+		help(tool)
+		help(tool.pong)
+		def add(a, b=1, *args, **kwargs):
+			"""add returns the sum."""
+			return a + b
+
+		help(add)
+		help("tool.ping")
+		help("nothing")
+		help(123)`)
+	var out bytes.Buffer
+	if err := b.REPLWith(strings.NewReader(input), &out, starbox.REPLPrompt("", "")); err != nil {
+		t.Fatal(err)
+	}
+	got := out.String()
+	for _, s := range []string{
+		"Documented builtins:\n  fetch\n  tool.ping\n",
+		"builtin fetch\n\nfetch(url) downloads the content of the url.\n",
+		"module tool\n\nMembers:\n  ping: ping(host) checks the host.\n  pong\n",
+		"builtin tool.pong\n\nno documentation\n",
+		"def add(a, b, *args, **kwargs)\n\nadd returns the sum.\n",
+		"tool.ping\n\nping(host) checks the host.\nIt returns True if alive.\n",
+		`no documentation for "nothing"` + "\n",
+		"int: 123\n",
+	} {
+		if !strings.Contains(got, s) {
+			t.Errorf("expect output contains %q, got:\n%s", s, got)
+		}
+	}
+
+	if _, err := b.Run(`help`); err == nil {
+		t.Errorf("expect error for help after REPL session, got nil")
+	}
+}
@@ -0,0 +1,140 @@
+package starbox
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// historyFileExt is the extension of REPL history files.
+	historyFileExt = ".history"
+	// transcriptTimeFormat is the format of timestamps in REPL transcripts.
+	transcriptTimeFormat = "2006-01-02T15:04:05.000Z07:00"
+)
+
+// REPLHistoryDir sets the directory to keep the input history of REPL sessions, one file per box name, e.g. "~/.starbox/history/mybox.history", the history is disabled by default or with an empty string.
+// The terminal REPL loads the history for line editing, and appends new input to it, while REPLWith() only appends new input.
+func REPLHistoryDir(dir string) REPLOption {
+	return func(c *replConfig) {
+		c.historyDir = dir
+	}
+}
+
+// REPLTranscript records every input and output of the REPL session with timestamps to the writer, it can be replayed as a script to reproduce the session.
+// Outputs and failed inputs are recorded as comments, so the replay only executes the successful inputs.
+func REPLTranscript(w io.Writer) REPLOption {
+	return func(c *replConfig) {
+		c.transcript = w
+	}
+}
+
+// DefaultREPLHistoryDir returns the default directory of REPL history files used by the starbox command, which can be passed to REPLHistoryDir() to share the history with it, i.e. ".starbox/history" in the home directory of the user, or an empty string if the home directory is unknown.
+func DefaultREPLHistoryDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil || home == "" {
+		return ""
+	}
+	return filepath.Join(home, ".starbox", "history")
+}
+
+// historyPath returns the path of the history file for the box name in the directory, with unsafe characters in the name replaced.
+func historyPath(dir, name string) string {
+	safe := strings.Map(func(r rune) rune {
+		if r == '-' || r == '_' || r == '.' || ('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z') || ('0' <= r && r <= '9') {
+			return r
+		}
+		return '_'
+	}, name)
+	if strings.Trim(safe, ".") == "" {
+		safe = "default"
+	}
+	return filepath.Join(dir, safe+historyFileExt)
+}
+
+// openHistory creates the directory and opens the history file for appending.
+func openHistory(path string) (*os.File, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	return os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+}
+
+// historyReader wraps a line reader to append the non-empty lines of input to the history file.
+type historyReader struct {
+	lineReader
+	w io.Writer
+}
+
+func (r *historyReader) ReadLine(prompt string) ([]byte, error) {
+	line, err := r.lineReader.ReadLine(prompt)
+	if err == nil {
+		if l := strings.TrimRight(string(line), "\r\n"); strings.TrimSpace(l) != "" {
+			_, _ = fmt.Fprintln(r.w, l)
+		}
+	}
+	return line, err
+}
+
+// transcriptRecorder records the inputs and outputs of a REPL session as a replayable script.
+type transcriptRecorder struct {
+	w      io.Writer
+	input  bytes.Buffer // lines of the current input
+	output bytes.Buffer // output of the current input
+	start  time.Time    // time when the current input starts
+}
+
+// newTranscriptRecorder creates a recorder and writes the header of the transcript.
+func newTranscriptRecorder(w io.Writer, name string) *transcriptRecorder {
+	t := &transcriptRecorder{w: w}
+	fmt.Fprintf(w, "# Starbox REPL transcript of %q, started at %s\n", name, time.Now().Format(transcriptTimeFormat))
+	return t
+}
+
+// begin resets the recorder for a new input.
+func (t *transcriptRecorder) begin() {
+	t.input.Reset()
+	t.output.Reset()
+	t.start = time.Now()
+}
+
+// addInput records a line of the current input.
+func (t *transcriptRecorder) addInput(line []byte) {
+	t.input.Write(line)
+}
+
+// Write records the output of the current input.
+func (t *transcriptRecorder) Write(p []byte) (int, error) {
+	return t.output.Write(p)
+}
+
+// commit writes the current input and its output to the transcript, failed inputs are commented out to keep the transcript replayable.
+func (t *transcriptRecorder) commit(failed bool) {
+	code := strings.TrimRight(t.input.String(), " \t\r\n")
+	if strings.TrimSpace(code) == "" {
+		return
+	}
+
+	var sb strings.Builder
+	sb.WriteString("\n# [" + t.start.Format(transcriptTimeFormat) + "]")
+	if failed {
+		sb.WriteString(" failed")
+	}
+	sb.WriteString("\n")
+	for _, line := range strings.Split(code, "\n") {
+		if failed {
+			sb.WriteString("# ")
+		}
+		sb.WriteString(line + "\n")
+	}
+	if out := strings.TrimRight(t.output.String(), "\n"); out != "" {
+		for _, line := range strings.Split(out, "\n") {
+			sb.WriteString("# | " + line + "\n")
+		}
+	}
+	_, _ = io.WriteString(t.w, sb.String())
+}
@@ -0,0 +1,93 @@
+package starbox_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/PureMature/starbox"
+)
+
+// TestREPLWith_History tests the following:
+// 1. Start REPL sessions with the history directory for boxes with different names.
+// 2. Check the non-empty input lines are appended to the history file named after the box.
+// 3. Check unsafe characters in the box name are replaced in the file name.
+func TestREPLWith_History(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "history")
+	runSession := func(name, input string) {
+		b := starbox.New(name)
+		var out bytes.Buffer
+		if err := b.REPLWith(strings.NewReader(input), &out, starbox.REPLHistoryDir(dir)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	runSession("test", "a = 1\n\nprint(a)\n")
+	runSession("test", "def f():\n  return 2\n\nf()\n")
+	runSession("../other box", "b = 2\n")
+
+	data, err := os.ReadFile(filepath.Join(dir, "test.history"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "a = 1\nprint(a)\ndef f():\n  return 2\nf()\n"; string(data) != want {
+		t.Errorf("unexpected history: %q, want %q", data, want)
+	}
+	data, err = os.ReadFile(filepath.Join(dir, ".._other_box.history"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "b = 2\n"; string(data) != want {
+		t.Errorf("unexpected history: %q, want %q", data, want)
+	}
+}
+
+// TestREPLWith_Transcript tests the following:
+// 1. Start a REPL session with the transcript, and run inputs with results, printed messages, syntax errors and runtime errors.
+// 2. Check the transcript records inputs with timestamps, and outputs and failed inputs as comments.
+// 3. Replay the transcript as a script with a new box, and check the globals are reproduced.
+func TestREPLWith_Transcript(t *testing.T) {
+	b := starbox.New("test")
+	input := HereDoc(`
+		x = 10
+		print("x is", x)
+		def double(n):
+			return n * 2
+
+		y = double(x)
+		y + 1
+		fail("oops")
+		1 +
+		z = y - x`)
+	var out, tr bytes.Buffer
+	if err := b.REPLWith(strings.NewReader(input), &out, starbox.REPLPrompt("", ""), starbox.REPLTranscript(&tr)); err != nil {
+		t.Fatal(err)
+	}
+	got := tr.String()
+	if !strings.HasPrefix(got, `# Starbox REPL transcript of "test", started at `) {
+		t.Errorf("unexpected header of transcript:\n%s", got)
+	}
+	for _, s := range []string{
+		"]\nx = 10\n",
+		"]\nprint(\"x is\", x)\n# | x is 10\n",
+		"]\ndef double(n):\n\treturn n * 2\n",
+		"]\ny + 1\n# | 21\n",
+		"] failed\n# fail(\"oops\")\n# | Traceback",
+		"] failed\n# 1 +\n# | <stdin>:2:1: got newline, want primary expression\n",
+		"]\nz = y - x\n",
+	} {
+		if !strings.Contains(got, s) {
+			t.Errorf("expect transcript contains %q, got:\n%s", s, got)
+		}
+	}
+
+	b2 := starbox.New("replay")
+	res, err := b2.Run(got)
+	if err != nil {
+		t.Fatalf("replay transcript: %v\n%s", err, got)
+	}
+	if res["x"] != int64(10) || res["y"] != int64(20) || res["z"] != int64(10) {
+		t.Errorf("unexpected replay output: %v", res)
+	}
+}
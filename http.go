@@ -0,0 +1,250 @@
+package starbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	libhttp "github.com/1set/starlet/lib/http"
+)
+
+// DefaultHandlerTimeout is the default timeout for running the script of each HTTP request.
+const DefaultHandlerTimeout = 30 * time.Second
+
+// responseChunkSize is the size of chunks when writing the buffered response body to the client.
+const responseChunkSize = 32 * 1024
+
+// BoxFactory creates a new Starbox instance for an HTTP request, the instance must not have been executed, and the HTTP context is added to it by the handler.
+type BoxFactory func(r *http.Request) *Starbox
+
+// HTTPError is an error with an HTTP status code, the handlers respond with the status code if the error is returned by the script, e.g. from a custom builtin.
+type HTTPError struct {
+	StatusCode int   // StatusCode is the HTTP status code to respond with.
+	Err        error // Err is the underlying error.
+}
+
+// Error returns the error message.
+func (e *HTTPError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("http %d: %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("http %d: %v", e.StatusCode, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *HTTPError) Unwrap() error {
+	return e.Err
+}
+
+// HandlerOption configures the HTTP handlers created by Handler() and other constructors of this package.
+type HandlerOption func(c *handlerConfig)
+
+// HandlerTimeout sets the timeout for running the script of each HTTP request, zero or negative means no timeout besides the request context.
+func HandlerTimeout(timeout time.Duration) HandlerOption {
+	return func(c *handlerConfig) {
+		c.timeout = timeout
+	}
+}
+
+// HandlerErrorStatus sets the function mapping errors of running the script to HTTP status codes, the default is DefaultErrorStatus().
+func HandlerErrorStatus(fn func(err error) int) HandlerOption {
+	return func(c *handlerConfig) {
+		if fn != nil {
+			c.errorStatus = fn
+		}
+	}
+}
+
+// DefaultErrorStatus maps errors of running the script to HTTP status codes: the status code of HTTPError, 503 for timeout, 499 for cancelled requests, and 500 for others.
+func DefaultErrorStatus(err error) int {
+	var he *HTTPError
+	switch {
+	case errors.As(err, &he) && he.StatusCode > 0:
+		return he.StatusCode
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, context.Canceled):
+		return 499 // client closed request
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// handlerConfig is the shared configuration of HTTP handlers.
+type handlerConfig struct {
+	factory     BoxFactory
+	timeout     time.Duration
+	errorStatus func(err error) int
+	cache       ScriptCache
+}
+
+func newHandlerConfig(factory BoxFactory, opts []HandlerOption) handlerConfig {
+	c := handlerConfig{
+		factory:     factory,
+		timeout:     DefaultHandlerTimeout,
+		errorStatus: DefaultErrorStatus,
+		cache:       NewMemoryCache(),
+	}
+	for _, opt := range opts {
+		if opt != nil {
+			opt(&c)
+		}
+	}
+	return c
+}
+
+// newBox creates a new Starbox instance for the request, and shares the compiled script cache among instances unless the factory sets one.
+func (c *handlerConfig) newBox(r *http.Request) (*Starbox, error) {
+	var box *Starbox
+	if c.factory == nil {
+		box = New("http")
+	} else if box = c.factory(r); box == nil {
+		return nil, errors.New("nil box from factory")
+	}
+
+	box.mu.Lock()
+	defer box.mu.Unlock()
+	if box.hasExec {
+		return nil, errors.New("box from factory has been executed")
+	}
+	if box.scriptCache == nil {
+		box.scriptCache = c.cache
+	}
+	return box, nil
+}
+
+// context returns the context of the request with the timeout applied.
+func (c *handlerConfig) context(r *http.Request) (context.Context, context.CancelFunc) {
+	if c.timeout > 0 {
+		return context.WithTimeout(r.Context(), c.timeout)
+	}
+	return context.WithCancel(r.Context())
+}
+
+// runError returns the error of running the script, wrapped with the error of the context if it's done.
+func runError(ctx context.Context, err error) error {
+	if ce := ctx.Err(); ce != nil && !errors.Is(err, ce) {
+		return fmt.Errorf("%w: %v", ce, err)
+	}
+	return err
+}
+
+// writeError writes the error response with the mapped status code, and the details are only logged but not sent to the client.
+func (c *handlerConfig) writeError(w http.ResponseWriter, r *http.Request, err error) {
+	code := c.errorStatus(err)
+	log.Warnw("failed to handle http request", "method", r.Method, "url", r.URL.String(), "status", code, "error", err)
+	if errors.Is(err, context.Canceled) && r.Context().Err() != nil {
+		// the client is gone, no need to respond
+		return
+	}
+	http.Error(w, http.StatusText(code), code)
+}
+
+// recoverPanic recovers from a panic in handling the request, and responds with 500 if nothing has been written.
+func (c *handlerConfig) recoverPanic(w *trackingWriter, r *http.Request) {
+	if v := recover(); v != nil {
+		if v == http.ErrAbortHandler {
+			panic(v)
+		}
+		log.Errorw("panic in handling http request", "method", r.Method, "url", r.URL.String(), "panic", v)
+		if !w.wroteHeader {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		}
+	}
+}
+
+// trackingWriter wraps the response writer to track whether the header has been written.
+type trackingWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (w *trackingWriter) WriteHeader(code int) {
+	w.wroteHeader = true
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *trackingWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush flushes the buffered data to the client if supported.
+func (w *trackingWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// writeResponse writes the response set by the script to the writer after the script finishes, the body is buffered in full by the script, and it's written in chunks with flushing if supported, so it's not streamed while the script runs.
+func writeResponse(w http.ResponseWriter, resp *libhttp.ServerResponse) error {
+	d := resp.Export()
+	hdr := w.Header()
+	for k, vs := range d.Header {
+		hdr.Del(k)
+		for _, v := range vs {
+			hdr.Add(k, v)
+		}
+	}
+	hdr.Set("Content-Length", strconv.Itoa(len(d.Data)))
+	w.WriteHeader(d.StatusCode)
+
+	flusher, _ := w.(http.Flusher)
+	for data := d.Data; len(data) > 0; {
+		n := len(data)
+		if n > responseChunkSize {
+			n = responseChunkSize
+		}
+		if _, err := w.Write(data[:n]); err != nil {
+			return err
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		data = data[n:]
+	}
+	return nil
+}
+
+// ScriptHandler is an http.Handler running a script in a new Starbox instance with the HTTP context for each request.
+type ScriptHandler struct {
+	script string
+	handlerConfig
+}
+
+var _ http.Handler = (*ScriptHandler)(nil)
+
+// Handler creates an http.Handler running the script for each request in a new Starbox instance created by the factory, a nil factory creates instances with the default settings.
+// The script reads the request from the global `request`, and writes the response via the global `response`, as AddHTTPContext() does.
+func Handler(script string, factory BoxFactory, opts ...HandlerOption) *ScriptHandler {
+	return &ScriptHandler{
+		script:        script,
+		handlerConfig: newHandlerConfig(factory, opts),
+	}
+}
+
+// ServeHTTP runs the script for the request and writes the response.
+func (h *ScriptHandler) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	w := &trackingWriter{ResponseWriter: rw}
+	defer h.recoverPanic(w, r)
+
+	box, err := h.newBox(r)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+	resp := box.AddHTTPContext(r)
+
+	ctx, cancel := h.context(r)
+	defer cancel()
+	if _, err := box.RunContext(ctx, h.script); err != nil {
+		h.writeError(w, r, runError(ctx, err))
+		return
+	}
+	if err := writeResponse(w, resp); err != nil {
+		log.Warnw("failed to write http response", "method", r.Method, "url", r.URL.String(), "error", err)
+	}
+}
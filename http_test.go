@@ -0,0 +1,119 @@
+package starbox_test
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/PureMature/starbox"
+	"go.starlark.net/starlark"
+)
+
+// TestHandler tests the following:
+// 1. Create an HTTP handler with a script reading the request and writing the response.
+// 2. Serve requests with the handler, and check the status, headers and body.
+// 3. Check errors, timeouts and panics are mapped to the expected status codes.
+func TestHandler(t *testing.T) {
+	factory := func(r *http.Request) *starbox.Starbox {
+		b := starbox.New("web")
+		b.AddKeyValue("greeting", "Aloha")
+		b.AddBuiltin("deny", func(thread *starlark.Thread, bt *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			return nil, &starbox.HTTPError{StatusCode: http.StatusForbidden, Err: errors.New("denied")}
+		})
+		return b
+	}
+	script := HereDoc(`
+		p = request.url.split("?")[0]
+		if p.endswith("/fail"):
+			fail("oops")
+		elif p.endswith("/deny"):
+			deny()
+		elif p.endswith("/slow"):
+			while True:
+				pass
+		response.add_header("X-Box", "web")
+		response.set_status(201)
+		response.set_text(greeting + " " + request.method + " " + request.body)
+	`)
+	h := starbox.Handler(script, factory, starbox.HandlerTimeout(100*time.Millisecond))
+
+	tests := []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/hello", http.StatusCreated, "Aloha POST data"},
+		{"/fail", http.StatusInternalServerError, "Internal Server Error\n"},
+		{"/deny", http.StatusForbidden, "Forbidden\n"},
+		{"/slow", http.StatusServiceUnavailable, "Service Unavailable\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "http://localhost"+tt.path, strings.NewReader("data")))
+			if rec.Code != tt.status {
+				t.Errorf("expect status %d, got %d", tt.status, rec.Code)
+			}
+			if body := rec.Body.String(); body != tt.body {
+				t.Errorf("expect body %q, got %q", tt.body, body)
+			}
+			if tt.status == http.StatusCreated {
+				if v := rec.Header().Get("X-Box"); v != "web" {
+					t.Errorf("unexpected header: %q", v)
+				}
+				if v := rec.Header().Get("Content-Type"); v != "text/plain" {
+					t.Errorf("unexpected content type: %q", v)
+				}
+			}
+		})
+	}
+
+	// panic in factory
+	h = starbox.Handler(script, func(r *http.Request) *starbox.Starbox {
+		panic("bad factory")
+	})
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://localhost/", nil))
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("expect status 500 for panic, got %d", rec.Code)
+	}
+
+	// custom error mapping, and nil box
+	h = starbox.Handler(script, func(r *http.Request) *starbox.Starbox { return nil }, starbox.HandlerErrorStatus(func(err error) int {
+		return http.StatusTeapot
+	}))
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://localhost/", nil))
+	if rec.Code != http.StatusTeapot {
+		t.Errorf("expect status 418 for custom mapping, got %d", rec.Code)
+	}
+}
+
+// TestHandler_Server tests the handler serves a large body via a real server with the default factory.
+func TestHandler_Server(t *testing.T) {
+	h := starbox.Handler(`response.set_data("x" * 100000)`, nil)
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	for i := 0; i < 3; i++ {
+		resp, err := http.Get(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusOK || len(data) != 100000 || resp.ContentLength != 100000 {
+			t.Errorf("unexpected response: %d, %d bytes, length %d", resp.StatusCode, len(data), resp.ContentLength)
+		}
+		if ct := resp.Header.Get("Content-Type"); ct != "application/octet-stream" {
+			t.Errorf("unexpected content type: %q", ct)
+		}
+	}
+}
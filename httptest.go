@@ -0,0 +1,96 @@
+package starbox
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/1set/starlet"
+)
+
+// HTTPTestRequest describes an HTTP request for testing scripts without a server.
+type HTTPTestRequest struct {
+	Method string            // Method is the HTTP method, GET if empty.
+	URL    string            // URL is the request target, either a path like "/users?id=1" or an absolute URL, "/" if empty.
+	Header map[string]string // Header is the request headers.
+	Body   string            // Body is the request body.
+}
+
+// Request converts the description to an incoming server request like httptest.NewRequest().
+func (t HTTPTestRequest) Request() *http.Request {
+	method := t.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	target := t.URL
+	if target == "" {
+		target = "/"
+	}
+	var body io.Reader
+	if t.Body != "" {
+		body = strings.NewReader(t.Body)
+	}
+	req := httptest.NewRequest(method, target, body)
+	for k, v := range t.Header {
+		req.Header.Set(k, v)
+	}
+	return req
+}
+
+// HTTPTestResult is the response of a script or handler for an HTTP test request.
+type HTTPTestResult struct {
+	StatusCode int                  // StatusCode is the status code of the response.
+	Header     http.Header          // Header is the headers of the response.
+	Body       []byte               // Body is the body of the response.
+	Output     starlet.StringAnyMap // Output is the converted output of the script, only set by RunHTTP().
+}
+
+// Text returns the body of the response as a string.
+func (r *HTTPTestResult) Text() string {
+	return string(r.Body)
+}
+
+// JSON decodes the body of the response as JSON into a Go value.
+func (r *HTTPTestResult) JSON() (interface{}, error) {
+	var v interface{}
+	err := json.Unmarshal(r.Body, &v)
+	return v, err
+}
+
+// newHTTPTestResult returns the result recorded by the recorder.
+func newHTTPTestResult(rec *httptest.ResponseRecorder) *HTTPTestResult {
+	res := rec.Result()
+	defer res.Body.Close()
+	return &HTTPTestResult{
+		StatusCode: res.StatusCode,
+		Header:     res.Header,
+		Body:       rec.Body.Bytes(),
+	}
+}
+
+// RunHTTP executes a script with the HTTP context of the test request added via AddHTTPContext(), and returns the response written by the script via the global `response`.
+// If the script fails, it returns the error and a nil result.
+// It panics if called after execution, as AddHTTPContext() does.
+func (s *Starbox) RunHTTP(script string, req HTTPTestRequest) (*HTTPTestResult, error) {
+	resp := s.AddHTTPContext(req.Request())
+	out, err := s.Run(script)
+	if err != nil {
+		return nil, err
+	}
+	rec := httptest.NewRecorder()
+	if err := writeResponse(rec, resp); err != nil {
+		return nil, err
+	}
+	res := newHTTPTestResult(rec)
+	res.Output = out
+	return res, nil
+}
+
+// ServeHTTPTest serves the test request with the handler, e.g. created by Handler(), Router() or Middleware(), and returns the recorded response.
+func ServeHTTPTest(h http.Handler, req HTTPTestRequest) *HTTPTestResult {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req.Request())
+	return newHTTPTestResult(rec)
+}
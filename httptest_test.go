@@ -0,0 +1,67 @@
+package starbox_test
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/PureMature/starbox"
+)
+
+// TestRunHTTP tests the following:
+// 1. Create a new Starbox instance, and run a script with a test request.
+// 2. Check the status, headers, body and output of the result.
+// 3. Check the script error is returned.
+func TestRunHTTP(t *testing.T) {
+	b := starbox.New("test")
+	res, err := b.RunHTTP(HereDoc(`
+		data = request.json
+		response.add_header("X-Method", request.method)
+		response.set_status(202)
+		response.set_json({"name": data["name"], "q": request.query["q"][0], "h": request.header["X-Token"][0]})
+	`), starbox.HTTPTestRequest{
+		Method: "POST",
+		URL:    "/items?q=search",
+		Header: map[string]string{"X-Token": "abc"},
+		Body:   `{"name": "Aloha"}`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusAccepted {
+		t.Errorf("unexpected status: %d", res.StatusCode)
+	}
+	if v := res.Header.Get("X-Method"); v != "POST" {
+		t.Errorf("unexpected header: %q", v)
+	}
+	if v := res.Header.Get("Content-Type"); v != "application/json" {
+		t.Errorf("unexpected content type: %q", v)
+	}
+	js, err := res.JSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := map[string]interface{}{"name": "Aloha", "q": "search", "h": "abc"}; !reflect.DeepEqual(js, expected) {
+		t.Errorf("unexpected body: %s", res.Text())
+	}
+	if res.Output["data"] == nil {
+		t.Errorf("unexpected output: %v", res.Output)
+	}
+
+	if _, err := starbox.New("test").RunHTTP(`fail("oops")`, starbox.HTTPTestRequest{}); err == nil {
+		t.Error("expect error, got nil")
+	}
+}
+
+// TestServeHTTPTest tests the helper with the handler from Router().
+func TestServeHTTPTest(t *testing.T) {
+	h := starbox.Router(`route("GET", "/hi/{name}", lambda req, resp: resp.set_text("hi " + req.params["name"]))`, nil)
+	res := starbox.ServeHTTPTest(h, starbox.HTTPTestRequest{URL: "/hi/bob"})
+	if res.StatusCode != http.StatusOK || res.Text() != "hi bob" || res.Output != nil {
+		t.Errorf("unexpected result: %d %q", res.StatusCode, res.Text())
+	}
+	res = starbox.ServeHTTPTest(h, starbox.HTTPTestRequest{Method: "DELETE", URL: "http://example.com/hi/bob"})
+	if res.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("unexpected status: %d", res.StatusCode)
+	}
+}
@@ -0,0 +1,168 @@
+package starbox
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io/fs"
+	"sync"
+
+	"github.com/1set/starlet"
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+// moduleLoadChainKey is the thread local key of the chain of loading module scripts, which the thread belongs to.
+const moduleLoadChainKey = "starbox_load_chain"
+
+// moduleLoadFunc is the signature of the Load function of Starlark threads.
+type moduleLoadFunc func(thread *starlark.Thread, module string) (starlark.StringDict, error)
+
+// moduleLoader wraps the loader of Starlet to load module scripts from the filesystem of the box, it compiles them with the script cache and runs them on threads prepared by the hook, e.g. for the debugger.
+// Builtin and custom modules and missing files are left to the loader of Starlet.
+type moduleLoader struct {
+	orig        moduleLoadFunc
+	lazyMods    starlet.ModuleLoaderMap
+	modFS       fs.FS
+	predeclared starlark.StringDict
+	cache       ScriptCache
+	hook        func(parent, child *starlark.Thread, name string, src []byte)
+
+	mu      sync.Mutex
+	entries map[string]*moduleLoadEntry
+}
+
+// moduleLoadEntry is the result of loading a module script, and the chain loading it until it's done.
+type moduleLoadEntry struct {
+	owner   *moduleLoadChain
+	ready   chan struct{}
+	globals starlark.StringDict
+	err     error
+}
+
+// moduleLoadChain is a chain of threads loading module scripts one by one, and the entry it's waiting for to detect cycles across chains.
+type moduleLoadChain struct {
+	waitsFor *moduleLoadEntry
+}
+
+// attachLoader wraps the loader of the thread to load module scripts before the first run.
+// Starlet creates the thread and the predeclared names only when a script runs, so an empty script is run directly to prepare them, it bypasses the script cache and executes nothing, and the script of the box is set back.
+func (s *Starbox) attachLoader(script []byte, lazyMods starlet.ModuleLoaderMap, modFS fs.FS) error {
+	if _, err := s.mac.RunScript([]byte(""), nil); err != nil {
+		return err
+	}
+	s.mac.SetScript(mainScriptName, script, modFS)
+
+	thread := s.mac.GetStarlarkThread()
+	s.loader = &moduleLoader{
+		orig:        thread.Load,
+		lazyMods:    lazyMods,
+		modFS:       modFS,
+		predeclared: s.mac.GetStarlarkPredeclared(),
+		cache:       s.scriptCache,
+		entries:     make(map[string]*moduleLoadEntry),
+	}
+	thread.Load = s.loader.load
+	return nil
+}
+
+// load loads the module script once for the machine like Starlet, so the globals of it are kept across runs, waits for the other threads loading the same module, and reports cycles in the load graph.
+func (l *moduleLoader) load(thread *starlark.Thread, module string) (starlark.StringDict, error) {
+	if _, ok := l.lazyMods[module]; ok || l.modFS == nil {
+		return l.orig(thread, module)
+	}
+	name := normalizeScriptName(module)
+	src, err := fs.ReadFile(l.modFS, name)
+	if err != nil {
+		return l.orig(thread, module)
+	}
+
+	// threads not loading modules start a new chain
+	cc, _ := thread.Local(moduleLoadChainKey).(*moduleLoadChain)
+	if cc == nil {
+		cc = &moduleLoadChain{}
+	}
+
+	l.mu.Lock()
+	e, ok := l.entries[name]
+	if !ok {
+		e = &moduleLoadEntry{owner: cc, ready: make(chan struct{})}
+		l.entries[name] = e
+		l.mu.Unlock()
+
+		e.globals, e.err = l.exec(thread, cc, name, src)
+
+		l.mu.Lock()
+		e.owner = nil
+		l.mu.Unlock()
+		close(e.ready)
+		return e.globals, e.err
+	}
+
+	// it's a cycle if the chain loading the module waits for this chain
+	for o := e.owner; o != nil; {
+		if o == cc {
+			l.mu.Unlock()
+			return nil, fmt.Errorf("cycle in load graph: %s", name)
+		}
+		if o.waitsFor == nil {
+			break
+		}
+		o = o.waitsFor.owner
+	}
+	cc.waitsFor = e
+	l.mu.Unlock()
+
+	<-e.ready
+
+	l.mu.Lock()
+	cc.waitsFor = nil
+	l.mu.Unlock()
+	return e.globals, e.err
+}
+
+// exec executes the compiled module script on a new thread like Starlet, and the thread shares the chain and context of the loading thread.
+func (l *moduleLoader) exec(thread *starlark.Thread, cc *moduleLoadChain, name string, src []byte) (starlark.StringDict, error) {
+	prog, err := l.compile(name, src)
+	if err != nil {
+		return nil, err
+	}
+	child := &starlark.Thread{
+		Name:  "load " + name,
+		Print: thread.Print,
+		Load:  thread.Load,
+	}
+	child.SetLocal(moduleLoadChainKey, cc)
+	child.SetLocal("context", thread.Local("context"))
+	if l.hook != nil {
+		l.hook(thread, child, name, src)
+	}
+	globals, err := prog.Init(child, l.predeclared)
+	globals.Freeze()
+	return globals, err
+}
+
+// compile returns the compiled program of the module script from the script cache, or compiles it with the same options as Starlet and saves it into the cache.
+// The cache key contains the file name besides the content, since the positions in compiled programs refer to it.
+func (l *moduleLoader) compile(name string, src []byte) (*starlark.Program, error) {
+	key := fmt.Sprintf("%d:%s:%x", starlark.CompilerVersion, name, sha256.Sum256(src))
+	if l.cache != nil {
+		if b, ok := l.cache.Get(key); ok {
+			if prog, err := starlark.CompiledProgram(bytes.NewReader(b)); err == nil {
+				return prog, nil
+			}
+		}
+	}
+
+	_, prog, err := starlark.SourceProgramOptions(syntax.LegacyFileOptions(), name, src, l.predeclared.Has)
+	if err != nil {
+		return nil, err
+	}
+	if l.cache != nil {
+		var buf bytes.Buffer
+		if err := prog.Write(&buf); err == nil {
+			_ = l.cache.Set(key, buf.Bytes())
+		}
+	}
+	return prog, nil
+}
@@ -0,0 +1,388 @@
+package starbox
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/1set/starlet/dataconv"
+	stdjson "go.starlark.net/lib/json"
+	"go.starlark.net/starlark"
+)
+
+// MemoryFormat defines the encoding format of collective memory snapshots.
+type MemoryFormat string
+
+const (
+	// MemoryFormatJSON represents the JSON format, it's human-readable but only supports JSON-compatible values, and tuples become lists after restoring.
+	MemoryFormatJSON MemoryFormat = "json"
+	// MemoryFormatBinary represents the binary format based on gob encoding, it keeps the types of all the supported values, including tuples, sets, bytes and big integers.
+	MemoryFormatBinary MemoryFormat = "binary"
+)
+
+// memoryMagic is the header of memory snapshots in binary format.
+var memoryMagic = []byte("SBXM\x01")
+
+// SnapshotMemory writes a snapshot of the collective memory to the writer in the given format.
+// It fails if the memory contains values that can't be encoded, e.g. functions.
+func SnapshotMemory(w io.Writer, memory *dataconv.SharedDict, format MemoryFormat) error {
+	if memory == nil {
+		return errors.New("nil memory")
+	}
+	d, err := memory.CloneDict()
+	if err != nil {
+		return err
+	}
+
+	switch format {
+	case MemoryFormatJSON:
+		// encode with the Starlark JSON module to support big integers
+		enc := stdjson.Module.Members["encode"]
+		thread := &starlark.Thread{Name: "memory"}
+		s, err := starlark.Call(thread, enc, starlark.Tuple{d}, nil)
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(w, string(s.(starlark.String)))
+		return err
+	case MemoryFormatBinary:
+		mv, err := encodeMemoryValue(d)
+		if err != nil {
+			return err
+		}
+		if _, err = w.Write(memoryMagic); err != nil {
+			return err
+		}
+		return gob.NewEncoder(w).Encode(mv)
+	default:
+		return fmt.Errorf("unknown memory format: %s", format)
+	}
+}
+
+// RestoreMemory reads a snapshot in the given format from the reader and merges it into the collective memory, keys in the snapshot overwrite the existing ones.
+func RestoreMemory(r io.Reader, memory *dataconv.SharedDict, format MemoryFormat) error {
+	if memory == nil {
+		return errors.New("nil memory")
+	}
+
+	var val starlark.Value
+	switch format {
+	case MemoryFormatJSON:
+		b, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		// decode with the Starlark JSON module to support big integers
+		dec := stdjson.Module.Members["decode"]
+		thread := &starlark.Thread{Name: "memory"}
+		if val, err = starlark.Call(thread, dec, starlark.Tuple{starlark.String(b)}, nil); err != nil {
+			return err
+		}
+	case MemoryFormatBinary:
+		head := make([]byte, len(memoryMagic))
+		if _, err := io.ReadFull(r, head); err != nil {
+			return err
+		}
+		if !bytes.Equal(head, memoryMagic) {
+			return errors.New("invalid memory snapshot header")
+		}
+		var mv memoryValue
+		if err := gob.NewDecoder(r).Decode(&mv); err != nil {
+			return err
+		}
+		var err error
+		if val, err = mv.decode(); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown memory format: %s", format)
+	}
+
+	d, ok := val.(*starlark.Dict)
+	if !ok {
+		return fmt.Errorf("got %s, want dict", val.Type())
+	}
+	for _, kv := range d.Items() {
+		if err := memory.SetKey(kv[0], kv[1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SaveMemoryFile writes a snapshot of the collective memory to the file in the given format.
+// The file is replaced atomically, so a crash during saving never leaves a partial snapshot.
+func SaveMemoryFile(path string, memory *dataconv.SharedDict, format MemoryFormat) error {
+	var buf bytes.Buffer
+	if err := SnapshotMemory(&buf, memory, format); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, buf.Bytes())
+}
+
+// LoadMemoryFile creates a new collective memory and restores it from the snapshot file in the given format.
+// If the file doesn't exist, it returns an empty memory, so it can be used as a load-at-startup helper for the first deployment as well.
+func LoadMemoryFile(path string, format MemoryFormat) (*dataconv.SharedDict, error) {
+	memory := NewMemory()
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return memory, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	if err = RestoreMemory(f, memory, format); err != nil {
+		return nil, err
+	}
+	return memory, nil
+}
+
+// AutoSaveMemory saves snapshots of the collective memory to the file in the given format periodically, and only when the content changes.
+// It returns a stop function to stop auto-saving, the stop function saves the final snapshot and returns the error if any.
+// It returns an error without starting if the memory is nil or the interval is not positive.
+func AutoSaveMemory(path string, memory *dataconv.SharedDict, format MemoryFormat, interval time.Duration) (stop func() error, err error) {
+	if memory == nil {
+		return nil, errors.New("nil memory")
+	}
+	if interval <= 0 {
+		return nil, fmt.Errorf("invalid auto-save interval: %v", interval)
+	}
+
+	var (
+		mu       sync.Mutex
+		lastSave []byte
+		done     = make(chan struct{})
+		wg       sync.WaitGroup
+		once     sync.Once
+	)
+	save := func() error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		var buf bytes.Buffer
+		if err := SnapshotMemory(&buf, memory, format); err != nil {
+			return err
+		}
+		if lastSave != nil && bytes.Equal(lastSave, buf.Bytes()) {
+			return nil
+		}
+		if err := writeFileAtomic(path, buf.Bytes()); err != nil {
+			return err
+		}
+		lastSave = buf.Bytes()
+		return nil
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := save(); err != nil {
+					log.Warnf("failed to auto-save memory to %s: %v", path, err)
+				}
+			}
+		}
+	}()
+
+	return func() (err error) {
+		once.Do(func() {
+			close(done)
+			wg.Wait()
+			err = save()
+		})
+		return
+	}, nil
+}
+
+// writeFileAtomic writes data to a temporary file in the same directory and renames it to the given path.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tn := tmp.Name()
+	if _, err = tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tn)
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		_ = os.Remove(tn)
+		return err
+	}
+	if err = os.Rename(tn, path); err != nil {
+		_ = os.Remove(tn)
+		return err
+	}
+	return nil
+}
+
+// memoryValue is the gob-friendly representation of Starlark values in binary memory snapshots.
+type memoryValue struct {
+	Kind  string
+	Str   string
+	Bytes []byte
+	Float float64
+	Bool  bool
+	Items []*memoryValue
+}
+
+// encodeMemoryValue converts a Starlark value into the binary snapshot representation, it fails if the value contains itself like the JSON format.
+func encodeMemoryValue(v starlark.Value) (*memoryValue, error) {
+	return encodeMemoryValueIn(v, make(map[starlark.Value]bool))
+}
+
+// encodeMemoryValueIn converts a Starlark value into the binary snapshot representation, and the containers being encoded are tracked in the path to detect cycles.
+func encodeMemoryValueIn(v starlark.Value, path map[starlark.Value]bool) (*memoryValue, error) {
+	switch v.(type) {
+	case *starlark.List, *starlark.Dict, *starlark.Set:
+		if path[v] {
+			return nil, fmt.Errorf("cycle in memory snapshot: %s", v.Type())
+		}
+		path[v] = true
+		defer delete(path, v)
+	}
+
+	encodeAll := func(kind string, it starlark.Iterable) (*memoryValue, error) {
+		mv := &memoryValue{Kind: kind}
+		iter := it.Iterate()
+		defer iter.Done()
+		var x starlark.Value
+		for iter.Next(&x) {
+			ev, err := encodeMemoryValueIn(x, path)
+			if err != nil {
+				return nil, err
+			}
+			mv.Items = append(mv.Items, ev)
+		}
+		return mv, nil
+	}
+
+	switch v := v.(type) {
+	case starlark.NoneType:
+		return &memoryValue{Kind: "none"}, nil
+	case starlark.Bool:
+		return &memoryValue{Kind: "bool", Bool: bool(v)}, nil
+	case starlark.Int:
+		return &memoryValue{Kind: "int", Str: v.String()}, nil
+	case starlark.Float:
+		return &memoryValue{Kind: "float", Float: float64(v)}, nil
+	case starlark.String:
+		return &memoryValue{Kind: "string", Str: string(v)}, nil
+	case starlark.Bytes:
+		return &memoryValue{Kind: "bytes", Bytes: []byte(v)}, nil
+	case *starlark.List:
+		return encodeAll("list", v)
+	case starlark.Tuple:
+		return encodeAll("tuple", v)
+	case *starlark.Set:
+		return encodeAll("set", v)
+	case *starlark.Dict:
+		mv := &memoryValue{Kind: "dict"}
+		for _, kv := range v.Items() {
+			ek, err := encodeMemoryValueIn(kv[0], path)
+			if err != nil {
+				return nil, err
+			}
+			ev, err := encodeMemoryValueIn(kv[1], path)
+			if err != nil {
+				return nil, err
+			}
+			mv.Items = append(mv.Items, ek, ev)
+		}
+		return mv, nil
+	default:
+		return nil, fmt.Errorf("unsupported value type for memory snapshot: %s", v.Type())
+	}
+}
+
+// decode converts the binary snapshot representation back to a Starlark value.
+func (mv *memoryValue) decode() (starlark.Value, error) {
+	decodeAll := func() ([]starlark.Value, error) {
+		vs := make([]starlark.Value, 0, len(mv.Items))
+		for _, it := range mv.Items {
+			v, err := it.decode()
+			if err != nil {
+				return nil, err
+			}
+			vs = append(vs, v)
+		}
+		return vs, nil
+	}
+
+	switch mv.Kind {
+	case "none":
+		return starlark.None, nil
+	case "bool":
+		return starlark.Bool(mv.Bool), nil
+	case "int":
+		n, ok := new(big.Int).SetString(mv.Str, 10)
+		if !ok {
+			return nil, fmt.Errorf("invalid int in memory snapshot: %q", mv.Str)
+		}
+		return starlark.MakeBigInt(n), nil
+	case "float":
+		return starlark.Float(mv.Float), nil
+	case "string":
+		return starlark.String(mv.Str), nil
+	case "bytes":
+		return starlark.Bytes(mv.Bytes), nil
+	case "list":
+		vs, err := decodeAll()
+		if err != nil {
+			return nil, err
+		}
+		return starlark.NewList(vs), nil
+	case "tuple":
+		vs, err := decodeAll()
+		if err != nil {
+			return nil, err
+		}
+		return starlark.Tuple(vs), nil
+	case "set":
+		vs, err := decodeAll()
+		if err != nil {
+			return nil, err
+		}
+		set := starlark.NewSet(len(vs))
+		for _, v := range vs {
+			if err := set.Insert(v); err != nil {
+				return nil, err
+			}
+		}
+		return set, nil
+	case "dict":
+		vs, err := decodeAll()
+		if err != nil {
+			return nil, err
+		}
+		if len(vs)%2 != 0 {
+			return nil, errors.New("invalid dict in memory snapshot")
+		}
+		d := starlark.NewDict(len(vs) / 2)
+		for i := 0; i < len(vs); i += 2 {
+			if err := d.SetKey(vs[i], vs[i+1]); err != nil {
+				return nil, err
+			}
+		}
+		return d, nil
+	default:
+		return nil, fmt.Errorf("unknown value kind in memory snapshot: %q", mv.Kind)
+	}
+}
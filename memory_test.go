@@ -0,0 +1,185 @@
+package starbox_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/PureMature/starbox"
+	"go.starlark.net/starlark"
+)
+
+// TestSnapshotMemory tests the following:
+// 1. Create a collective memory and fill it via script.
+// 2. Snapshot the memory in JSON and binary formats.
+// 3. Restore the snapshots into new memories.
+// 4. Check the values are restored, and the types are kept in binary format.
+func TestSnapshotMemory(t *testing.T) {
+	b := starbox.New("test")
+	mem := b.CreateMemory("share")
+	if _, err := b.Run(HereDoc(`
+		share["count"] = 12345678901234567890
+		share["name"] = "Aloha"
+		share["pi"] = 3.14
+		share["list"] = [1, "two", None, True]
+		share["tuple"] = (1, 2)
+		share["nested"] = {"a": {"b": [1]}}
+	`)); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		format    starbox.MemoryFormat
+		tupleType string
+	}{
+		{starbox.MemoryFormatJSON, "list"},
+		{starbox.MemoryFormatBinary, "tuple"},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.format), func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := starbox.SnapshotMemory(&buf, mem, tt.format); err != nil {
+				t.Fatal(err)
+			}
+			restored := starbox.NewMemory()
+			if err := starbox.RestoreMemory(&buf, restored, tt.format); err != nil {
+				t.Fatal(err)
+			}
+			if restored.Len() != mem.Len() {
+				t.Errorf("expect %d keys, got %d", mem.Len(), restored.Len())
+			}
+
+			b2 := starbox.New("test2")
+			b2.AttachMemory("share", restored)
+			out, err := b2.Run(HereDoc(`
+				count = str(share["count"])
+				name = share["name"]
+				tt = type(share["tuple"])
+				deep = share["nested"]["a"]["b"][0]
+			`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ev := "12345678901234567890"; out["count"] != ev {
+				t.Errorf("unexpected count: %v", out["count"])
+			}
+			if out["name"] != "Aloha" || out["tt"] != tt.tupleType || out["deep"] != int64(1) {
+				t.Errorf("unexpected output: %v", out)
+			}
+		})
+	}
+
+	// errors
+	if err := starbox.SnapshotMemory(&bytes.Buffer{}, mem, "xml"); err == nil {
+		t.Error("expect error for unknown format, got nil")
+	}
+	if err := starbox.RestoreMemory(bytes.NewBufferString("[1]"), starbox.NewMemory(), starbox.MemoryFormatJSON); err == nil {
+		t.Error("expect error for non-dict snapshot, got nil")
+	}
+	if err := starbox.RestoreMemory(bytes.NewBufferString("not a snapshot"), starbox.NewMemory(), starbox.MemoryFormatBinary); err == nil {
+		t.Error("expect error for invalid binary snapshot, got nil")
+	}
+	bad := starbox.NewMemory()
+	_ = bad.SetKey(starlark.String("fn"), starlark.NewBuiltin("fn", nil))
+	if err := starbox.SnapshotMemory(&bytes.Buffer{}, bad, starbox.MemoryFormatBinary); err == nil {
+		t.Error("expect error for unsupported value, got nil")
+	}
+}
+
+// TestSnapshotMemory_Cycle tests the following:
+// 1. Store a self-referencing list and dict into collective memories via script.
+// 2. Check the snapshots in both formats fail with errors instead of crashing.
+// 3. Check values shared without cycles are still encoded.
+func TestSnapshotMemory_Cycle(t *testing.T) {
+	for _, script := range []string{
+		"l = [1]\nl.append(l)\nm['l'] = l",
+		"d = {'a': 1}\nd['self'] = [d]\nm['d'] = d",
+	} {
+		mem := starbox.NewMemory()
+		b := starbox.New("test")
+		b.AttachMemory("m", mem)
+		if _, err := b.Run(script); err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range []starbox.MemoryFormat{starbox.MemoryFormatJSON, starbox.MemoryFormatBinary} {
+			var buf bytes.Buffer
+			if err := starbox.SnapshotMemory(&buf, mem, f); err == nil {
+				t.Errorf("expect error for cycle in %s format of %q, got nil", f, script)
+			}
+		}
+	}
+
+	mem := starbox.NewMemory()
+	b := starbox.New("test")
+	b.AttachMemory("m", mem)
+	if _, err := b.Run("l = [1]\nm['x'] = [l, l, {'k': l}]"); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := starbox.SnapshotMemory(&buf, mem, starbox.MemoryFormatBinary); err != nil {
+		t.Errorf("expect shared values encoded, got %v", err)
+	}
+}
+
+// TestMemoryFile tests the following:
+// 1. Load memory from a missing file, and expect an empty memory.
+// 2. Check invalid intervals are rejected, start auto-saving the memory, and update it via script.
+// 3. Stop auto-saving, and load the memory from the file again.
+// 4. Check the values persist.
+func TestMemoryFile(t *testing.T) {
+	fp := filepath.Join(t.TempDir(), "memory.bin")
+	mem, err := starbox.LoadMemoryFile(fp, starbox.MemoryFormatBinary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mem.Len() != 0 {
+		t.Errorf("expect empty memory, got %v", mem)
+	}
+
+	for _, d := range []time.Duration{0, -time.Second} {
+		if _, err := starbox.AutoSaveMemory(fp, mem, starbox.MemoryFormatBinary, d); err == nil {
+			t.Errorf("expect error for interval %v, got nil", d)
+		}
+	}
+	if _, err := starbox.AutoSaveMemory(fp, nil, starbox.MemoryFormatBinary, time.Second); err == nil {
+		t.Error("expect error for nil memory, got nil")
+	}
+	stop, err := starbox.AutoSaveMemory(fp, mem, starbox.MemoryFormatBinary, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := starbox.New("test")
+	b.AttachMemory("state", mem)
+	if _, err := b.Run(`state["counter"] = state.get("counter", 0) + 1`); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if _, err := os.Stat(fp); err != nil {
+		t.Errorf("expect snapshot file saved, got %v", err)
+	}
+	if err := stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := starbox.LoadMemoryFile(fp, starbox.MemoryFormatBinary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, ok, _ := loaded.Get(starlark.String("counter")); !ok || v != starlark.MakeInt(1) {
+		t.Errorf("expect counter=1, got %v", v)
+	}
+
+	// save and load in JSON format
+	jp := filepath.Join(t.TempDir(), "memory.json")
+	if err := starbox.SaveMemoryFile(jp, loaded, starbox.MemoryFormatJSON); err != nil {
+		t.Fatal(err)
+	}
+	if bs, _ := os.ReadFile(jp); string(bs) != `{"counter":1}` {
+		t.Errorf("unexpected json snapshot: %s", bs)
+	}
+	if _, err := starbox.LoadMemoryFile(jp, starbox.MemoryFormatBinary); err == nil {
+		t.Error("expect error for mismatched format, got nil")
+	}
+}
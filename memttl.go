@@ -0,0 +1,430 @@
+package starbox
+
+import (
+	"container/list"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/1set/starlet"
+	"github.com/1set/starlet/dataconv"
+	stdtime "go.starlark.net/lib/time"
+	"go.starlark.net/starlark"
+)
+
+const (
+	ttlMemoryTypeName = "ttl_memory"
+)
+
+// TTLMemory is a collective memory with per-key time-to-live and LRU eviction, it can be shared among multiple Starbox instances like the shared dictionary created by NewMemory().
+// Expired entries are removed lazily on access or by Purge(), and the least recently used entries are evicted when the number of entries exceeds the limit.
+type TTLMemory struct {
+	mu         sync.Mutex
+	maxEntries int
+	defaultTTL time.Duration
+	index      *starlark.Dict // key -> *ttlEntry
+	lru        *list.List     // front is the most recently used, values are *ttlEntry
+	now        func() time.Time
+}
+
+// ttlEntry is an entry of TTLMemory, it's a Starlark value only to be stored in the index dictionary.
+type ttlEntry struct {
+	key      starlark.Value
+	value    starlark.Value
+	expireAt time.Time // zero means no expiry
+	elem     *list.Element
+}
+
+func (e *ttlEntry) String() string        { return fmt.Sprintf("ttl_entry(%s)", e.key) }
+func (e *ttlEntry) Type() string          { return "ttl_entry" }
+func (e *ttlEntry) Freeze()               {}
+func (e *ttlEntry) Truth() starlark.Bool  { return true }
+func (e *ttlEntry) Hash() (uint32, error) { return 0, fmt.Errorf("unhashable type: ttl_entry") }
+
+var (
+	_ starlark.Value     = (*TTLMemory)(nil)
+	_ starlark.Mapping   = (*TTLMemory)(nil)
+	_ starlark.HasSetKey = (*TTLMemory)(nil)
+	_ starlark.HasAttrs  = (*TTLMemory)(nil)
+	_ starlark.Sequence  = (*TTLMemory)(nil)
+)
+
+// NewTTLMemory creates a new collective memory with the given maximum number of entries and default time-to-live for entries set without TTL.
+// Zero or negative maxEntries means no limit, and zero or negative defaultTTL means entries never expire by default.
+func NewTTLMemory(maxEntries int, defaultTTL time.Duration) *TTLMemory {
+	return &TTLMemory{
+		maxEntries: maxEntries,
+		defaultTTL: defaultTTL,
+		index:      starlark.NewDict(8),
+		lru:        list.New(),
+		now:        time.Now,
+	}
+}
+
+// String returns the string representation of the memory.
+func (m *TTLMemory) String() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	d := starlark.NewDict(m.lru.Len())
+	for el := m.lru.Back(); el != nil; el = el.Prev() {
+		e := el.Value.(*ttlEntry)
+		if !m.expired(e) {
+			_ = d.SetKey(e.key, e.value)
+		}
+	}
+	return fmt.Sprintf("%s(%s)", ttlMemoryTypeName, d.String())
+}
+
+// Type returns the type name of the memory.
+func (m *TTLMemory) Type() string { return ttlMemoryTypeName }
+
+// Freeze does nothing, the memory is always mutable to be shared among boxes.
+func (m *TTLMemory) Freeze() {}
+
+// Truth returns true if the memory has any live entry.
+func (m *TTLMemory) Truth() starlark.Bool { return m.Len() > 0 }
+
+// Hash returns an error, the memory is not hashable.
+func (m *TTLMemory) Hash() (uint32, error) {
+	return 0, fmt.Errorf("unhashable type: %s", ttlMemoryTypeName)
+}
+
+// Get returns the value of the key if it's not expired, and marks it as recently used.
+// It implements the starlark.Mapping interface.
+func (m *TTLMemory) Get(k starlark.Value) (starlark.Value, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, err := m.lookup(k)
+	if err != nil || e == nil {
+		return nil, false, err
+	}
+	m.lru.MoveToFront(e.elem)
+	return e.value, true, nil
+}
+
+// SetKey sets the value of the key with the default time-to-live.
+// It implements the starlark.HasSetKey interface.
+func (m *TTLMemory) SetKey(k, v starlark.Value) error {
+	return m.SetWithTTL(k, v, m.defaultTTL)
+}
+
+// SetWithTTL sets the value of the key with the given time-to-live, zero or negative ttl means the entry never expires.
+func (m *TTLMemory) SetWithTTL(k, v starlark.Value, ttl time.Duration) error {
+	switch v.(type) {
+	case *TTLMemory, *dataconv.SharedDict:
+		return fmt.Errorf("unsupported value: %s", v.Type())
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, err := m.lookup(k)
+	if err != nil {
+		return err
+	}
+	if e == nil {
+		e = &ttlEntry{key: k}
+		if err := m.index.SetKey(k, e); err != nil {
+			return err
+		}
+		e.elem = m.lru.PushFront(e)
+	} else {
+		m.lru.MoveToFront(e.elem)
+	}
+	e.value = v
+	e.expireAt = m.deadline(ttl)
+
+	// evict the least recently used entries
+	for m.maxEntries > 0 && m.lru.Len() > m.maxEntries {
+		m.remove(m.lru.Back().Value.(*ttlEntry))
+	}
+	return nil
+}
+
+// TTL returns the remaining time-to-live of the key, and whether the key exists. It returns -1 for entries never expire.
+func (m *TTLMemory) TTL(k starlark.Value) (time.Duration, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, err := m.lookup(k)
+	if err != nil || e == nil {
+		return 0, false
+	}
+	if e.expireAt.IsZero() {
+		return -1, true
+	}
+	return e.expireAt.Sub(m.now()), true
+}
+
+// Expire sets the time-to-live of the key, zero or negative ttl means the entry never expires. It returns false if the key doesn't exist.
+func (m *TTLMemory) Expire(k starlark.Value, ttl time.Duration) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, err := m.lookup(k)
+	if err != nil || e == nil {
+		return false
+	}
+	e.expireAt = m.deadline(ttl)
+	return true
+}
+
+// Delete removes the key, and returns whether the key existed.
+func (m *TTLMemory) Delete(k starlark.Value) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, err := m.lookup(k)
+	if err != nil || e == nil {
+		return false
+	}
+	m.remove(e)
+	return true
+}
+
+// Len returns the number of live entries.
+func (m *TTLMemory) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.purge()
+	return m.lru.Len()
+}
+
+// Purge removes all the expired entries, and returns the number of removed entries.
+func (m *TTLMemory) Purge() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.purge()
+}
+
+// Iterate returns an iterator over a snapshot of the live keys from the least recently used to the most.
+// It implements the starlark.Iterable interface.
+func (m *TTLMemory) Iterate() starlark.Iterator {
+	return starlark.NewList(m.keys()).Iterate()
+}
+
+// Attr returns the method of the memory with the given name.
+// It implements the starlark.HasAttrs interface.
+func (m *TTLMemory) Attr(name string) (starlark.Value, error) {
+	fn, ok := ttlMemoryMethods[name]
+	if !ok {
+		return nil, nil
+	}
+	return starlark.NewBuiltin(name, fn).BindReceiver(m), nil
+}
+
+// AttrNames returns the names of the methods of the memory.
+// It implements the starlark.HasAttrs interface.
+func (m *TTLMemory) AttrNames() []string {
+	names := make([]string, 0, len(ttlMemoryMethods))
+	for n := range ttlMemoryMethods {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// lookup finds the live entry of the key, and removes it if expired. It must be called with the lock held.
+func (m *TTLMemory) lookup(k starlark.Value) (*ttlEntry, error) {
+	v, found, err := m.index.Get(k)
+	if err != nil || !found {
+		return nil, err
+	}
+	e := v.(*ttlEntry)
+	if m.expired(e) {
+		m.remove(e)
+		return nil, nil
+	}
+	return e, nil
+}
+
+func (m *TTLMemory) expired(e *ttlEntry) bool {
+	return !e.expireAt.IsZero() && !m.now().Before(e.expireAt)
+}
+
+func (m *TTLMemory) deadline(ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return m.now().Add(ttl)
+}
+
+func (m *TTLMemory) remove(e *ttlEntry) {
+	_, _, _ = m.index.Delete(e.key)
+	m.lru.Remove(e.elem)
+}
+
+func (m *TTLMemory) purge() int {
+	var cnt int
+	for el := m.lru.Back(); el != nil; {
+		prev := el.Prev()
+		if e := el.Value.(*ttlEntry); m.expired(e) {
+			m.remove(e)
+			cnt++
+		}
+		el = prev
+	}
+	return cnt
+}
+
+func (m *TTLMemory) keys() []starlark.Value {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.purge()
+	ks := make([]starlark.Value, 0, m.lru.Len())
+	for el := m.lru.Back(); el != nil; el = el.Prev() {
+		ks = append(ks, el.Value.(*ttlEntry).key)
+	}
+	return ks
+}
+
+var ttlMemoryMethods = map[string]func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error){
+	"get":          ttlMemoryGet,
+	"set":          ttlMemorySet,
+	"set_with_ttl": ttlMemorySetWithTTL,
+	"ttl":          ttlMemoryTTL,
+	"expire":       ttlMemoryExpire,
+	"delete":       ttlMemoryDelete,
+	"keys":         ttlMemoryKeys,
+	"len":          ttlMemoryLen,
+}
+
+// ttlMemoryGet returns the value of the key, or the default value if not found or expired.
+func ttlMemoryGet(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var k, d starlark.Value = nil, starlark.None
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "key", &k, "default?", &d); err != nil {
+		return nil, err
+	}
+	v, found, err := b.Receiver().(*TTLMemory).Get(k)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return d, nil
+	}
+	return v, nil
+}
+
+// ttlMemorySet sets the value of the key with the default time-to-live.
+func ttlMemorySet(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var k, v starlark.Value
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "key", &k, "value", &v); err != nil {
+		return nil, err
+	}
+	return starlark.None, b.Receiver().(*TTLMemory).SetKey(k, v)
+}
+
+// ttlMemorySetWithTTL sets the value of the key with the given time-to-live in seconds or time.duration.
+func ttlMemorySetWithTTL(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var (
+		k, v starlark.Value
+		ttl  ttlValue
+	)
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "key", &k, "value", &v, "ttl", &ttl); err != nil {
+		return nil, err
+	}
+	return starlark.None, b.Receiver().(*TTLMemory).SetWithTTL(k, v, time.Duration(ttl))
+}
+
+// ttlMemoryTTL returns the remaining time-to-live of the key in seconds, -1 for entries never expire, or None if not found.
+func ttlMemoryTTL(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var k starlark.Value
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "key", &k); err != nil {
+		return nil, err
+	}
+	ttl, found := b.Receiver().(*TTLMemory).TTL(k)
+	if !found {
+		return starlark.None, nil
+	}
+	if ttl < 0 {
+		return starlark.Float(-1), nil
+	}
+	return starlark.Float(ttl.Seconds()), nil
+}
+
+// ttlMemoryExpire sets the time-to-live of the key in seconds or time.duration, and returns whether the key exists.
+func ttlMemoryExpire(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var (
+		k   starlark.Value
+		ttl ttlValue
+	)
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "key", &k, "ttl", &ttl); err != nil {
+		return nil, err
+	}
+	return starlark.Bool(b.Receiver().(*TTLMemory).Expire(k, time.Duration(ttl))), nil
+}
+
+// ttlMemoryDelete removes the key, and returns whether the key existed.
+func ttlMemoryDelete(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var k starlark.Value
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "key", &k); err != nil {
+		return nil, err
+	}
+	return starlark.Bool(b.Receiver().(*TTLMemory).Delete(k)), nil
+}
+
+// ttlMemoryKeys returns the live keys from the least recently used to the most.
+func ttlMemoryKeys(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 0); err != nil {
+		return nil, err
+	}
+	return starlark.NewList(b.Receiver().(*TTLMemory).keys()), nil
+}
+
+// ttlMemoryLen returns the number of live entries.
+func ttlMemoryLen(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 0); err != nil {
+		return nil, err
+	}
+	return starlark.MakeInt(b.Receiver().(*TTLMemory).Len()), nil
+}
+
+// ttlValue unpacks a time-to-live from seconds in int or float, or a time.duration value, or None for no expiry.
+type ttlValue time.Duration
+
+func (t *ttlValue) Unpack(v starlark.Value) error {
+	switch v := v.(type) {
+	case starlark.NoneType:
+		*t = 0
+	case starlark.Int, starlark.Float:
+		f, ok := starlark.AsFloat(v)
+		if !ok {
+			return fmt.Errorf("invalid ttl: %s", v)
+		}
+		*t = ttlValue(f * float64(time.Second))
+	case stdtime.Duration:
+		*t = ttlValue(v)
+	default:
+		return fmt.Errorf("got %s, want int, float, time.duration or None", v.Type())
+	}
+	return nil
+}
+
+// AttachTTLMemory adds a collective memory with time-to-live to the global environment before execution.
+// It panics if called after execution.
+func (s *Starbox) AttachTTLMemory(name string, memory *TTLMemory) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.hasExec {
+		log.DPanic("cannot add memory after execution")
+	}
+	if s.globals == nil {
+		s.globals = make(starlet.StringAnyMap)
+	}
+	s.globals[name] = memory
+}
+
+// CreateTTLMemory creates a new collective memory with time-to-live with the given limits like NewTTLMemory(), and adds it to the global environment before execution.
+// It panics if called after execution.
+func (s *Starbox) CreateTTLMemory(name string, maxEntries int, defaultTTL time.Duration) *TTLMemory {
+	memory := NewTTLMemory(maxEntries, defaultTTL)
+	s.AttachTTLMemory(name, memory)
+	return memory
+}
@@ -0,0 +1,119 @@
+package starbox_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/PureMature/starbox"
+	"go.starlark.net/starlark"
+)
+
+// TestTTLMemory tests the following:
+// 1. Create a new Starbox instance with a TTL memory.
+// 2. Run a script that sets keys with and without TTL, and reads TTLs.
+// 3. Wait for the short TTL to expire, and check the expired key is gone.
+// 4. Check the methods in Go.
+func TestTTLMemory(t *testing.T) {
+	b := starbox.New("test")
+	mem := b.CreateTTLMemory("cache", 0, 0)
+	out, err := b.Run(HereDoc(`
+		cache["forever"] = 1
+		cache.set_with_ttl("short", 2, 0.05)
+		cache.set_with_ttl("long", 3, 3600)
+		t1 = cache.ttl("forever")
+		t2 = cache.ttl("long") > 3500
+		t3 = cache.ttl("missing")
+		e1 = cache.expire("forever", 7200)
+		e2 = cache.expire("missing", 1)
+		n = len(cache)
+		g = cache.get("none", "default")
+		has = "short" in cache
+		ks = cache.keys()
+	`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{
+		"t1": -1.0, "t2": true, "t3": nil, "e1": true, "e2": false, "n": int64(3), "g": "default", "has": true,
+	}
+	for k, v := range expected {
+		if out[k] != v {
+			t.Errorf("expect %s=%v, got %v", k, v, out[k])
+		}
+	}
+
+	time.Sleep(80 * time.Millisecond)
+	if _, found, _ := mem.Get(starlark.String("short")); found {
+		t.Error("expect short key expired")
+	}
+	if n := mem.Len(); n != 2 {
+		t.Errorf("expect 2 live entries, got %d", n)
+	}
+	if ttl, ok := mem.TTL(starlark.String("forever")); !ok || ttl < time.Hour {
+		t.Errorf("unexpected ttl: %v %v", ttl, ok)
+	}
+	if !mem.Delete(starlark.String("long")) || mem.Delete(starlark.String("long")) {
+		t.Error("unexpected delete result")
+	}
+	if err := mem.SetKey(starlark.String("self"), mem); err == nil {
+		t.Error("expect error for nested memory, got nil")
+	}
+	if _, err := b.Run(`cache.set_with_ttl("x", 1, "bad")`); err == nil {
+		t.Error("expect error for invalid ttl, got nil")
+	}
+}
+
+// TestTTLMemory_LRU tests the least recently used entries are evicted when exceeding the limit, and expired entries are purged.
+func TestTTLMemory_LRU(t *testing.T) {
+	mem := starbox.NewTTLMemory(3, 0)
+	for _, k := range []string{"a", "b", "c"} {
+		_ = mem.SetKey(starlark.String(k), starlark.String(k))
+	}
+	// touch a, so b is the least recently used
+	if _, found, _ := mem.Get(starlark.String("a")); !found {
+		t.Fatal("expect a found")
+	}
+	_ = mem.SetKey(starlark.String("d"), starlark.String("d"))
+	if _, found, _ := mem.Get(starlark.String("b")); found {
+		t.Error("expect b evicted")
+	}
+	if n := mem.Len(); n != 3 {
+		t.Errorf("expect 3 entries, got %d", n)
+	}
+	if s := mem.String(); s != `ttl_memory({"c": "c", "a": "a", "d": "d"})` {
+		t.Errorf("unexpected string: %s", s)
+	}
+
+	_ = mem.SetWithTTL(starlark.String("e"), starlark.None, time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	if n := mem.Purge(); n != 1 {
+		t.Errorf("expect 1 purged, got %d", n)
+	}
+}
+
+// TestTTLMemory_Concurrent tests multiple boxes attached to the same TTL memory concurrently.
+func TestTTLMemory_Concurrent(t *testing.T) {
+	mem := starbox.NewTTLMemory(50, time.Minute)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			b := starbox.New("test")
+			b.AttachTTLMemory("cache", mem)
+			b.AddKeyValue("idx", i)
+			if _, err := b.Run(HereDoc(`
+				for j in range(100):
+					cache["k" + str(idx) + "_" + str(j)] = j
+					cache.get("k" + str(idx) + "_" + str(j // 2))
+			`)); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if n := mem.Len(); n != 50 {
+		t.Errorf("expect 50 entries, got %d", n)
+	}
+}
@@ -0,0 +1,97 @@
+package starbox
+
+import (
+	"fmt"
+
+	"github.com/1set/starlet/dataconv"
+	"go.starlark.net/starlark"
+)
+
+// memoryUpdateMaxRetries is the maximum number of attempts of an atomic update before giving up due to conflicts.
+const memoryUpdateMaxRetries = 100
+
+// memoryUpdateKeyMethod is the name of the method of collective memory for atomic updates of a key, which is separate from the update method of dictionaries.
+const memoryUpdateKeyMethod = "update_key"
+
+// CompareAndSwap atomically sets the key of the collective memory to the new value if the current value equals the old one, and reports whether the swap happened.
+// A nil old value means the key must be absent, and a nil new value deletes the key.
+func CompareAndSwap(memory *dataconv.SharedDict, key, old, new starlark.Value) (bool, error) {
+	if memory == nil {
+		return false, fmt.Errorf("nil memory")
+	}
+	return compareAndSwap(memory, key, old, new)
+}
+
+// compareAndSwap is the implementation of CompareAndSwap() which holds the lock of the memory only while comparing and setting.
+func compareAndSwap(memory *dataconv.SharedDict, key, old, new starlark.Value) (bool, error) {
+	switch new.(type) {
+	case *dataconv.SharedDict, *memoryView, *TTLMemory:
+		return false, fmt.Errorf("unsupported value: %s", new.Type())
+	}
+	swapped := false
+	_, err := withMemoryDict(memory, func(d *starlark.Dict) (starlark.Value, error) {
+		cur, found, err := d.Get(key)
+		if err != nil {
+			return nil, err
+		}
+		if old == nil && found || old != nil && (!found || !valuesEqual(cur, old)) {
+			return starlark.None, nil
+		}
+		if new == nil {
+			_, _, err = d.Delete(key)
+		} else {
+			err = d.SetKey(key, new)
+		}
+		swapped = err == nil
+		return starlark.None, err
+	})
+	return swapped, err
+}
+
+// atomicUpdate implements update_key(key, fn, default=None) for collective memory, it calls fn with a copy of the current value of the key (or default if absent), and sets the key to the result atomically.
+// The function runs without holding the lock, so it can safely access the memory itself, and it's called again with the latest value if the key is changed concurrently, so it should have no side effects.
+func (v *memoryView) atomicUpdate(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var (
+		key  starlark.Value
+		fn   starlark.Callable
+		dflt starlark.Value = starlark.None
+	)
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "key", &key, "fn", &fn, "default?", &dflt); err != nil {
+		return nil, err
+	}
+	fk, err := v.fullKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", b.Name(), err)
+	}
+
+	for i := 0; i < memoryUpdateMaxRetries; i++ {
+		cur, found, err := v.mem.Get(fk)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", b.Name(), err)
+		}
+
+		// the snapshot detects in-place changes of containers, and the function gets its own copy
+		var snap starlark.Value
+		arg := dflt
+		if found {
+			snap = copyValue(cur)
+			arg = copyValue(cur)
+		}
+		nv, err := starlark.Call(thread, fn, starlark.Tuple{arg}, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		ok, err := compareAndSwap(v.mem, fk, snap, nv)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", b.Name(), err)
+		}
+		if ok {
+			if snap == nil || !valuesEqual(snap, nv) {
+				notifyMemoryChanges(v.mem, []MemoryEvent{v.newEvent(fk, snap, nv)})
+			}
+			return nv, nil
+		}
+	}
+	return nil, fmt.Errorf("%s: too many conflicts on key %s after %d attempts", b.Name(), key, memoryUpdateMaxRetries)
+}
@@ -0,0 +1,130 @@
+package starbox_test
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/PureMature/starbox"
+	"go.starlark.net/starlark"
+)
+
+// TestMemoryAtomicUpdate tests the following:
+// 1. Create a collective memory, and attach it to multiple boxes running concurrently.
+// 2. Each box increments counters with update_key(key, fn) many times.
+// 3. Check no increment is lost.
+// 4. Check the callback can access the memory itself without deadlock.
+func TestMemoryAtomicUpdate(t *testing.T) {
+	const boxes, loops = 8, 50
+	mem := starbox.NewMemory()
+
+	var wg sync.WaitGroup
+	for i := 0; i < boxes; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b := starbox.New("worker")
+			b.AttachMemory("share", mem)
+			if _, err := b.Run(HereDoc(`
+				for _ in range(50):
+					share.update_key("count", lambda x: x + 1, 0)
+					share.update_key("list", lambda l: l + [share.get("count")], [])
+			`)); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if v, _, _ := mem.Get(starlark.String("count")); v != starlark.MakeInt(boxes*loops) {
+		t.Errorf("expect count=%d, got %v", boxes*loops, v)
+	}
+	if v, _, _ := mem.Get(starlark.String("list")); v.(*starlark.List).Len() != boxes*loops {
+		t.Errorf("expect %d items, got %d", boxes*loops, v.(*starlark.List).Len())
+	}
+
+	b := starbox.New("test")
+	b.AttachMemory("share", mem)
+	out, err := b.Run(HereDoc(`
+		r = share.update_key("new", lambda x: x)
+		share.update({"plain": 1})
+		n = share.update_key("count", lambda x: share["plain"] + x)
+		k = share.update_key(key="kw", fn=lambda x: x + 1, default=10)
+		p = share.update_key("kw", fn=lambda x: x * 2)
+		share.update(a=1)
+		names = [n for n in dir(share) if n.startswith("update")]
+	`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out["r"] != nil || out["n"] != int64(boxes*loops+1) || out["k"] != int64(11) || out["p"] != int64(22) {
+		t.Errorf("unexpected output: %v", out)
+	}
+	if fmt.Sprint(out["names"]) != "[update update_key]" {
+		t.Errorf("unexpected methods: %v", out["names"])
+	}
+	for _, k := range []string{"key", "fn"} {
+		if _, found, _ := mem.Get(starlark.String(k)); found {
+			t.Errorf("unexpected key %q in memory", k)
+		}
+	}
+	if v, _, _ := mem.Get(starlark.String("a")); v != starlark.MakeInt(1) {
+		t.Errorf("expect a=1 by plain update, got %v", v)
+	}
+
+	// update keeps the semantics of dictionaries
+	if _, err := b.Run(`share.update("k", fn=lambda x: x)`); err == nil {
+		t.Error("expect error for plain update with a string, got nil")
+	}
+	if _, err := b.Run(`share.update_key("k")`); err == nil || !strings.Contains(err.Error(), "missing argument for fn") {
+		t.Errorf("expect error for missing fn, got %v", err)
+	}
+
+	b = starbox.New("test")
+	b.AttachMemory("share", mem, starbox.MemoryReadOnly())
+	if _, err := b.Run(`share.update_key("count", lambda x: 0)`); err == nil {
+		t.Error("expect error for read-only memory, got nil")
+	}
+	b = starbox.New("test")
+	b.AttachMemory("share", mem)
+	if _, err := b.Run(`share.update_key("count", lambda x: fail("oops"))`); err == nil {
+		t.Error("expect error from callback, got nil")
+	}
+}
+
+// TestCompareAndSwap tests the compare-and-swap of collective memory from Go.
+func TestCompareAndSwap(t *testing.T) {
+	mem := starbox.NewMemory()
+	k := starlark.String("k")
+	tests := []struct {
+		old, new starlark.Value
+		swapped  bool
+	}{
+		{starlark.MakeInt(1), starlark.MakeInt(2), false},
+		{nil, starlark.MakeInt(1), true},
+		{nil, starlark.MakeInt(2), false},
+		{starlark.MakeInt(2), starlark.MakeInt(3), false},
+		{starlark.MakeInt(1), starlark.NewList([]starlark.Value{starlark.True}), true},
+		{starlark.NewList([]starlark.Value{starlark.True}), nil, true},
+		{nil, starlark.String("x"), true},
+	}
+	for i, tt := range tests {
+		ok, err := starbox.CompareAndSwap(mem, k, tt.old, tt.new)
+		if err != nil {
+			t.Fatalf("case %d: unexpected error: %v", i, err)
+		}
+		if ok != tt.swapped {
+			t.Errorf("case %d: expect swapped=%v, got %v", i, tt.swapped, ok)
+		}
+	}
+	if v, _, _ := mem.Get(k); v != starlark.String("x") {
+		t.Errorf("unexpected value: %v", v)
+	}
+	if _, err := starbox.CompareAndSwap(mem, k, nil, mem); err == nil {
+		t.Error("expect error for nested memory, got nil")
+	}
+	if _, err := starbox.CompareAndSwap(nil, k, nil, k); err == nil {
+		t.Error("expect error for nil memory, got nil")
+	}
+}
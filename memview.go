@@ -0,0 +1,536 @@
+package starbox
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/1set/starlet/dataconv"
+	stdjson "go.starlark.net/lib/json"
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+// MemoryOption configures the view of a collective memory attached to a box by AttachMemory() or CreateMemory().
+type MemoryOption func(v *memoryView)
+
+// MemoryReadOnly makes the attached memory read-only for the script, all writes fail, and values are copied on read so nested lists and dicts can't be changed in place either.
+func MemoryReadOnly() MemoryOption {
+	return func(v *memoryView) {
+		v.readOnly = true
+	}
+}
+
+// MemoryNamespace makes the attached memory a namespaced view, the script sees and writes only the string keys starting with the prefix, and the prefix is hidden from the script.
+// Keys in events delivered to subscribers are the full keys with the prefix.
+func MemoryNamespace(prefix string) MemoryOption {
+	return func(v *memoryView) {
+		v.prefix = prefix
+	}
+}
+
+// memoryView is the Starlark value attached to the global environment for a collective memory, it wraps the shared dictionary to track writes by the box, and optionally restricts the access of the script.
+type memoryView struct {
+	mem      *dataconv.SharedDict
+	boxName  string
+	readOnly bool
+	prefix   string
+}
+
+var (
+	_ starlark.Value      = (*memoryView)(nil)
+	_ starlark.Comparable = (*memoryView)(nil)
+	_ starlark.Mapping    = (*memoryView)(nil)
+	_ starlark.HasAttrs   = (*memoryView)(nil)
+	_ starlark.HasSetKey  = (*memoryView)(nil)
+)
+
+func newMemoryView(mem *dataconv.SharedDict, boxName string, opts ...MemoryOption) *memoryView {
+	v := &memoryView{mem: mem, boxName: boxName}
+	for _, opt := range opts {
+		if opt != nil {
+			opt(v)
+		}
+	}
+	return v
+}
+
+// restricted reports whether the script sees something other than the plain shared dictionary.
+func (v *memoryView) restricted() bool {
+	return v.readOnly || v.prefix != ""
+}
+
+func (v *memoryView) String() string {
+	if !v.restricted() {
+		return v.mem.String()
+	}
+	d, _ := v.visibleDict()
+	return fmt.Sprintf("%s(%s)", v.Type(), d.String())
+}
+
+func (v *memoryView) Type() string          { return v.mem.Type() }
+func (v *memoryView) Freeze()               {}
+func (v *memoryView) Truth() starlark.Bool  { return v.Len() > 0 }
+func (v *memoryView) Hash() (uint32, error) { return v.mem.Hash() }
+
+// Len returns the number of entries visible in the memory.
+func (v *memoryView) Len() int {
+	if v.prefix == "" {
+		return v.mem.Len()
+	}
+	d, _ := v.visibleDict()
+	return d.Len()
+}
+
+// Get returns the value of the key in the memory, or a copy of the value for read-only views.
+func (v *memoryView) Get(k starlark.Value) (starlark.Value, bool, error) {
+	fk, err := v.fullKey(k)
+	if err != nil {
+		return nil, false, err
+	}
+	val, found, err := v.mem.Get(fk)
+	if err != nil || !found {
+		return val, found, err
+	}
+	if v.readOnly {
+		val = copyValue(val)
+	}
+	return val, true, nil
+}
+
+// SetKey sets the value of the key in the memory, and notifies the subscribers.
+func (v *memoryView) SetKey(k, val starlark.Value) error {
+	if v.readOnly {
+		return fmt.Errorf("cannot set key in read-only %s", v.Type())
+	}
+	switch val.(type) {
+	case *dataconv.SharedDict, *memoryView, *TTLMemory:
+		return fmt.Errorf("unsupported value: %s", val.Type())
+	}
+	fk, err := v.fullKey(k)
+	if err != nil {
+		return err
+	}
+
+	var old starlark.Value
+	_, err = withMemoryDict(v.mem, func(d *starlark.Dict) (starlark.Value, error) {
+		if ov, found, err := d.Get(fk); err != nil {
+			return nil, err
+		} else if found {
+			old = ov
+		}
+		return starlark.None, d.SetKey(fk, val)
+	})
+	if err != nil {
+		return err
+	}
+	if old == nil || !valuesEqual(old, val) {
+		notifyMemoryChanges(v.mem, []MemoryEvent{v.newEvent(fk, old, val)})
+	}
+	return nil
+}
+
+// Attr returns the method of the memory, the mutating methods are wrapped to notify the subscribers, and the methods of restricted views only work on the visible entries.
+func (v *memoryView) Attr(name string) (starlark.Value, error) {
+	if name == memoryUpdateKeyMethod {
+		return starlark.NewBuiltin(name, func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			if v.readOnly {
+				return nil, fmt.Errorf("%s: read-only %s", b.Name(), v.Type())
+			}
+			return v.atomicUpdate(thread, b, args, kwargs)
+		}), nil
+	}
+	if op, ok := memoryMutators[name]; ok {
+		return starlark.NewBuiltin(name, func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			if v.readOnly {
+				return nil, fmt.Errorf("%s: read-only %s", b.Name(), v.Type())
+			}
+			return v.mutate(thread, b, args, kwargs, op)
+		}), nil
+	}
+	if !v.restricted() {
+		return v.mem.Attr(name)
+	}
+	if op, ok := memoryReaders[name]; ok {
+		return starlark.NewBuiltin(name, func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			d, err := v.visibleDict()
+			if err != nil {
+				return nil, err
+			}
+			return op(thread, b, d, args, kwargs)
+		}), nil
+	}
+	return nil, nil
+}
+
+// AttrNames returns the names of the methods of the memory.
+func (v *memoryView) AttrNames() []string {
+	if !v.restricted() {
+		names := append([]string{memoryUpdateKeyMethod}, v.mem.AttrNames()...)
+		sort.Strings(names)
+		return names
+	}
+	names := make([]string, 0, len(memoryMutators)+len(memoryReaders)+1)
+	names = append(names, memoryUpdateKeyMethod)
+	for n := range memoryMutators {
+		names = append(names, n)
+	}
+	for n := range memoryReaders {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// CompareSameType compares the underlying shared dictionaries, or the visible entries if any view is restricted.
+func (v *memoryView) CompareSameType(op syntax.Token, y starlark.Value, depth int) (bool, error) {
+	w := y.(*memoryView)
+	if !v.restricted() && !w.restricted() {
+		return v.mem.CompareSameType(op, w.mem, depth)
+	}
+	xd, err := v.visibleDict()
+	if err != nil {
+		return false, err
+	}
+	yd, err := w.visibleDict()
+	if err != nil {
+		return false, err
+	}
+	return xd.CompareSameType(op, yd, depth)
+}
+
+// fullKey converts the key seen by the script to the key in the shared dictionary.
+func (v *memoryView) fullKey(k starlark.Value) (starlark.Value, error) {
+	if v.prefix == "" {
+		return k, nil
+	}
+	s, ok := k.(starlark.String)
+	if !ok {
+		return nil, fmt.Errorf("namespaced %s: got %s key, want string", v.Type(), k.Type())
+	}
+	return starlark.String(v.prefix) + s, nil
+}
+
+// visibleDict returns a snapshot of the entries visible to the script.
+func (v *memoryView) visibleDict() (*starlark.Dict, error) {
+	var vd *starlark.Dict
+	_, err := withMemoryDict(v.mem, func(d *starlark.Dict) (starlark.Value, error) {
+		vd = v.extract(d)
+		return starlark.None, nil
+	})
+	if vd == nil {
+		vd = starlark.NewDict(0)
+	}
+	return vd, err
+}
+
+// extract returns a new dictionary of the entries in the underlying dictionary visible to the script, with the prefix removed from the keys.
+func (v *memoryView) extract(d *starlark.Dict) *starlark.Dict {
+	vd := starlark.NewDict(d.Len())
+	for _, kv := range d.Items() {
+		k, val := kv[0], kv[1]
+		if v.prefix != "" {
+			s, ok := k.(starlark.String)
+			if !ok || !strings.HasPrefix(string(s), v.prefix) {
+				continue
+			}
+			k = s[len(v.prefix):]
+		}
+		if v.readOnly {
+			val = copyValue(val)
+		}
+		_ = vd.SetKey(k, val)
+	}
+	return vd
+}
+
+// mutate runs the mutating operation on the underlying dictionary while holding the lock, and notifies the subscribers with the differences.
+// For namespaced views, the operation runs on the visible entries and the differences are written back with the prefixed keys.
+func (v *memoryView) mutate(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple, op memoryMutator) (starlark.Value, error) {
+	var events []MemoryEvent
+	res, err := withMemoryDict(v.mem, func(d *starlark.Dict) (starlark.Value, error) {
+		if v.prefix == "" {
+			before := d.Items()
+			res, err := op(thread, b, d, args, kwargs)
+			events = v.diff(before, d.Items())
+			return res, err
+		}
+
+		vd := v.extract(d)
+		before, _ := v.prefixed(vd.Items())
+		res, err := op(thread, b, vd, args, kwargs)
+		after, perr := v.prefixed(vd.Items())
+		if perr != nil {
+			return nil, perr
+		}
+		events = v.diff(before, after)
+		for _, e := range events {
+			var we error
+			if e.NewValue == nil {
+				_, _, we = d.Delete(e.Key)
+			} else {
+				we = d.SetKey(e.Key, e.NewValue)
+			}
+			if we != nil {
+				return nil, we
+			}
+		}
+		return res, err
+	})
+	notifyMemoryChanges(v.mem, events)
+	return res, err
+}
+
+// prefixed adds the prefix back to the keys of the items in place, and fails if any key is not a string.
+func (v *memoryView) prefixed(items []starlark.Tuple) ([]starlark.Tuple, error) {
+	for i, kv := range items {
+		s, ok := kv[0].(starlark.String)
+		if !ok {
+			return nil, fmt.Errorf("namespaced %s: got %s key, want string", v.Type(), kv[0].Type())
+		}
+		items[i] = starlark.Tuple{starlark.String(v.prefix) + s, kv[1]}
+	}
+	return items, nil
+}
+
+// diff returns the change events between the items before and after an operation.
+func (v *memoryView) diff(before, after []starlark.Tuple) []MemoryEvent {
+	var (
+		events []MemoryEvent
+		oldMap = starlark.NewDict(len(before))
+		newMap = starlark.NewDict(len(after))
+	)
+	for _, kv := range before {
+		_ = oldMap.SetKey(kv[0], kv[1])
+	}
+	for _, kv := range after {
+		_ = newMap.SetKey(kv[0], kv[1])
+		ov, found, _ := oldMap.Get(kv[0])
+		if !found {
+			events = append(events, v.newEvent(kv[0], nil, kv[1]))
+		} else if !valuesEqual(ov, kv[1]) {
+			events = append(events, v.newEvent(kv[0], ov, kv[1]))
+		}
+	}
+	for _, kv := range before {
+		if _, found, _ := newMap.Get(kv[0]); !found {
+			events = append(events, v.newEvent(kv[0], kv[1], nil))
+		}
+	}
+	return events
+}
+
+func (v *memoryView) newEvent(k, old, val starlark.Value) MemoryEvent {
+	return MemoryEvent{Key: k, OldValue: old, NewValue: val, BoxName: v.boxName}
+}
+
+// memoryMutator is an operation on the underlying dictionary of a collective memory, or the visible entries of it.
+type memoryMutator func(thread *starlark.Thread, b *starlark.Builtin, d *starlark.Dict, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error)
+
+// dictMethod returns an operation calling the builtin method of the dictionary.
+func dictMethod(name string) memoryMutator {
+	return func(thread *starlark.Thread, _ *starlark.Builtin, d *starlark.Dict, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		m, err := d.Attr(name)
+		if err != nil {
+			return nil, err
+		}
+		return starlark.Call(thread, m, args, kwargs)
+	}
+}
+
+var memoryMutators = map[string]memoryMutator{
+	"clear":      dictMethod("clear"),
+	"pop":        dictMethod("pop"),
+	"popitem":    dictMethod("popitem"),
+	"setdefault": dictMethod("setdefault"),
+	"update":     dictMethod("update"),
+	"perform": func(thread *starlark.Thread, b *starlark.Builtin, d *starlark.Dict, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var fn starlark.Callable
+		if err := starlark.UnpackArgs(b.Name(), args, kwargs, "fn", &fn); err != nil {
+			return nil, err
+		}
+		return starlark.Call(thread, fn, starlark.Tuple{d}, nil)
+	},
+	"from_json": func(thread *starlark.Thread, b *starlark.Builtin, d *starlark.Dict, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var s starlark.Value
+		if err := starlark.UnpackArgs(b.Name(), args, kwargs, "x", &s); err != nil {
+			return nil, err
+		}
+		var str starlark.String
+		switch x := s.(type) {
+		case starlark.String:
+			str = x
+		case starlark.Bytes:
+			str = starlark.String(x)
+		default:
+			return nil, fmt.Errorf("%s: got %s, want string or bytes", b.Name(), s.Type())
+		}
+		nv, err := starlark.Call(thread, stdjson.Module.Members["decode"], starlark.Tuple{str}, nil)
+		if err != nil {
+			return nil, err
+		}
+		nd, ok := nv.(*starlark.Dict)
+		if !ok {
+			return nil, fmt.Errorf("got %s, want dict", nv.Type())
+		}
+		for _, kv := range nd.Items() {
+			if err := d.SetKey(kv[0], kv[1]); err != nil {
+				return nil, err
+			}
+		}
+		return nd, nil
+	},
+}
+
+// memoryReaders are the non-mutating methods of restricted views, which work on a snapshot of the visible entries.
+var memoryReaders = map[string]memoryMutator{
+	"get":    dictMethod("get"),
+	"items":  dictMethod("items"),
+	"keys":   dictMethod("keys"),
+	"values": dictMethod("values"),
+	"len": func(_ *starlark.Thread, b *starlark.Builtin, d *starlark.Dict, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 0); err != nil {
+			return nil, err
+		}
+		return starlark.MakeInt(d.Len()), nil
+	},
+	"to_dict": func(_ *starlark.Thread, b *starlark.Builtin, d *starlark.Dict, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 0); err != nil {
+			return nil, err
+		}
+		return d, nil
+	},
+	"to_json": func(thread *starlark.Thread, b *starlark.Builtin, d *starlark.Dict, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 0); err != nil {
+			return nil, err
+		}
+		return starlark.Call(thread, stdjson.Module.Members["encode"], starlark.Tuple{d}, nil)
+	},
+}
+
+// withMemoryDict calls the function with the underlying dictionary of the shared dictionary while holding its lock.
+// The function must not access the shared dictionary itself, or it deadlocks.
+func withMemoryDict(mem *dataconv.SharedDict, fn func(d *starlark.Dict) (starlark.Value, error)) (starlark.Value, error) {
+	pf, err := mem.Attr("perform")
+	if err != nil {
+		return nil, err
+	}
+	if pf == nil {
+		return nil, fmt.Errorf("no dict in %s", mem.Type())
+	}
+	cb := starlark.NewBuiltin("perform", func(_ *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, _ []starlark.Tuple) (starlark.Value, error) {
+		return fn(args[0].(*starlark.Dict))
+	})
+	return starlark.Call(&starlark.Thread{Name: "memory"}, pf, starlark.Tuple{cb}, nil)
+}
+
+// valuesEqual reports whether the two values are equal, and treats incomparable values as unequal.
+// Lists and dicts are compared in depth, and containers referencing themselves are equal if they have the same structure.
+func valuesEqual(x, y starlark.Value) bool {
+	return valuesEqualWith(x, y, make(map[[2]starlark.Value]bool))
+}
+
+// valuesEqualWith reports whether the two values are equal, the pairs of lists and dicts being compared are kept in the map to stop at cycles.
+func valuesEqualWith(x, y starlark.Value, seen map[[2]starlark.Value]bool) bool {
+	switch a := x.(type) {
+	case *starlark.List:
+		b, ok := y.(*starlark.List)
+		if !ok || a.Len() != b.Len() {
+			return false
+		}
+		p := [2]starlark.Value{a, b}
+		if a == b || seen[p] {
+			return true
+		}
+		seen[p] = true
+		for i := 0; i < a.Len(); i++ {
+			if !valuesEqualWith(a.Index(i), b.Index(i), seen) {
+				return false
+			}
+		}
+		return true
+	case starlark.Tuple:
+		b, ok := y.(starlark.Tuple)
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if !valuesEqualWith(a[i], b[i], seen) {
+				return false
+			}
+		}
+		return true
+	case *starlark.Dict:
+		b, ok := y.(*starlark.Dict)
+		if !ok || a.Len() != b.Len() {
+			return false
+		}
+		p := [2]starlark.Value{a, b}
+		if a == b || seen[p] {
+			return true
+		}
+		seen[p] = true
+		for _, kv := range a.Items() {
+			bv, found, err := b.Get(kv[0])
+			if err != nil || !found || !valuesEqualWith(kv[1], bv, seen) {
+				return false
+			}
+		}
+		return true
+	}
+	eq, err := starlark.Equal(x, y)
+	return err == nil && eq
+}
+
+// copyValue returns a deep copy of lists, dicts and sets, and of tuples containing them, other values are immutable and returned as is.
+// Containers referenced more than once, e.g. a list containing itself, are copied once and the copy is reused, so the structure is kept.
+func copyValue(v starlark.Value) starlark.Value {
+	return copyValueWith(v, make(map[starlark.Value]starlark.Value))
+}
+
+// copyValueWith returns a deep copy of the value, and the copies of containers are kept in the map by their originals.
+func copyValueWith(v starlark.Value, copies map[starlark.Value]starlark.Value) starlark.Value {
+	switch x := v.(type) {
+	case *starlark.List:
+		if c, ok := copies[x]; ok {
+			return c
+		}
+		l := starlark.NewList(make([]starlark.Value, 0, x.Len()))
+		copies[x] = l
+		for i := 0; i < x.Len(); i++ {
+			_ = l.Append(copyValueWith(x.Index(i), copies))
+		}
+		return l
+	case starlark.Tuple:
+		vs := make(starlark.Tuple, len(x))
+		for i := range x {
+			vs[i] = copyValueWith(x[i], copies)
+		}
+		return vs
+	case *starlark.Dict:
+		if c, ok := copies[x]; ok {
+			return c
+		}
+		d := starlark.NewDict(x.Len())
+		copies[x] = d
+		for _, kv := range x.Items() {
+			_ = d.SetKey(kv[0], copyValueWith(kv[1], copies))
+		}
+		return d
+	case *starlark.Set:
+		if c, ok := copies[x]; ok {
+			return c
+		}
+		s := starlark.NewSet(x.Len())
+		copies[x] = s
+		iter := x.Iterate()
+		defer iter.Done()
+		var e starlark.Value
+		for iter.Next(&e) {
+			_ = s.Insert(e)
+		}
+		return s
+	default:
+		return v
+	}
+}
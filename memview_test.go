@@ -0,0 +1,187 @@
+package starbox_test
+
+import (
+	"testing"
+
+	"github.com/PureMature/starbox"
+	"go.starlark.net/starlark"
+)
+
+// TestMemoryReadOnly tests the following:
+// 1. Create a collective memory with nested values, and attach it as read-only to a box.
+// 2. Run scripts to read the memory, and check the values are visible.
+// 3. Run scripts to write the memory in various ways, and check all of them fail.
+// 4. Check the nested values can't be changed in place via the read-only view.
+func TestMemoryReadOnly(t *testing.T) {
+	mem := starbox.NewMemory()
+	_ = mem.SetKey(starlark.String("name"), starlark.String("Aloha"))
+	_ = mem.SetKey(starlark.String("list"), starlark.NewList([]starlark.Value{starlark.MakeInt(1)}))
+
+	newBox := func() *starbox.Starbox {
+		b := starbox.New("reader")
+		b.AttachMemory("share", mem, starbox.MemoryReadOnly())
+		return b
+	}
+	out, err := newBox().Run(HereDoc(`
+		a = share["name"]
+		b = share.get("missing", 0)
+		c = share.len()
+		d = sorted(share.keys())
+		e = "list" in share
+		l = share["list"]
+		l.append(2)
+		f = len(share["list"])
+	`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out["a"] != "Aloha" || out["b"] != int64(0) || out["c"] != int64(2) || out["e"] != true || out["f"] != int64(1) {
+		t.Errorf("unexpected output: %v", out)
+	}
+
+	for _, script := range []string{
+		`share["name"] = "Mahalo"`,
+		`share.update({"x": 1})`,
+		`share.pop("name")`,
+		`share.clear()`,
+		`share.setdefault("y", 2)`,
+		`share.from_json('{"z": 3}')`,
+		`share.perform(lambda d: d.clear())`,
+	} {
+		if _, err := newBox().Run(script); err == nil {
+			t.Errorf("expect error for %q, got nil", script)
+		}
+	}
+	if n := mem.Len(); n != 2 {
+		t.Errorf("expect 2 entries unchanged, got %d", n)
+	}
+	if l, _, _ := mem.Get(starlark.String("list")); l.(*starlark.List).Len() != 1 {
+		t.Errorf("expect nested list unchanged, got %v", l)
+	}
+}
+
+// TestMemoryReadOnly_Cycle tests the following:
+// 1. Store a self-referencing list and dict into a collective memory from a box.
+// 2. Read them via a read-only view in another box, and check the copies keep the structure.
+// 3. Update them atomically by update_key, and check the callback gets the copies.
+func TestMemoryReadOnly_Cycle(t *testing.T) {
+	mem := starbox.NewMemory()
+	w := starbox.New("writer")
+	w.AttachMemory("share", mem)
+	if _, err := w.Run(HereDoc(`
+		l = [1]
+		l.append(l)
+		d = {"n": 1}
+		d["self"] = d
+		share["l"] = l
+		share["d"] = d
+	`)); err != nil {
+		t.Fatal(err)
+	}
+
+	r := starbox.New("reader")
+	r.AttachMemory("share", mem, starbox.MemoryReadOnly())
+	out, err := r.Run(HereDoc(`
+		def check():
+			l = share["l"]
+			d = share["d"]
+			l[1].append(2)
+			return l[1][1][0], len(l), d["self"]["self"]["n"]
+		a, b, c = check()
+	`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out["a"] != int64(1) || out["b"] != int64(3) || out["c"] != int64(1) {
+		t.Errorf("unexpected output: %v", out)
+	}
+	if l, _, _ := mem.Get(starlark.String("l")); l.(*starlark.List).Len() != 2 {
+		t.Errorf("expect the list in memory unchanged, got length %d", l.(*starlark.List).Len())
+	}
+
+	u := starbox.New("updater")
+	u.AttachMemory("share", mem)
+	out, err = u.Run(`n = share.update_key("l", lambda x: len(x[1]))`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out["n"] != int64(2) {
+		t.Errorf("unexpected output: %v", out)
+	}
+}
+
+// TestMemoryNamespace tests the following:
+// 1. Create a collective memory, and attach it to two boxes with different namespaces.
+// 2. Run scripts in both boxes to write the same keys, and check they don't clobber each other.
+// 3. Check the full keys in the underlying memory and in the events.
+// 4. Check non-string keys are rejected, and a namespaced read-only view works.
+func TestMemoryNamespace(t *testing.T) {
+	mem := starbox.NewMemory()
+	_ = mem.SetKey(starlark.String("global"), starlark.True)
+	ch, unsub := starbox.Subscribe(mem, "")
+
+	script := HereDoc(`
+		state["count"] = state.get("count", 0) + idx
+		state.update({"name": "box" + str(idx)})
+		state.setdefault("tmp", 1)
+		state.pop("tmp")
+		n = state.len()
+		ks = sorted(state.keys())
+		g = "global" in state
+	`)
+	for i, ns := range []string{"alice/", "bob/"} {
+		b := starbox.New(ns)
+		b.AttachMemory("state", mem, starbox.MemoryNamespace(ns))
+		b.AddKeyValue("idx", i+1)
+		out, err := b.Run(script)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out["n"] != int64(2) || out["g"] != false {
+			t.Errorf("unexpected output: %v", out)
+		}
+	}
+	unsub()
+
+	expected := map[string]starlark.Value{
+		"global":      starlark.True,
+		"alice/count": starlark.MakeInt(1),
+		"alice/name":  starlark.String("box1"),
+		"bob/count":   starlark.MakeInt(2),
+		"bob/name":    starlark.String("box2"),
+	}
+	if n := mem.Len(); n != len(expected) {
+		t.Errorf("expect %d entries, got %d: %s", len(expected), n, mem)
+	}
+	for k, v := range expected {
+		if got, found, _ := mem.Get(starlark.String(k)); !found || got.String() != v.String() {
+			t.Errorf("expect %s=%v, got %v", k, v, got)
+		}
+	}
+
+	var keys []string
+	for e := range ch {
+		keys = append(keys, e.BoxName+":"+e.KeyString())
+	}
+	if len(keys) != 8 || keys[0] != "alice/:alice/count" || keys[7] != "bob/:bob/tmp" {
+		t.Errorf("unexpected events: %v", keys)
+	}
+
+	b := starbox.New("bad")
+	b.AttachMemory("state", mem, starbox.MemoryNamespace("alice/"))
+	if _, err := b.Run(`state[1] = 2`); err == nil {
+		t.Error("expect error for non-string key, got nil")
+	}
+	b = starbox.New("viewer")
+	b.AttachMemory("state", mem, starbox.MemoryNamespace("bob/"), starbox.MemoryReadOnly())
+	out, err := b.Run(`c = state["count"]; s = str(state)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out["c"] != int64(2) || out["s"] != `collective_memory({"count": 2, "name": "box2"})` {
+		t.Errorf("unexpected output: %v", out)
+	}
+	if _, err := b.Run(`state["count"] = 3`); err == nil {
+		t.Error("expect error for read-only view, got nil")
+	}
+}
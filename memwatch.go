@@ -0,0 +1,133 @@
+package starbox
+
+import (
+	"path"
+	"sync"
+	"time"
+
+	"github.com/1set/starlet/dataconv"
+	"go.starlark.net/starlark"
+)
+
+// MemoryEvent is a change of a key in a collective memory made by a script.
+type MemoryEvent struct {
+	Key      starlark.Value // Key is the changed key.
+	OldValue starlark.Value // OldValue is the value before the change, nil if the key was absent.
+	NewValue starlark.Value // NewValue is the value after the change, nil if the key was deleted.
+	BoxName  string         // BoxName is the name of the Starbox instance that made the change.
+	Time     time.Time      // Time is when the change was made.
+}
+
+// KeyString returns the key as a Go string, i.e. the raw string for string keys, or the Starlark representation for other keys.
+func (e MemoryEvent) KeyString() string {
+	if s, ok := e.Key.(starlark.String); ok {
+		return string(s)
+	}
+	return e.Key.String()
+}
+
+// memorySubscriber is a subscriber of changes of a collective memory.
+type memorySubscriber struct {
+	pattern string
+	fn      func(MemoryEvent)
+}
+
+var (
+	memorySubsMu sync.RWMutex
+	memorySubs   = make(map[*dataconv.SharedDict][]*memorySubscriber)
+)
+
+// memoryEventBufferSize is the buffer size of channels returned by Subscribe().
+const memoryEventBufferSize = 128
+
+// SubscribeFunc registers a callback for changes of keys matching the pattern in the collective memory, and returns a function to unsubscribe.
+// The pattern uses the syntax of path.Match() against the key string, and an empty pattern matches all keys.
+// Only changes made by scripts in Starbox instances with the memory attached are observed, and the callback is called synchronously in the goroutine of the script after the change, so it should return quickly.
+func SubscribeFunc(memory *dataconv.SharedDict, keyPattern string, fn func(MemoryEvent)) (unsubscribe func()) {
+	sub := &memorySubscriber{pattern: keyPattern, fn: fn}
+
+	memorySubsMu.Lock()
+	memorySubs[memory] = append(memorySubs[memory], sub)
+	memorySubsMu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			memorySubsMu.Lock()
+			defer memorySubsMu.Unlock()
+
+			subs := memorySubs[memory]
+			for i, s := range subs {
+				if s == sub {
+					subs = append(subs[:i:i], subs[i+1:]...)
+					break
+				}
+			}
+			if len(subs) == 0 {
+				delete(memorySubs, memory)
+			} else {
+				memorySubs[memory] = subs
+			}
+		})
+	}
+}
+
+// Subscribe registers a channel for changes of keys matching the pattern in the collective memory like SubscribeFunc(), and returns the channel and a function to unsubscribe and close the channel.
+// The channel is buffered, and events are dropped with a warning log if the buffer is full, so the scripts are never blocked by slow receivers.
+func Subscribe(memory *dataconv.SharedDict, keyPattern string) (events <-chan MemoryEvent, unsubscribe func()) {
+	var (
+		mu     sync.Mutex
+		closed bool
+		ch     = make(chan MemoryEvent, memoryEventBufferSize)
+	)
+	unsub := SubscribeFunc(memory, keyPattern, func(e MemoryEvent) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if closed {
+			return
+		}
+		select {
+		case ch <- e:
+		default:
+			log.Warnf("memory event dropped for key %s: subscriber buffer is full", e.KeyString())
+		}
+	})
+	return ch, func() {
+		unsub()
+		mu.Lock()
+		defer mu.Unlock()
+		if !closed {
+			closed = true
+			close(ch)
+		}
+	}
+}
+
+// notifyMemoryChanges sends the events to the matched subscribers of the collective memory.
+func notifyMemoryChanges(memory *dataconv.SharedDict, events []MemoryEvent) {
+	if len(events) == 0 {
+		return
+	}
+
+	memorySubsMu.RLock()
+	subs := append([]*memorySubscriber(nil), memorySubs[memory]...)
+	memorySubsMu.RUnlock()
+	if len(subs) == 0 {
+		return
+	}
+
+	now := time.Now()
+	for _, e := range events {
+		e.Time = now
+		key := e.KeyString()
+		for _, sub := range subs {
+			if sub.pattern != "" {
+				if ok, _ := path.Match(sub.pattern, key); !ok {
+					continue
+				}
+			}
+			sub.fn(e)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package starbox_test
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/PureMature/starbox"
+	"go.starlark.net/starlark"
+)
+
+// TestSubscribe tests the following:
+// 1. Create a collective memory, and subscribe to changes with a channel and a callback.
+// 2. Run scripts in two boxes attached to the memory to change keys in various ways.
+// 3. Check the events received, including the keys, values and box names.
+// 4. Unsubscribe and check no more events are received.
+func TestSubscribe(t *testing.T) {
+	mem := starbox.NewMemory()
+	ch, unsub := starbox.Subscribe(mem, "")
+
+	var (
+		mu      sync.Mutex
+		counted []string
+	)
+	unsubFn := starbox.SubscribeFunc(mem, "count*", func(e starbox.MemoryEvent) {
+		mu.Lock()
+		defer mu.Unlock()
+		counted = append(counted, e.BoxName+":"+e.KeyString())
+	})
+
+	b1 := starbox.New("writer")
+	b1.AttachMemory("share", mem)
+	if _, err := b1.Run(HereDoc(`
+		share["count"] = 1
+		share["count"] = 1
+		share["name"] = "Aloha"
+		share.update({"count": 2, "extra": True})
+	`)); err != nil {
+		t.Fatal(err)
+	}
+	b2 := starbox.New("cleaner")
+	b2.AttachMemory("share", mem)
+	if _, err := b2.Run(HereDoc(`
+		share.pop("extra")
+		def inc(d):
+			d["count_all"] = d["count"] + 1
+		share.perform(inc)
+		share.from_json('{"name": "Mahalo"}')
+	`)); err != nil {
+		t.Fatal(err)
+	}
+	unsub()
+	unsubFn()
+
+	type change struct {
+		box, key string
+		old, new starlark.Value
+	}
+	expected := []change{
+		{"writer", "count", nil, starlark.MakeInt(1)},
+		{"writer", "name", nil, starlark.String("Aloha")},
+		{"writer", "count", starlark.MakeInt(1), starlark.MakeInt(2)},
+		{"writer", "extra", nil, starlark.True},
+		{"cleaner", "extra", starlark.True, nil},
+		{"cleaner", "count_all", nil, starlark.MakeInt(3)},
+		{"cleaner", "name", starlark.String("Aloha"), starlark.String("Mahalo")},
+	}
+	var got []starbox.MemoryEvent
+	for e := range ch {
+		got = append(got, e)
+	}
+	if len(got) != len(expected) {
+		t.Fatalf("expect %d events, got %d: %v", len(expected), len(got), got)
+	}
+	for i, e := range got {
+		ex := expected[i]
+		if e.BoxName != ex.box || e.KeyString() != ex.key || e.Time.IsZero() {
+			t.Errorf("event %d: expect %s:%s, got %+v", i, ex.box, ex.key, e)
+		}
+		if (e.OldValue == nil) != (ex.old == nil) || (ex.old != nil && e.OldValue.String() != ex.old.String()) {
+			t.Errorf("event %d: expect old %v, got %v", i, ex.old, e.OldValue)
+		}
+		if (e.NewValue == nil) != (ex.new == nil) || (ex.new != nil && e.NewValue.String() != ex.new.String()) {
+			t.Errorf("event %d: expect new %v, got %v", i, ex.new, e.NewValue)
+		}
+	}
+
+	mu.Lock()
+	if len(counted) != 3 || counted[2] != "cleaner:count_all" {
+		t.Errorf("unexpected callback events: %v", counted)
+	}
+	mu.Unlock()
+
+	// no more events after unsubscribing
+	b3 := starbox.New("late")
+	b3.AttachMemory("share", mem)
+	if _, err := b3.Run(`share["count"] = 100`); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	if len(counted) != 3 {
+		t.Errorf("unexpected callback events after unsubscribing: %v", counted)
+	}
+	mu.Unlock()
+}
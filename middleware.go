@@ -0,0 +1,166 @@
+package starbox
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+
+	libhttp "github.com/1set/starlet/lib/http"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+// responseWriters are the methods of the response struct which write the response, calling any of them in a middleware script short-circuits the request.
+var responseWriters = map[string]bool{
+	"set_status": true,
+	"set_code":   true,
+	"set_data":   true,
+	"set_json":   true,
+	"set_text":   true,
+	"set_html":   true,
+}
+
+// middlewareState tracks the changes made by a middleware script.
+type middlewareState struct {
+	mu           sync.Mutex
+	written      bool // whether the script writes the response
+	contentType  bool // whether the script sets the content type
+	reqHeaderSet http.Header
+	reqHeaderDel []string
+}
+
+// responseStruct returns the response struct for the script, which is the same as the one from AddHTTPContext() but tracks the calls of methods.
+func (m *middlewareState) responseStruct(resp *libhttp.ServerResponse) *starlarkstruct.Struct {
+	sd := make(starlark.StringDict)
+	resp.Struct().ToStringDict(sd)
+	for name, v := range sd {
+		b, ok := v.(*starlark.Builtin)
+		if !ok {
+			continue
+		}
+		name := name
+		sd[name] = starlark.NewBuiltin(name, func(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			res, err := starlark.Call(thread, b, args, kwargs)
+			if err == nil {
+				m.mu.Lock()
+				if responseWriters[name] {
+					m.written = true
+				} else if name == "set_content_type" {
+					m.contentType = true
+				}
+				m.mu.Unlock()
+			}
+			return res, err
+		})
+	}
+	return starlarkstruct.FromStringDict(starlark.String("Response"), sd)
+}
+
+// builtins returns the builtins for the script to change the headers of the request passed to the next handler.
+func (m *middlewareState) builtins() starlark.StringDict {
+	return starlark.StringDict{
+		"set_request_header": starlark.NewBuiltin("set_request_header", func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			var name, value string
+			if err := starlark.UnpackArgs(b.Name(), args, kwargs, "name", &name, "value", &value); err != nil {
+				return nil, err
+			}
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			m.reqHeaderSet.Set(name, value)
+			return starlark.None, nil
+		}),
+		"remove_request_header": starlark.NewBuiltin("remove_request_header", func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			var name string
+			if err := starlark.UnpackArgs(b.Name(), args, kwargs, "name", &name); err != nil {
+				return nil, err
+			}
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			m.reqHeaderSet.Del(name)
+			m.reqHeaderDel = append(m.reqHeaderDel, name)
+			return starlark.None, nil
+		}),
+	}
+}
+
+// nextRequest returns the request for the next handler with the header changes applied.
+func (m *middlewareState) nextRequest(r *http.Request) *http.Request {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(m.reqHeaderSet) == 0 && len(m.reqHeaderDel) == 0 {
+		return r
+	}
+	nr := r.Clone(r.Context())
+	for _, name := range m.reqHeaderDel {
+		nr.Header.Del(name)
+		if strings.EqualFold(name, "Host") {
+			nr.Host = ""
+		}
+	}
+	for name, vs := range m.reqHeaderSet {
+		nr.Header[name] = vs
+		if strings.EqualFold(name, "Host") && len(vs) > 0 {
+			nr.Host = vs[0]
+		}
+	}
+	return nr
+}
+
+// Middleware creates an HTTP middleware which runs the script in a new Starbox instance created by the factory for each request before the next handler.
+// The script reads the request from the global `request`, and can change the headers of the request passed to the next handler with the builtins set_request_header(name, value) and remove_request_header(name).
+// Headers added via the global `response` are added to the final response, and if the script writes the response via its methods like set_status() or set_text(), the request is short-circuited and the next handler is not called.
+// If the script fails, it responds with the error status as Handler() does, and the next handler is not called either.
+func Middleware(script string, factory BoxFactory, opts ...HandlerOption) func(next http.Handler) http.Handler {
+	cfg := newHandlerConfig(factory, opts)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			w := &trackingWriter{ResponseWriter: rw}
+			if nr := cfg.runMiddleware(w, r, script); nr != nil {
+				next.ServeHTTP(rw, nr)
+			}
+		})
+	}
+}
+
+// runMiddleware runs the middleware script for the request, and returns the request for the next handler if it passes through, or nil if the response has been written.
+func (c *handlerConfig) runMiddleware(w *trackingWriter, r *http.Request, script string) *http.Request {
+	defer c.recoverPanic(w, r)
+
+	box, err := c.newBox(r)
+	if err != nil {
+		c.writeError(w, r, err)
+		return nil
+	}
+	state := &middlewareState{reqHeaderSet: make(http.Header)}
+	resp := box.AddHTTPContext(r)
+	box.AddKeyStarlarkValue("response", state.responseStruct(resp))
+	box.AddStarlarkValues(state.builtins())
+
+	ctx, cancel := c.context(r)
+	defer cancel()
+	if _, err := box.RunContext(ctx, script); err != nil {
+		c.writeError(w, r, runError(ctx, err))
+		return nil
+	}
+
+	// short-circuit with the response
+	if state.written {
+		if err := writeResponse(w, resp); err != nil {
+			log.Warnw("failed to write http response", "method", r.Method, "url", r.URL.String(), "error", err)
+		}
+		return nil
+	}
+
+	// pass through with the headers
+	hdr := resp.Export().Header
+	if !state.contentType {
+		hdr.Del("Content-Type")
+	}
+	for k, vs := range hdr {
+		for _, v := range vs {
+			w.Header().Add(k, v)
+		}
+	}
+	return state.nextRequest(r)
+}
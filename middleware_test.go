@@ -0,0 +1,80 @@
+package starbox_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/PureMature/starbox"
+)
+
+// TestMiddleware tests the following:
+// 1. Create a middleware with a script checking the auth header, rewriting request headers and adding response headers.
+// 2. Serve requests through the middleware in front of a Go handler.
+// 3. Check the requests passing through reach the handler with the changed headers.
+// 4. Check the unauthorized requests are short-circuited, and script errors fail closed.
+func TestMiddleware(t *testing.T) {
+	script := HereDoc(`
+		token = request.header.get("Authorization", [""])[0]
+		if token != "Bearer secret":
+			response.set_status(401)
+			response.set_text("unauthorized")
+		elif "X-Boom" in request.header:
+			fail("boom")
+		else:
+			response.add_header("X-Checked", "yes")
+			set_request_header("X-User", "alice")
+			remove_request_header("Authorization")
+	`)
+	mw := starbox.Middleware(script, nil)
+
+	var called int
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called++
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write([]byte("user=" + r.Header.Get("X-User") + " auth=" + r.Header.Get("Authorization")))
+	})
+	h := mw(next)
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		status  int
+		body    string
+		checked string
+		called  int
+	}{
+		{"no auth", nil, http.StatusUnauthorized, "unauthorized", "", 0},
+		{"wrong auth", map[string]string{"Authorization": "Bearer wrong"}, http.StatusUnauthorized, "unauthorized", "", 0},
+		{"pass", map[string]string{"Authorization": "Bearer secret"}, http.StatusOK, "user=alice auth=", "yes", 1},
+		{"error", map[string]string{"Authorization": "Bearer secret", "X-Boom": "1"}, http.StatusInternalServerError, "Internal Server Error\n", "", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "http://localhost/", nil)
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tt.status {
+				t.Errorf("expect status %d, got %d", tt.status, rec.Code)
+			}
+			if body := rec.Body.String(); body != tt.body {
+				t.Errorf("expect body %q, got %q", tt.body, body)
+			}
+			if v := rec.Header().Get("X-Checked"); v != tt.checked {
+				t.Errorf("expect X-Checked %q, got %q", tt.checked, v)
+			}
+			if called != tt.called {
+				t.Errorf("expect next handler called %d times, got %d", tt.called, called)
+			}
+			if tt.status == http.StatusOK && rec.Header().Get("Content-Type") != "text/plain" {
+				t.Errorf("unexpected content type: %q", rec.Header().Get("Content-Type"))
+			}
+			if req.Header.Get("Authorization") != tt.headers["Authorization"] {
+				t.Error("expect original request unchanged")
+			}
+		})
+	}
+}
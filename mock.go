@@ -0,0 +1,280 @@
+package starbox
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/1set/starlet"
+	"github.com/1set/starlet/dataconv"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+// mockModuleName is the name of the module for scripts to inspect the recorded calls of fakes.
+const mockModuleName = "mock"
+
+// MockCall is a recorded call of a fake set by Mock.
+type MockCall struct {
+	Target string                 // Target is the mocked builtin or module member, e.g. "now" or "http.get".
+	Args   []interface{}          // Args is the positional arguments converted to Go values, or the Starlark values if they can't be converted.
+	Kwargs map[string]interface{} // Kwargs is the keyword arguments converted like Args.
+
+	args   starlark.Tuple
+	kwargs []starlark.Tuple
+}
+
+// mockFake is the behavior of a fake.
+type mockFake struct {
+	results []interface{}
+	next    int
+	errMsg  string
+	fn      StarlarkFunc
+}
+
+// Mock replaces builtins and module members of boxes with recorded fakes for tests, the fakes return fixtures, fail, or call custom functions, and record the calls with arguments.
+// Targets are builtins like "now", which shadow builtins added by AddBuiltin() and universal ones like "len", or module members like "http.get", which replace the members of modules loaded by name, loaders or AddModuleFunctions(), and create the module if it's not in the box.
+// Scripts can inspect the recorded calls via the "mock" module, e.g. mock.calls("http.get") returns a list of structs with fields target, args and kwargs, and mock.reset() clears them.
+// It's safe for concurrent use, and shared by clones of the box.
+type Mock struct {
+	mu    sync.Mutex
+	fakes map[string]*mockFake
+	calls []MockCall
+}
+
+// NewMock creates a mock without fakes.
+func NewMock() *Mock {
+	return &Mock{fakes: make(map[string]*mockFake)}
+}
+
+// Return makes the target return the fixtures in order for each call, the last one is repeated for further calls, and None is returned if there is no fixture.
+// Fixtures are Starlark values or Go values converted by dataconv.Marshal().
+func (m *Mock) Return(target string, fixtures ...interface{}) *Mock {
+	return m.set(target, &mockFake{results: fixtures})
+}
+
+// Fail makes the target fail with the error message for each call.
+func (m *Mock) Fail(target, errMsg string) *Mock {
+	return m.set(target, &mockFake{errMsg: errMsg})
+}
+
+// Func makes the target call the function for each call, e.g. to return fixtures by arguments.
+func (m *Mock) Func(target string, fn StarlarkFunc) *Mock {
+	return m.set(target, &mockFake{fn: fn})
+}
+
+// set replaces the fake of the target.
+func (m *Mock) set(target string, f *mockFake) *Mock {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.fakes[strings.TrimSpace(target)] = f
+	return m
+}
+
+// Calls returns the recorded calls of the target in order, or all recorded calls if the target is empty.
+func (m *Mock) Calls(target string) []MockCall {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var calls []MockCall
+	for _, c := range m.calls {
+		if target == "" || c.Target == target {
+			calls = append(calls, c)
+		}
+	}
+	return calls
+}
+
+// Reset clears the recorded calls, and rewinds the fixtures of all targets, the fakes are kept.
+func (m *Mock) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.calls = nil
+	for _, f := range m.fakes {
+		f.next = 0
+	}
+}
+
+// targets returns the mocked builtins and the mocked members grouped by modules.
+func (m *Mock) targets() (builtins []string, modules map[string][]string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	modules = make(map[string][]string)
+	for target := range m.fakes {
+		if mod, member, ok := strings.Cut(target, "."); ok {
+			modules[mod] = append(modules[mod], member)
+		} else {
+			builtins = append(builtins, target)
+		}
+	}
+	sort.Strings(builtins)
+	return builtins, modules
+}
+
+// wrapLoaders wraps the loaders of mocked modules to replace the members with fakes, and appends a preload loader for mocked builtins and the "mock" module, which overrides the globals and earlier loaders.
+func (m *Mock) wrapLoaders(preMods starlet.ModuleLoaderList, lazyMods starlet.ModuleLoaderMap) (starlet.ModuleLoaderList, starlet.ModuleLoaderMap) {
+	if lazyMods == nil {
+		lazyMods = make(starlet.ModuleLoaderMap)
+	}
+	builtins, modules := m.targets()
+	names := make([]string, 0, len(modules))
+	for name := range modules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		ld := m.moduleLoader(name, modules[name], lazyMods[name])
+		lazyMods[name] = ld
+		preMods = append(preMods, ld)
+	}
+
+	mockMod := m.module()
+	lazyMods[mockModuleName] = dataconv.WrapModuleData(mockModuleName, mockMod)
+	preMods = append(preMods, func() (starlark.StringDict, error) {
+		d := starlark.StringDict{mockModuleName: &starlarkstruct.Module{Name: mockModuleName, Members: mockMod}}
+		for _, name := range builtins {
+			d[name] = m.fake(name)
+		}
+		return d, nil
+	})
+	return preMods, lazyMods
+}
+
+// moduleLoader returns a loader which loads the module with the original loader if any, and replaces the members with fakes.
+func (m *Mock) moduleLoader(name string, members []string, orig starlet.ModuleLoader) starlet.ModuleLoader {
+	return func() (starlark.StringDict, error) {
+		fakes := make(starlark.StringDict, len(members))
+		for _, member := range members {
+			fakes[member] = m.fake(name + "." + member)
+		}
+
+		d := make(starlark.StringDict)
+		if orig != nil {
+			od, err := orig()
+			if err != nil {
+				return nil, err
+			}
+			for k, v := range od {
+				d[k] = v
+			}
+		}
+		switch mod := d[name].(type) {
+		case *starlarkstruct.Module:
+			sd := make(starlark.StringDict, len(mod.Members)+len(fakes))
+			for k, v := range mod.Members {
+				sd[k] = v
+			}
+			for k, v := range fakes {
+				sd[k] = v
+			}
+			d[name] = &starlarkstruct.Module{Name: mod.Name, Members: sd}
+		case *starlarkstruct.Struct:
+			sd := make(starlark.StringDict)
+			mod.ToStringDict(sd)
+			for k, v := range fakes {
+				sd[k] = v
+			}
+			d[name] = starlarkstruct.FromStringDict(mod.Constructor(), sd)
+		default:
+			if len(d) == 0 {
+				d[name] = &starlarkstruct.Module{Name: name, Members: fakes}
+			} else {
+				// modules like go_idiomatic have members at the top level
+				for k, v := range fakes {
+					d[k] = v
+				}
+			}
+		}
+		return d, nil
+	}
+}
+
+// fake returns the builtin of the fake for the target.
+func (m *Mock) fake(target string) *starlark.Builtin {
+	return starlark.NewBuiltin(target, m.call)
+}
+
+// call records the call of the fake, and returns the next fixture, fails, or calls the custom function.
+func (m *Mock) call(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	call := MockCall{Target: b.Name(), Args: make([]interface{}, len(args)), Kwargs: make(map[string]interface{}, len(kwargs)), args: args, kwargs: kwargs}
+	for i, v := range args {
+		call.Args[i] = mockGoValue(v)
+	}
+	for _, kv := range kwargs {
+		call.Kwargs[string(kv[0].(starlark.String))] = mockGoValue(kv[1])
+	}
+
+	m.mu.Lock()
+	m.calls = append(m.calls, call)
+	f := m.fakes[b.Name()]
+	var res interface{}
+	if f != nil && len(f.results) > 0 {
+		res = f.results[f.next]
+		if f.next < len(f.results)-1 {
+			f.next++
+		}
+	}
+	m.mu.Unlock()
+
+	switch {
+	case f == nil:
+		return starlark.None, nil
+	case f.fn != nil:
+		return f.fn(thread, b, args, kwargs)
+	case f.errMsg != "":
+		return nil, fmt.Errorf("%s: %s", b.Name(), f.errMsg)
+	}
+	if v, ok := res.(starlark.Value); ok {
+		return v, nil
+	}
+	v, err := dataconv.Marshal(res)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", b.Name(), err)
+	}
+	return v, nil
+}
+
+// mockGoValue converts the argument to a Go value, or keeps the Starlark value if it can't be converted.
+func mockGoValue(v starlark.Value) interface{} {
+	if gv, err := dataconv.Unmarshal(v); err == nil {
+		return gv
+	}
+	return v
+}
+
+// module returns the members of the "mock" module.
+func (m *Mock) module() starlark.StringDict {
+	return starlark.StringDict{
+		"calls": starlark.NewBuiltin(mockModuleName+".calls", func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			var target string
+			if err := starlark.UnpackArgs(b.Name(), args, kwargs, "target?", &target); err != nil {
+				return nil, err
+			}
+			calls := m.Calls(target)
+			list := make([]starlark.Value, len(calls))
+			for i, c := range calls {
+				kw := starlark.NewDict(len(c.kwargs))
+				for _, kv := range c.kwargs {
+					_ = kw.SetKey(kv[0], kv[1])
+				}
+				list[i] = starlarkstruct.FromStringDict(starlark.String("call"), starlark.StringDict{
+					"target": starlark.String(c.Target),
+					"args":   c.args,
+					"kwargs": kw,
+				})
+			}
+			return starlark.NewList(list), nil
+		}),
+		"reset": starlark.NewBuiltin(mockModuleName+".reset", func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			if err := starlark.UnpackArgs(b.Name(), args, kwargs); err != nil {
+				return nil, err
+			}
+			m.Reset()
+			return starlark.None, nil
+		}),
+	}
+}
@@ -0,0 +1,121 @@
+package starbox_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/PureMature/starbox"
+	"go.starlark.net/starlark"
+)
+
+// TestMock tests the following:
+// 1. Mock a builtin module member, a member of a module added by AddModuleFunctions(), a builtin, a universal builtin, and a member of a missing module.
+// 2. Check the fakes return fixtures in order, fail, or call custom functions, and other members are kept.
+// 3. Check the recorded calls from Go and from the script via the mock module.
+// 4. Check the mock is reset and shared by clones.
+func TestMock(t *testing.T) {
+	m := starbox.NewMock().
+		Return("http.get", map[string]interface{}{"status_code": 200}, starlark.String("second")).
+		Return("svc.fetch", "fake").
+		Fail("now", "clock is broken").
+		Func("len", func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			return starlark.MakeInt(42), nil
+		}).
+		Return("ext.ping")
+
+	b := starbox.New("test")
+	b.SetModuleSet(starbox.NetworkModuleSet)
+	b.AddModuleFunctions("svc", starbox.FuncMap{
+		"fetch": func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			return starlark.String("real"), nil
+		},
+		"other": func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			return starlark.String("other"), nil
+		},
+	})
+	b.AddBuiltin("now", func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		return starlark.String("real now"), nil
+	})
+	b.SetMock(m)
+
+	out, err := b.Run(HereDoc(`
+		load("http", "get")
+		load("svc", "fetch")
+		r1 = http.get("https://example.com", headers={"a": "b"})
+		r2 = get("https://example.com/2")
+		r3 = get("https://example.com/3")
+		f = fetch(1, 2)
+		o = svc.other()
+		n = len([1])
+		p = ext.ping()
+		e = mock.calls("now")
+		c = [(c.target, c.args, c.kwargs) for c in mock.calls("http.get")]
+	`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := fmt.Sprintf("%v %v %v %v %v %v %v %v", out["r1"], out["r2"], out["r3"], out["f"], out["o"], out["n"], out["p"], out["e"])
+	if got != "map[status_code:200] second second fake other 42 <nil> []" {
+		t.Errorf("unexpected output: %s", got)
+	}
+	if got := fmt.Sprint(out["c"]); !strings.Contains(got, `[http.get [https://example.com] map[headers:map[a:b]]]`) || !strings.Contains(got, `[http.get [https://example.com/3] map[]]`) {
+		t.Errorf("unexpected calls in script: %s", got)
+	}
+
+	// calls from Go
+	calls := m.Calls("")
+	if len(calls) != 6 {
+		t.Errorf("unexpected number of calls: %d", len(calls))
+	}
+	if c := m.Calls("svc.fetch"); len(c) != 1 || fmt.Sprint(c[0].Args) != "[1 2]" {
+		t.Errorf("unexpected calls of fetch: %v", c)
+	}
+	if c := m.Calls("http.get"); len(c) != 3 || c[0].Kwargs["headers"].(map[string]interface{})["a"] != "b" {
+		t.Errorf("unexpected calls of get: %v", c)
+	}
+
+	// failure of fake
+	if _, err := b.Run(`now()`); err == nil || !strings.Contains(err.Error(), "now: clock is broken") {
+		t.Errorf("expect error of fake, got %v", err)
+	}
+	if c := m.Calls("now"); len(c) != 1 {
+		t.Errorf("unexpected calls of now: %v", c)
+	}
+
+	// reset and clone
+	m.Reset()
+	c := b.Clone()
+	out, err = c.Run(`r = http.get("x")`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(out["r"]) != "map[status_code:200]" || len(m.Calls("")) != 1 {
+		t.Errorf("unexpected output after reset: %v, calls: %v", out, m.Calls(""))
+	}
+}
+
+// TestMock_ScriptTests tests the mock of the template box is reset for each script test, and scripts can assert on the recorded calls.
+func TestMock_ScriptTests(t *testing.T) {
+	tpl := starbox.New("tpl")
+	tpl.SetMock(starbox.NewMock().Return("svc.get", 1, 2))
+	report := starbox.RunScriptTests(tpl, []starbox.ScriptTestFile{{Name: "svc_test.star", Script: HereDoc(`
+		def test_first():
+			assert.eq(svc.get("a"), 1)
+			assert.eq(svc.get("b"), 2)
+			assert.eq(len(mock.calls("svc.get")), 2)
+		def test_second():
+			assert.eq(svc.get("c"), 1)
+			calls = mock.calls()
+			assert.eq(len(calls), 1)
+			assert.eq(calls[0].args, ("c",))
+	`)}})
+	for _, res := range report.Results {
+		if !res.Passed() {
+			t.Errorf("%s failed: %s", res.Name, res.Message)
+		}
+	}
+	if len(report.Results) != 2 {
+		t.Errorf("unexpected results: %d", len(report.Results))
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 
 	"github.com/1set/starlet"
+	lassert "github.com/PureMature/starbox/module/assert"
 	// lrt "github.com/PureMature/starbox/module/runtime"
 )
 
@@ -30,6 +31,7 @@ var (
 	}
 	localModuleLoaders = starlet.ModuleLoaderMap{
 		// lrt.ModuleName: lrt.LoadModule,
+		lassert.ModuleName: lassert.LoadModule,
 	}
 )
 
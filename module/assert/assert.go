@@ -0,0 +1,153 @@
+// Package assert implements the Starlark module for assertions in script tests, e.g. assert.eq(got, want) fails the script with a message if the values are not equal.
+package assert
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sync"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+	"go.starlark.net/syntax"
+)
+
+// ModuleName defines the expected name for this Module when used in starlark's load() function, eg: load('assert', 'assert')
+const ModuleName = "assert"
+
+var (
+	once       sync.Once
+	moduleData starlark.StringDict
+)
+
+// LoadModule loads the assert module. It is concurrency-safe and idempotent.
+func LoadModule() (starlark.StringDict, error) {
+	once.Do(func() {
+		moduleData = starlark.StringDict{
+			ModuleName: &starlarkstruct.Module{
+				Name: ModuleName,
+				Members: starlark.StringDict{
+					"eq":       starlark.NewBuiltin(ModuleName+".eq", eq),
+					"ne":       starlark.NewBuiltin(ModuleName+".ne", ne),
+					"true":     starlark.NewBuiltin(ModuleName+".true", isTrue),
+					"fails":    starlark.NewBuiltin(ModuleName+".fails", fails),
+					"contains": starlark.NewBuiltin(ModuleName+".contains", contains),
+				},
+			},
+		}
+	})
+	return moduleData, nil
+}
+
+// failure returns the error of the failed assertion, with the optional message of the caller.
+func failure(b *starlark.Builtin, msg starlark.String, format string, args ...interface{}) error {
+	detail := fmt.Sprintf(format, args...)
+	if msg != "" {
+		return fmt.Errorf("%s: %s: %s", b.Name(), string(msg), detail)
+	}
+	return fmt.Errorf("%s: %s", b.Name(), detail)
+}
+
+// eq fails if the two values are not equal, e.g. assert.eq(got, want, msg="").
+func eq(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var (
+		x, y starlark.Value
+		msg  starlark.String
+	)
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "got", &x, "want", &y, "msg?", &msg); err != nil {
+		return nil, err
+	}
+	ok, err := starlark.Equal(x, y)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, failure(b, msg, "%s != %s", x, y)
+	}
+	return starlark.None, nil
+}
+
+// ne fails if the two values are equal, e.g. assert.ne(got, unwanted, msg="").
+func ne(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var (
+		x, y starlark.Value
+		msg  starlark.String
+	)
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "got", &x, "unwanted", &y, "msg?", &msg); err != nil {
+		return nil, err
+	}
+	ok, err := starlark.Equal(x, y)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		return nil, failure(b, msg, "%s == %s", x, y)
+	}
+	return starlark.None, nil
+}
+
+// isTrue fails if the value is not truthy, e.g. assert.true(cond, msg="").
+func isTrue(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var (
+		cond starlark.Value
+		msg  starlark.String
+	)
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "cond", &cond, "msg?", &msg); err != nil {
+		return nil, err
+	}
+	if !cond.Truth() {
+		return nil, failure(b, msg, "%s is not true", cond)
+	}
+	return starlark.None, nil
+}
+
+// fails calls the function without arguments, fails if it succeeds or its error doesn't match the optional regular expression, and returns the error message, e.g. assert.fails(lambda: 1 // 0, "division by zero").
+func fails(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var (
+		fn      starlark.Callable
+		pattern starlark.String
+	)
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "fn", &fn, "pattern?", &pattern); err != nil {
+		return nil, err
+	}
+	var re *regexp.Regexp
+	if pattern != "" {
+		var err error
+		if re, err = regexp.Compile(string(pattern)); err != nil {
+			return nil, fmt.Errorf("%s: invalid pattern: %w", b.Name(), err)
+		}
+	}
+
+	_, err := starlark.Call(thread, fn, nil, nil)
+	if err == nil {
+		return nil, fmt.Errorf("%s: %s succeeded unexpectedly", b.Name(), fn.Name())
+	}
+	msg := err.Error()
+	var evalErr *starlark.EvalError
+	if errors.As(err, &evalErr) {
+		msg = evalErr.Msg
+	}
+	if re != nil && !re.MatchString(msg) {
+		return nil, fmt.Errorf("%s: error %q does not match %q", b.Name(), msg, re)
+	}
+	return starlark.String(msg), nil
+}
+
+// contains fails if the container doesn't contain the item by the `in` operator, e.g. assert.contains([1, 2], 1, msg="").
+func contains(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var (
+		container, item starlark.Value
+		msg             starlark.String
+	)
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "container", &container, "item", &item, "msg?", &msg); err != nil {
+		return nil, err
+	}
+	in, err := starlark.Binary(syntax.IN, item, container)
+	if err != nil {
+		return nil, err
+	}
+	if !in.Truth() {
+		return nil, failure(b, msg, "%s does not contain %s", container, item)
+	}
+	return starlark.None, nil
+}
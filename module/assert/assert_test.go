@@ -0,0 +1,139 @@
+package assert_test
+
+import (
+	"testing"
+
+	"github.com/PureMature/starbox/module/assert"
+	"go.starlark.net/starlark"
+)
+
+// TestLoadModule_Assert tests the following:
+// 1. Load the module, and run scripts calling each assertion.
+// 2. Check the assertions pass with valid values, and the returned values.
+// 3. Check the failures of assertions with and without messages, and the errors of invalid arguments.
+func TestLoadModule_Assert(t *testing.T) {
+	tests := []struct {
+		name    string
+		script  string
+		wantErr string
+	}{
+		{
+			name:   "eq pass",
+			script: `assert.eq(1 + 1, 2); assert.eq([1, "a"], [1, "a"], msg="list")`,
+		},
+		{
+			name:    "eq fail",
+			script:  `assert.eq(1, 2)`,
+			wantErr: `assert.eq: 1 != 2`,
+		},
+		{
+			name:    "eq fail with message",
+			script:  `assert.eq("a", "b", msg="strings")`,
+			wantErr: `assert.eq: strings: "a" != "b"`,
+		},
+		{
+			name:    "eq missing argument",
+			script:  `assert.eq(1)`,
+			wantErr: `assert.eq: missing argument for want`,
+		},
+		{
+			name:   "ne pass",
+			script: `assert.ne(1, 2); assert.ne(None, 0, "none")`,
+		},
+		{
+			name:    "ne fail",
+			script:  `assert.ne([1], [1])`,
+			wantErr: `assert.ne: [1] == [1]`,
+		},
+		{
+			name:    "ne fail with message",
+			script:  `assert.ne(1, 1, msg="same")`,
+			wantErr: `assert.ne: same: 1 == 1`,
+		},
+		{
+			name:   "true pass",
+			script: `assert.true(1); assert.true([0], msg="non-empty")`,
+		},
+		{
+			name:    "true fail",
+			script:  `assert.true([])`,
+			wantErr: `assert.true: [] is not true`,
+		},
+		{
+			name:    "true fail with message",
+			script:  `assert.true(None, "nothing")`,
+			wantErr: `assert.true: nothing: None is not true`,
+		},
+		{
+			name: "fails pass",
+			script: `
+msg = assert.fails(lambda: 1 // 0)
+assert.eq(msg, "floored division by zero")
+assert.eq(assert.fails(lambda: fail("oops"), "oo+"), "fail: oops")
+`,
+		},
+		{
+			name: "fails unexpected success",
+			script: `
+def ok():
+	pass
+assert.fails(ok)
+`,
+			wantErr: `assert.fails: ok succeeded unexpectedly`,
+		},
+		{
+			name:    "fails mismatch",
+			script:  `assert.fails(lambda: fail("oops"), "^boom")`,
+			wantErr: `assert.fails: error "fail: oops" does not match "^boom"`,
+		},
+		{
+			name:    "fails invalid pattern",
+			script:  `assert.fails(lambda: fail("oops"), "(")`,
+			wantErr: "assert.fails: invalid pattern: error parsing regexp: missing closing ): `(`",
+		},
+		{
+			name:    "fails not callable",
+			script:  `assert.fails(1)`,
+			wantErr: `assert.fails: for parameter fn: got int, want callable`,
+		},
+		{
+			name:   "contains pass",
+			script: `assert.contains([1, 2], 1); assert.contains("hello", "ell"); assert.contains({"k": 1}, "k", msg="dict")`,
+		},
+		{
+			name:    "contains fail",
+			script:  `assert.contains([1, 2], 3)`,
+			wantErr: `assert.contains: [1, 2] does not contain 3`,
+		},
+		{
+			name:    "contains fail with message",
+			script:  `assert.contains("abc", "z", msg="letters")`,
+			wantErr: `assert.contains: letters: "abc" does not contain "z"`,
+		},
+		{
+			name:    "contains invalid container",
+			script:  `assert.contains(1, 1)`,
+			wantErr: `unknown binary op: int in int`,
+		},
+	}
+
+	predeclared, err := assert.LoadModule()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := starlark.ExecFile(&starlark.Thread{Name: "test"}, "test.star", tt.script, predeclared)
+			var got string
+			if err != nil {
+				got = err.Error()
+				if evalErr, ok := err.(*starlark.EvalError); ok {
+					got = evalErr.Msg
+				}
+			}
+			if got != tt.wantErr {
+				t.Errorf("expect error %q, got %q", tt.wantErr, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package starbox
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/1set/starlet"
+	"go.starlark.net/starlark"
+)
+
+// profileMu serializes the profiled executions, since the Starlark profiler is global to the process.
+var profileMu sync.Mutex
+
+// profileRun runs the execution with the Starlark profiler writing to the writer set by SetProfile() or a buffer for the function set by SetProfileFunc(), or just runs it if there is neither.
+func (s *Starbox) profileRun(run func() (starlet.StringAnyMap, error)) (starlet.StringAnyMap, error) {
+	var w io.Writer
+	var buf *bytes.Buffer
+	switch {
+	case s.profileW != nil:
+		w = s.profileW
+	case s.profileFn != nil:
+		buf = &bytes.Buffer{}
+		w = buf
+	default:
+		return run()
+	}
+
+	profileMu.Lock()
+	defer profileMu.Unlock()
+
+	if err := starlark.StartProfile(w); err != nil {
+		return nil, fmt.Errorf("start profile: %w", err)
+	}
+	out, err := run()
+	if perr := starlark.StopProfile(); perr != nil && err == nil {
+		err = fmt.Errorf("stop profile: %w", perr)
+	} else if perr == nil && buf != nil {
+		s.profileFn(buf.Bytes())
+	}
+	return out, err
+}
@@ -0,0 +1,177 @@
+package starbox_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/PureMature/starbox"
+	"go.starlark.net/starlark"
+)
+
+// TestSetProfile tests the following:
+// 1. Set the profile writer, and run a script with a hot function.
+// 2. Check the profile is gzipped and contains the Starlark function names and the script name.
+// 3. Check no profile is written after the writer is set to nil.
+// 4. Check the error when the profiler is already started.
+func TestSetProfile(t *testing.T) {
+	var buf bytes.Buffer
+	b := starbox.New("test")
+	b.SetProfile(&buf)
+	out, err := b.Run(HereDoc(`
+		def hot(n):
+			s = 0
+			for i in range(n):
+				s += i * i
+			return s
+		def run():
+			t = 0
+			for _ in range(50):
+				t += hot(10000)
+			return t
+		x = run()
+	`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out["x"] == nil {
+		t.Errorf("unexpected output: %v", out)
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"hot", "run", "box.star"} {
+		if !bytes.Contains(data, []byte(s)) {
+			t.Errorf("expect profile to contain %q", s)
+		}
+	}
+
+	// disable the profile
+	buf.Reset()
+	b.SetProfile(nil)
+	if _, err := b.Run(`y = 1`); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("unexpected profile after disabled: %d bytes", buf.Len())
+	}
+
+	// profiler is already started
+	if err := starlark.StartProfile(io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	defer starlark.StopProfile()
+	c := starbox.New("test")
+	c.SetProfile(&buf)
+	if _, err := c.Run(`z = 1`); err == nil || !strings.Contains(err.Error(), "start profile: profiler already running") {
+		t.Errorf("expect error of started profiler, got %v", err)
+	}
+}
+
+// TestSetProfileFunc tests the following:
+// 1. Set the profile function, and run scripts twice.
+// 2. Parse each profile, and check it's a single gzipped pprof profile with the function names of its own run.
+// 3. Check profiles are no longer collected after the writer is set.
+func TestSetProfileFunc(t *testing.T) {
+	var profiles [][]byte
+	b := starbox.New("test")
+	b.SetProfileFunc(func(profile []byte) {
+		profiles = append(profiles, profile)
+	})
+	for _, name := range []string{"first", "second"} {
+		script := HereDoc(`
+			def %s():
+				s = 0
+				for i in range(200000):
+					s += i
+				return s
+			x = %s()
+		`, name, name)
+		if _, err := b.Run(script); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(profiles) != 2 {
+		t.Fatalf("expect 2 profiles, got %d", len(profiles))
+	}
+	for i, name := range []string{"first", "second"} {
+		strs := profileStrings(t, profiles[i])
+		if len(strs) == 0 || strs[0] != "" {
+			t.Errorf("profile %d: expect empty first string, got %q", i, strs)
+		}
+		var found bool
+		for _, s := range strs {
+			if s == name {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("profile %d: expect function %q, got %q", i, name, strs)
+		}
+	}
+
+	// the writer replaces the function
+	var buf bytes.Buffer
+	b.SetProfile(&buf)
+	if _, err := b.Run(`y = 1`); err != nil {
+		t.Fatal(err)
+	}
+	if len(profiles) != 2 || buf.Len() == 0 {
+		t.Errorf("expect profile written to the writer only, got %d profiles and %d bytes", len(profiles), buf.Len())
+	}
+}
+
+// profileStrings parses the gzipped pprof profile, and returns its string table, it fails if there is more than one gzip member or the protobuf message is malformed.
+func profileStrings(t *testing.T, data []byte) []string {
+	t.Helper()
+	br := bytes.NewReader(data)
+	zr, err := gzip.NewReader(br)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zr.Multistream(false)
+	msg, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if br.Len() != 0 {
+		t.Fatalf("unexpected %d bytes after the profile", br.Len())
+	}
+
+	var strs []string
+	for len(msg) > 0 {
+		key, n := binary.Uvarint(msg)
+		if n <= 0 {
+			t.Fatalf("malformed field key at %d bytes left", len(msg))
+		}
+		msg = msg[n:]
+		switch key & 7 {
+		case 0: // varint
+			if _, n = binary.Uvarint(msg); n <= 0 {
+				t.Fatal("malformed varint")
+			}
+			msg = msg[n:]
+		case 2: // length-delimited
+			l, n := binary.Uvarint(msg)
+			if n <= 0 || uint64(len(msg)-n) < l {
+				t.Fatal("malformed length-delimited field")
+			}
+			if key>>3 == 6 { // string_table
+				strs = append(strs, string(msg[n:n+int(l)]))
+			}
+			msg = msg[n+int(l):]
+		default:
+			t.Fatalf("unexpected wire type %d", key&7)
+		}
+	}
+	return strs
+}
@@ -0,0 +1,360 @@
+package starbox
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/chzyer/readline"
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+const (
+	// DefaultREPLPrompt is the default prompt of REPL sessions for the first line of input.
+	DefaultREPLPrompt = ">>> "
+	// DefaultREPLContinuePrompt is the default prompt of REPL sessions for continuation lines of input.
+	DefaultREPLContinuePrompt = "... "
+)
+
+// REPLOption configures the REPL session started by REPLWith().
+type REPLOption func(c *replConfig)
+
+// REPLPrompt sets the prompts of the REPL session for the first line and continuation lines of input, empty strings disable the prompts.
+func REPLPrompt(prompt, continuePrompt string) REPLOption {
+	return func(c *replConfig) {
+		c.prompt = prompt
+		c.continuePrompt = continuePrompt
+	}
+}
+
+// REPLContext sets the context of the REPL session, the running input is cancelled and the session ends when the context is done.
+// Note that a blocking read of the input can't be interrupted, the caller should close the input to end the session immediately.
+func REPLContext(ctx context.Context) REPLOption {
+	return func(c *replConfig) {
+		if ctx != nil {
+			c.ctx = ctx
+		}
+	}
+}
+
+// replConfig is the configuration of a REPL session.
+type replConfig struct {
+	ctx            context.Context
+	prompt         string
+	continuePrompt string
+	historyDir     string
+	transcript     io.Writer
+}
+
+func newREPLConfig(opts []REPLOption) replConfig {
+	cfg := replConfig{
+		ctx:            context.Background(),
+		prompt:         DefaultREPLPrompt,
+		continuePrompt: DefaultREPLContinuePrompt,
+	}
+	for _, opt := range opts {
+		if opt != nil {
+			opt(&cfg)
+		}
+	}
+	return cfg
+}
+
+// lineReader reads lines of input for REPL sessions.
+type lineReader interface {
+	// ReadLine shows the prompt, and reads a line of input including the newline, it returns io.EOF at the end of input.
+	ReadLine(prompt string) ([]byte, error)
+}
+
+// streamReader reads lines from a stream, and writes prompts to another.
+type streamReader struct {
+	in  *bufio.Reader
+	out io.Writer
+	eof bool
+}
+
+func (r *streamReader) ReadLine(prompt string) ([]byte, error) {
+	if r.eof {
+		return nil, io.EOF
+	}
+	if prompt != "" {
+		fmt.Fprint(r.out, prompt)
+	}
+	line, err := r.in.ReadBytes('\n')
+	if err != nil {
+		if err != io.EOF {
+			return nil, err
+		}
+		r.eof = true
+		if len(line) == 0 {
+			return nil, io.EOF
+		}
+		line = append(line, '\n')
+	}
+	return line, nil
+}
+
+// terminalReader reads lines from the terminal with line editing and completion.
+type terminalReader struct {
+	rl *readline.Instance
+}
+
+func (r *terminalReader) ReadLine(prompt string) ([]byte, error) {
+	r.rl.SetPrompt(prompt)
+	line, err := r.rl.Readline()
+	if err != nil {
+		return nil, err
+	}
+	return []byte(line + "\n"), nil
+}
+
+// replSession is a REPL session with the thread and globals of a Starbox instance.
+type replSession struct {
+	replConfig
+	box         *Starbox
+	reader      lineReader
+	out         io.Writer
+	thread      *starlark.Thread
+	globals     starlark.StringDict
+	modules     []string            // names of modules to load for completion
+	recorder    *transcriptRecorder // recorder of the transcript, nil if not enabled
+	redirect    bool                // whether to redirect print() to the output
+	eof         bool
+	interrupted bool
+}
+
+// REPLWith starts a REPL session reading input from the reader and writing prompts, results, printed messages and errors to the writer, it returns when the input ends, or the context set by REPLContext() is done.
+// Unlike REPL(), it doesn't use the terminal of the process, so sessions can be hosted over any stream, e.g. a web console or a network connection.
+// It can be called before or after other executions, and the globals of previous executions are available in the session.
+func (s *Starbox) REPLWith(in io.Reader, out io.Writer, opts ...REPLOption) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if in == nil || out == nil {
+		return errors.New("nil input or output for REPL")
+	}
+	if err := s.warmUp(); err != nil {
+		return err
+	}
+	sess, err := s.newREPLSession(out, newREPLConfig(opts))
+	if err != nil {
+		return err
+	}
+	sess.reader = &streamReader{in: bufio.NewReader(in), out: out}
+	if sess.historyDir != "" {
+		hf, err := openHistory(historyPath(sess.historyDir, s.name))
+		if err != nil {
+			return err
+		}
+		defer hf.Close()
+		sess.reader = &historyReader{lineReader: sess.reader, w: hf}
+	}
+	sess.redirect = true
+	return sess.run()
+}
+
+// warmUp prepares the environment and runs an empty script to set up the thread and globals for REPL sessions.
+func (s *Starbox) warmUp() error {
+	if err := s.prepareEnv(""); err != nil {
+		return err
+	}
+	s.hasExec = true
+	s.execTimes++
+	_, err := s.mac.Run()
+	return err
+}
+
+// newREPLSession creates a session with the thread and globals of the machine which has been executed.
+func (s *Starbox) newREPLSession(out io.Writer, cfg replConfig) (*replSession, error) {
+	thread := s.mac.GetStarlarkThread()
+	globals := s.mac.GetStarlarkPredeclared()
+	if thread == nil || globals == nil {
+		return nil, errors.New("no thread for REPL")
+	}
+	_, modules := s.completionSource()
+	sess := &replSession{
+		replConfig: cfg,
+		box:        s,
+		thread:     thread,
+		globals:    globals,
+		modules:    modules,
+	}
+	if cfg.transcript != nil {
+		sess.recorder = newTranscriptRecorder(cfg.transcript, s.name)
+	}
+	sess.setOutput(out)
+	return sess, nil
+}
+
+// terminalREPL starts a REPL session on the terminal of the process with line editing, tab completion and history, the machine must have been executed.
+// The history is only kept if REPLHistoryDir() sets the directory, and it's disabled if the directory can't be created.
+func (s *Starbox) terminalREPL(opts ...REPLOption) error {
+	cfg := newREPLConfig(opts)
+	sess, err := s.newREPLSession(os.Stdout, cfg)
+	if err != nil {
+		return err
+	}
+	var historyFile string
+	if cfg.historyDir != "" {
+		if err := os.MkdirAll(cfg.historyDir, 0700); err == nil {
+			historyFile = historyPath(cfg.historyDir, s.name)
+		}
+	}
+	rl, err := readline.NewEx(&readline.Config{
+		Prompt:       sess.prompt,
+		HistoryFile:  historyFile,
+		AutoComplete: &replCompleter{sess: sess},
+	})
+	if err != nil {
+		return err
+	}
+	defer rl.Close()
+
+	sess.reader = &terminalReader{rl: rl}
+	sess.setOutput(rl.Stdout())
+	return sess.run()
+}
+
+// setOutput sets the output of the session, which is also recorded by the transcript if enabled.
+func (r *replSession) setOutput(out io.Writer) {
+	if r.recorder != nil {
+		out = io.MultiWriter(out, r.recorder)
+	}
+	r.out = out
+}
+
+// run reads, evaluates and prints until the input ends or the context is done.
+func (r *replSession) run() error {
+	oldPrint := r.thread.Print
+	if r.redirect {
+		r.thread.Print = func(_ *starlark.Thread, msg string) {
+			fmt.Fprintln(r.out, msg)
+		}
+	} else if r.recorder != nil {
+		r.thread.Print = func(thread *starlark.Thread, msg string) {
+			if oldPrint != nil {
+				oldPrint(thread, msg)
+			} else {
+				fmt.Println(msg)
+			}
+			fmt.Fprintln(r.recorder, msg)
+		}
+	}
+	defer func() {
+		r.thread.Print = oldPrint
+	}()
+	if _, ok := r.globals[helpBuiltinName]; !ok {
+		r.globals[helpBuiltinName] = r.box.helpBuiltin()
+		defer delete(r.globals, helpBuiltinName)
+	}
+
+	for {
+		if err := r.ctx.Err(); err != nil {
+			return nil
+		}
+		if err := r.rep(); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// readLine reads a line with the reader, and records the end of input or interruption.
+func (r *replSession) readLine(prompt string) ([]byte, error) {
+	line, err := r.reader.ReadLine(prompt)
+	if err == nil && r.recorder != nil {
+		r.recorder.addInput(line)
+	}
+	if err == io.EOF {
+		r.eof = true
+	} else if err == readline.ErrInterrupt {
+		r.interrupted = true
+	}
+	return line, err
+}
+
+// rep reads a chunk of input, evaluates it, and prints the result or error.
+func (r *replSession) rep() error {
+	// parse
+	opts := *scriptFileOptions()
+	opts.LoadBindsGlobally = true
+	first := true
+	r.interrupted = false
+	if r.recorder != nil {
+		r.recorder.begin()
+	}
+	f, err := opts.ParseCompoundStmt("<stdin>", func() ([]byte, error) {
+		prompt := r.continuePrompt
+		if first {
+			prompt, first = r.prompt, false
+		}
+		return r.readLine(prompt)
+	})
+	if err != nil {
+		if r.eof {
+			return io.EOF
+		}
+		if r.interrupted {
+			fmt.Fprintln(r.out, readline.ErrInterrupt)
+			return nil
+		}
+		var se syntax.Error
+		if !errors.As(err, &se) {
+			return err
+		}
+		r.printError(err)
+		r.commit(true)
+		return nil
+	}
+
+	// evaluate and print
+	err = withThreadContext(r.ctx, r.thread, func() error {
+		if expr := soleExpr(f); expr != nil {
+			v, err := starlark.EvalExprOptions(f.Options, r.thread, expr, r.globals)
+			if err != nil {
+				return err
+			} else if v != starlark.None {
+				fmt.Fprintln(r.out, v)
+			}
+			return nil
+		}
+		return starlark.ExecREPLChunk(f, r.thread, r.globals)
+	})
+	if err != nil {
+		r.printError(err)
+	}
+	r.commit(err != nil)
+	return nil
+}
+
+// commit writes the input and output to the transcript if enabled.
+func (r *replSession) commit(failed bool) {
+	if r.recorder != nil {
+		r.recorder.commit(failed)
+	}
+}
+
+// printError prints the error with the backtrace for evaluation errors.
+func (r *replSession) printError(err error) {
+	if ee, ok := err.(*starlark.EvalError); ok {
+		fmt.Fprintln(r.out, ee.Backtrace())
+	} else {
+		fmt.Fprintln(r.out, err)
+	}
+}
+
+// soleExpr returns the expression if the file consists of a single expression statement, or nil otherwise.
+func soleExpr(f *syntax.File) syntax.Expr {
+	if len(f.Stmts) == 1 {
+		if stmt, ok := f.Stmts[0].(*syntax.ExprStmt); ok {
+			return stmt.X
+		}
+	}
+	return nil
+}
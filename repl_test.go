@@ -0,0 +1,90 @@
+package starbox_test
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/PureMature/starbox"
+	"go.starlark.net/starlark"
+)
+
+// TestREPLWith tests the following:
+// 1. Create a new Starbox instance with globals and modules, and run a script.
+// 2. Start a REPL session with input from a string, and output to a buffer.
+// 3. Check the results, printed messages and errors in the output, including multi-line input and globals from the previous run.
+// 4. Check the globals defined in the session are kept for later runs.
+func TestREPLWith(t *testing.T) {
+	b := starbox.New("test")
+	b.AddKeyValue("base", 10)
+	b.AddNamedModules("math", "json")
+	if _, err := b.Run(`prev = base * 2`); err != nil {
+		t.Fatal(err)
+	}
+
+	input := HereDoc(`
+		prev + 1
+		print("Aloha")
+		def add(a, b):
+			return a + b
+
+		add(prev, base)
+		x = math.floor(3.7)
+		undefined_name
+		1 +
+		load("json", "encode")
+		encode([x])
+		None
+		last = "done"`)
+	var out bytes.Buffer
+	if err := b.REPLWith(strings.NewReader(input), &out, starbox.REPLPrompt("> ", ". ")); err != nil {
+		t.Fatal(err)
+	}
+	got := out.String()
+	for _, s := range []string{
+		"> 21\n",
+		"> Aloha\n",
+		"> . . > 30\n",
+		"undefined: undefined_name",
+		"got newline, want primary expression",
+		`> "[3]"` + "\n",
+	} {
+		if !strings.Contains(got, s) {
+			t.Errorf("expect output contains %q, got:\n%s", s, got)
+		}
+	}
+	if strings.Contains(got, "None") {
+		t.Errorf("expect None not printed, got:\n%s", got)
+	}
+
+	out2, err := b.Run(`res = last + str(x)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out2["res"] != "done3" {
+		t.Errorf("unexpected output: %v", out2)
+	}
+}
+
+// TestREPLWith_Context tests the REPL session ends when the context is done, and the running input is cancelled.
+func TestREPLWith_Context(t *testing.T) {
+	b := starbox.New("test")
+	ctx, cancel := context.WithCancel(context.Background())
+	b.AddBuiltin("stop", func(thread *starlark.Thread, bt *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		cancel()
+		return starlark.None, nil
+	})
+	var out bytes.Buffer
+	input := "stop()\nwhile True:\n  pass\n\nprint('never')\n"
+	if err := b.REPLWith(strings.NewReader(input), &out, starbox.REPLContext(ctx), starbox.REPLPrompt("", "")); err != nil {
+		t.Fatal(err)
+	}
+	if got := out.String(); strings.Contains(got, "never") {
+		t.Errorf("unexpected output: %q", got)
+	}
+
+	if err := b.REPLWith(nil, &out); err == nil {
+		t.Error("expect error for nil input, got nil")
+	}
+}
@@ -0,0 +1,285 @@
+package starbox
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultREPLSessionTimeout is the default maximum duration of each session of REPL servers running sessions on clones of the box.
+const DefaultREPLSessionTimeout = 30 * time.Minute
+
+// DefaultREPLSharedSessionTimeout is the default maximum duration of each session of REPL servers sharing the box with sessions, which is shorter since each session holds the lock of the box.
+const DefaultREPLSharedSessionTimeout = time.Minute
+
+// replAuthTimeout is the time limit for clients to send the token after connecting.
+const replAuthTimeout = 10 * time.Second
+
+// ErrREPLServerClosed is returned by the Serve() and ListenAndServe() methods of REPLServer after Close() is called.
+var ErrREPLServerClosed = errors.New("starbox: REPL server closed")
+
+// REPLServerOption configures the REPL server created by NewREPLServer().
+type REPLServerOption func(srv *REPLServer)
+
+// REPLServerToken sets the token that clients must send as the first line after connecting, an empty token disables the authentication, which is only allowed for unix sockets.
+func REPLServerToken(token string) REPLServerOption {
+	return func(srv *REPLServer) {
+		srv.token = token
+	}
+}
+
+// REPLServerTimeout sets the maximum duration of each session, the connection is closed and the running input is cancelled when it expires, zero or negative means no timeout.
+// Without REPLServerClone(), the box is locked for the whole session, so the executions of the box wait up to the timeout, and no timeout blocks them until the client disconnects.
+func REPLServerTimeout(timeout time.Duration) REPLServerOption {
+	return func(srv *REPLServer) {
+		srv.timeout = timeout
+		srv.timeoutSet = true
+	}
+}
+
+// REPLServerClone makes each session run on a clone of the box created by Clone(), so sessions don't block the executions of the box, and share only the attached memories with it.
+// Without it, each session holds the lock of the box until it ends, and the default session timeout is DefaultREPLSharedSessionTimeout instead of DefaultREPLSessionTimeout.
+func REPLServerClone() REPLServerOption {
+	return func(srv *REPLServer) {
+		srv.clone = true
+	}
+}
+
+// REPLServerSessionOptions sets the options of each REPL session, e.g. REPLPrompt() or REPLTranscript().
+func REPLServerSessionOptions(opts ...REPLOption) REPLServerOption {
+	return func(srv *REPLServer) {
+		srv.replOpts = append(srv.replOpts, opts...)
+	}
+}
+
+// REPLServer hosts REPL sessions over network connections for live debugging, each connection gets a session bound to the box or a clone of it.
+// Without REPLServerClone(), a session holds the lock of the box like REPLWith(), so executions of the box and other sessions wait until it ends or times out.
+type REPLServer struct {
+	box        *Starbox
+	token      string
+	timeout    time.Duration
+	timeoutSet bool
+	clone      bool
+	replOpts   []REPLOption
+
+	ctx       context.Context
+	cancel    context.CancelFunc
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	closed    bool
+	wg        sync.WaitGroup
+}
+
+// NewREPLServer creates a REPL server for the box with the options, the default session timeout is DefaultREPLSessionTimeout with REPLServerClone(), or DefaultREPLSharedSessionTimeout without it.
+func NewREPLServer(box *Starbox, opts ...REPLServerOption) *REPLServer {
+	ctx, cancel := context.WithCancel(context.Background())
+	srv := &REPLServer{
+		box:       box,
+		ctx:       ctx,
+		cancel:    cancel,
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[net.Conn]struct{}),
+	}
+	for _, opt := range opts {
+		if opt != nil {
+			opt(srv)
+		}
+	}
+	if !srv.timeoutSet {
+		srv.timeout = DefaultREPLSharedSessionTimeout
+		if srv.clone {
+			srv.timeout = DefaultREPLSessionTimeout
+		}
+	}
+	return srv
+}
+
+// ListenAndServe listens on the network address and serves REPL sessions, the network must be "unix" for a socket file, or "tcp", "tcp4" and "tcp6" which require a token.
+// A stale socket file at the address is removed before listening, and the socket file is only accessible by the owner.
+func (srv *REPLServer) ListenAndServe(network, address string) error {
+	switch network {
+	case "unix":
+		if fi, err := os.Lstat(address); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			_ = os.Remove(address)
+		}
+	case "tcp", "tcp4", "tcp6":
+		if srv.token == "" {
+			return errors.New("token is required for REPL server over TCP")
+		}
+	default:
+		return fmt.Errorf("unsupported network for REPL server: %s", network)
+	}
+
+	l, err := net.Listen(network, address)
+	if err != nil {
+		return err
+	}
+	if network == "unix" {
+		if err := os.Chmod(address, 0600); err != nil {
+			l.Close()
+			return err
+		}
+	}
+	return srv.Serve(l)
+}
+
+// Serve accepts connections on the listener and serves a REPL session for each of them, it closes the listener when it returns.
+func (srv *REPLServer) Serve(l net.Listener) error {
+	if l == nil {
+		return errors.New("nil listener for REPL server")
+	}
+	if !srv.track(l, nil, true) {
+		l.Close()
+		return ErrREPLServerClosed
+	}
+	defer srv.track(l, nil, false)
+	defer l.Close()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if srv.isClosed() {
+				return ErrREPLServerClosed
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				continue
+			}
+			return err
+		}
+		if !srv.track(nil, conn, true) {
+			conn.Close()
+			return ErrREPLServerClosed
+		}
+		srv.wg.Add(1)
+		go func() {
+			defer srv.wg.Done()
+			defer srv.track(nil, conn, false)
+			defer conn.Close()
+			srv.serveConn(conn)
+		}()
+	}
+}
+
+// Close stops the listeners, closes the connections, cancels the running inputs, and waits for the sessions to end.
+func (srv *REPLServer) Close() error {
+	srv.mu.Lock()
+	if srv.closed {
+		srv.mu.Unlock()
+		return nil
+	}
+	srv.closed = true
+	srv.cancel()
+	var err error
+	for l := range srv.listeners {
+		if e := l.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	for c := range srv.conns {
+		c.Close()
+	}
+	srv.mu.Unlock()
+
+	srv.wg.Wait()
+	return err
+}
+
+// isClosed returns whether the server is closed.
+func (srv *REPLServer) isClosed() bool {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	return srv.closed
+}
+
+// track adds or removes the listener or connection, and returns false if the server is closed when adding.
+func (srv *REPLServer) track(l net.Listener, c net.Conn, add bool) bool {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	if add && srv.closed {
+		return false
+	}
+	if l != nil {
+		if add {
+			srv.listeners[l] = struct{}{}
+		} else {
+			delete(srv.listeners, l)
+		}
+	}
+	if c != nil {
+		if add {
+			srv.conns[c] = struct{}{}
+		} else {
+			delete(srv.conns, c)
+		}
+	}
+	return true
+}
+
+// serveConn authenticates the client and runs a REPL session on the connection until it ends or times out.
+func (srv *REPLServer) serveConn(conn net.Conn) {
+	rd := bufio.NewReader(conn)
+	if !srv.authenticate(conn, rd) {
+		return
+	}
+
+	box := srv.box
+	if srv.clone {
+		box = box.Clone()
+	}
+	ctx, cancel := srv.ctx, context.CancelFunc(func() {})
+	if srv.timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, srv.timeout)
+	}
+	defer cancel()
+
+	// close the connection when the session times out to end the blocking read
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				_ = conn.SetWriteDeadline(time.Now().Add(time.Second))
+				fmt.Fprintln(conn, "\nsession timed out")
+			}
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	fmt.Fprintf(conn, "connected to box %q\n", box.name)
+	opts := append([]REPLOption{REPLContext(ctx)}, srv.replOpts...)
+	if err := box.REPLWith(rd, conn, opts...); err != nil && ctx.Err() == nil {
+		fmt.Fprintln(conn, err)
+	}
+}
+
+// authenticate reads the token from the client in limited time, and returns true if it matches or no token is required.
+func (srv *REPLServer) authenticate(conn net.Conn, rd *bufio.Reader) bool {
+	if srv.token == "" {
+		return true
+	}
+	_ = conn.SetDeadline(time.Now().Add(replAuthTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	fmt.Fprint(conn, "token: ")
+	line, err := rd.ReadString('\n')
+	if err != nil {
+		return false
+	}
+	if subtle.ConstantTimeCompare([]byte(strings.TrimSpace(line)), []byte(srv.token)) != 1 {
+		fmt.Fprintln(conn, "authentication failed")
+		return false
+	}
+	return true
+}
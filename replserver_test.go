@@ -0,0 +1,196 @@
+package starbox_test
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/PureMature/starbox"
+	"go.starlark.net/starlark"
+)
+
+// startREPLServer starts the REPL server on a random local TCP port, and returns the address.
+func startREPLServer(t *testing.T, srv *starbox.REPLServer) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- srv.Serve(l)
+	}()
+	t.Cleanup(func() {
+		if err := srv.Close(); err != nil {
+			t.Errorf("close server: %v", err)
+		}
+		if err := <-errCh; !errors.Is(err, starbox.ErrREPLServerClosed) {
+			t.Errorf("unexpected error of serve: %v", err)
+		}
+	})
+	return l.Addr().String()
+}
+
+// dialREPL connects to the REPL server, sends the input, and returns everything received until the connection is closed.
+func dialREPL(t *testing.T, network, addr, input string) string {
+	t.Helper()
+	conn, err := net.Dial(network, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.WriteString(conn, input); err != nil {
+		t.Fatal(err)
+	}
+	if tc, ok := conn.(interface{ CloseWrite() error }); ok {
+		_ = tc.CloseWrite()
+	}
+	out, _ := io.ReadAll(bufio.NewReader(conn))
+	return string(out)
+}
+
+// TestREPLServer tests the following:
+// 1. Create a box with globals and collective memory, run a script, and serve REPL sessions on it with a token.
+// 2. Connect with the correct token, and check the globals of previous executions and the memory can be inspected and changed.
+// 3. Connect with a wrong token, and check the authentication fails without running the input.
+func TestREPLServer(t *testing.T) {
+	b := starbox.New("live")
+	mem := b.CreateMemory("memory")
+	if _, err := b.Run(`counter = 5` + "\n" + `memory["hits"] = 3`); err != nil {
+		t.Fatal(err)
+	}
+	srv := starbox.NewREPLServer(b, starbox.REPLServerToken("secret"), starbox.REPLServerSessionOptions(starbox.REPLPrompt("", "")))
+	addr := startREPLServer(t, srv)
+
+	got := dialREPL(t, "tcp", addr, "secret\ncounter * 2\nmemory[\"hits\"]\nmemory[\"seen\"] = True\n")
+	if want := "token: connected to box \"live\"\n10\n3\n"; got != want {
+		t.Errorf("unexpected output: %q, want %q", got, want)
+	}
+	if v, _, _ := mem.Get(starlark.String("seen")); v != starlark.True {
+		t.Errorf("expect memory changed by the session, got %v", v)
+	}
+
+	got = dialREPL(t, "tcp", addr, "wrong\nmemory[\"evil\"] = True\n")
+	if want := "token: authentication failed\n"; got != want {
+		t.Errorf("unexpected output: %q, want %q", got, want)
+	}
+	if v, _, _ := mem.Get(starlark.String("evil")); v != nil && v != starlark.None {
+		t.Errorf("expect memory not changed by unauthenticated client, got %v", v)
+	}
+}
+
+// TestREPLServer_Clone tests the following:
+// 1. Serve REPL sessions on clones of a box over a unix socket without a token.
+// 2. Check the session shares the memory with the box, but not the globals of its executions.
+// 3. Check the box can run while a session is connected.
+func TestREPLServer_Clone(t *testing.T) {
+	dir, err := os.MkdirTemp("", "sbrepl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sock := filepath.Join(dir, "repl.sock")
+
+	b := starbox.New("live")
+	mem := b.CreateMemory("memory")
+	if _, err := b.Run(`counter = 5` + "\n" + `memory["hits"] = 3`); err != nil {
+		t.Fatal(err)
+	}
+	srv := starbox.NewREPLServer(b, starbox.REPLServerClone(), starbox.REPLServerSessionOptions(starbox.REPLPrompt("", "")))
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- srv.ListenAndServe("unix", sock)
+	}()
+	for i := 0; i < 100; i++ {
+		if _, err := os.Stat(sock); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if fi, err := os.Stat(sock); err != nil {
+		t.Fatal(err)
+	} else if perm := fi.Mode().Perm(); perm != 0600 {
+		t.Errorf("unexpected permission of socket: %v", perm)
+	}
+
+	conn, err := net.Dial("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	rd := bufio.NewReader(conn)
+	if line, _ := rd.ReadString('\n'); line != "connected to box \"live\"\n" {
+		t.Errorf("unexpected greeting: %q", line)
+	}
+	if out, err := b.Run(`next = counter + 1`); err != nil || out["next"] != int64(6) {
+		t.Errorf("expect box runs during the session, got %v, %v", out, err)
+	}
+	if _, err := io.WriteString(conn, "memory[\"hits\"] + 1\ncounter\n"); err != nil {
+		t.Fatal(err)
+	}
+	conn.(*net.UnixConn).CloseWrite()
+	out, _ := io.ReadAll(rd)
+	conn.Close()
+	if got := string(out); !strings.HasPrefix(got, "4\n") || !strings.Contains(got, "undefined: counter") {
+		t.Errorf("unexpected output: %q", got)
+	}
+	if v, _, _ := mem.Get(starlark.String("hits")); v != starlark.MakeInt(3) {
+		t.Errorf("unexpected memory: %v", v)
+	}
+
+	if err := srv.Close(); err != nil {
+		t.Error(err)
+	}
+	if err := <-errCh; !errors.Is(err, starbox.ErrREPLServerClosed) {
+		t.Errorf("unexpected error of serve: %v", err)
+	}
+	if _, err := os.Stat(sock); !os.IsNotExist(err) {
+		t.Errorf("expect socket removed after close, got %v", err)
+	}
+}
+
+// TestREPLServer_Timeout tests the session is closed and the running input is cancelled when the session times out.
+func TestREPLServer_Timeout(t *testing.T) {
+	b := starbox.New("live")
+	srv := starbox.NewREPLServer(b, starbox.REPLServerTimeout(200*time.Millisecond), starbox.REPLServerClone(), starbox.REPLServerSessionOptions(starbox.REPLPrompt("", "")))
+	addr := startREPLServer(t, srv)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	start := time.Now()
+	if _, err := io.WriteString(conn, "while True:\n  pass\n\n"); err != nil {
+		t.Fatal(err)
+	}
+	out, _ := io.ReadAll(conn)
+	if !strings.Contains(string(out), "session timed out") {
+		t.Errorf("unexpected output: %q", out)
+	}
+	if d := time.Since(start); d > 3*time.Second {
+		t.Errorf("expect session closed soon after timeout, took %v", d)
+	}
+}
+
+// TestREPLServer_ListenAndServe tests errors of unsupported networks and TCP without a token.
+func TestREPLServer_ListenAndServe(t *testing.T) {
+	srv := starbox.NewREPLServer(starbox.New("test"))
+	if err := srv.ListenAndServe("tcp", "127.0.0.1:0"); err == nil {
+		t.Error("expect error for TCP without token, got nil")
+	}
+	if err := srv.ListenAndServe("udp", "127.0.0.1:0"); err == nil {
+		t.Error("expect error for UDP, got nil")
+	}
+	if err := srv.Close(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,216 @@
+package starbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	libhttp "github.com/1set/starlet/lib/http"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+// routeBuiltinName is the name of the builtin for scripts to register routes.
+const routeBuiltinName = "route"
+
+// scriptRoute is a route registered by the script.
+type scriptRoute struct {
+	method   string   // upper-cased method, empty for any method
+	pattern  string   // original path pattern
+	segments []string // segments of the path pattern
+	handler  starlark.Callable
+}
+
+// parseRoutePattern splits the path pattern into segments, and validates the parameters.
+func parseRoutePattern(pattern string) ([]string, error) {
+	if !strings.HasPrefix(pattern, "/") {
+		return nil, fmt.Errorf("pattern must start with /: %q", pattern)
+	}
+	segs := strings.Split(strings.Trim(pattern, "/"), "/")
+	if len(segs) == 1 && segs[0] == "" {
+		segs = nil
+	}
+	seen := make(map[string]bool)
+	for i, seg := range segs {
+		if !strings.HasPrefix(seg, "{") {
+			continue
+		}
+		if !strings.HasSuffix(seg, "}") {
+			return nil, fmt.Errorf("invalid parameter %q in pattern %q", seg, pattern)
+		}
+		name := strings.TrimSuffix(seg[1:len(seg)-1], "...")
+		if name == "" {
+			return nil, fmt.Errorf("empty parameter name in pattern %q", pattern)
+		}
+		if strings.HasSuffix(seg, "...}") && i != len(segs)-1 {
+			return nil, fmt.Errorf("wildcard parameter %q must be the last in pattern %q", seg, pattern)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("duplicate parameter %q in pattern %q", name, pattern)
+		}
+		seen[name] = true
+	}
+	return segs, nil
+}
+
+// match matches the path against the route, and returns the path parameters if matched.
+func (rt *scriptRoute) match(path string) (map[string]string, bool) {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) == 1 && parts[0] == "" {
+		parts = nil
+	}
+	params := make(map[string]string)
+	for i, seg := range rt.segments {
+		isParam := strings.HasPrefix(seg, "{")
+		if isParam && strings.HasSuffix(seg, "...}") {
+			params[seg[1:len(seg)-4]] = strings.Join(parts[i:], "/")
+			return params, true
+		}
+		if i >= len(parts) {
+			return nil, false
+		}
+		if isParam {
+			if parts[i] == "" {
+				return nil, false
+			}
+			params[seg[1:len(seg)-1]] = parts[i]
+		} else if seg != parts[i] {
+			return nil, false
+		}
+	}
+	return params, len(parts) == len(rt.segments)
+}
+
+// routeTable collects the routes registered by the script of a request.
+type routeTable struct {
+	routes []*scriptRoute
+}
+
+// builtin returns the builtin route(method, pattern, handler) for the script to register routes, method "" or "*" matches any method.
+func (t *routeTable) builtin() *starlark.Builtin {
+	return starlark.NewBuiltin(routeBuiltinName, func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var (
+			method, pattern string
+			handler         starlark.Callable
+		)
+		if err := starlark.UnpackArgs(b.Name(), args, kwargs, "method", &method, "pattern", &pattern, "handler", &handler); err != nil {
+			return nil, err
+		}
+		segs, err := parseRoutePattern(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", b.Name(), err)
+		}
+		if method = strings.ToUpper(strings.TrimSpace(method)); method == "*" {
+			method = ""
+		}
+		t.routes = append(t.routes, &scriptRoute{method: method, pattern: pattern, segments: segs, handler: handler})
+		return starlark.None, nil
+	})
+}
+
+// find returns the first route matching the request and its path parameters, and the allowed methods if only the path matches.
+func (t *routeTable) find(r *http.Request) (*scriptRoute, map[string]string, []string) {
+	var allowed []string
+	for _, rt := range t.routes {
+		params, ok := rt.match(r.URL.Path)
+		if !ok {
+			continue
+		}
+		if rt.method == "" || rt.method == r.Method {
+			return rt, params, nil
+		}
+		allowed = append(allowed, rt.method)
+	}
+	sort.Strings(allowed)
+	return nil, nil, allowed
+}
+
+// ScriptRouter is an http.Handler dispatching requests to the Starlark functions registered as routes by the script.
+type ScriptRouter struct {
+	script string
+	handlerConfig
+}
+
+var _ http.Handler = (*ScriptRouter)(nil)
+
+// Router creates an http.Handler which runs the script in a new Starbox instance created by the factory for each request, and calls the Starlark function of the route matching the request.
+// The script registers routes with the builtin route(method, pattern, handler), the pattern is a path like "/users/{id}" or "/files/{path...}", and the first matched route wins.
+// The handler is called as handler(request, response), where request is the global `request` with extra fields `path` and `params` for the path parameters, and response is the global `response` from AddHTTPContext().
+// It responds with 404 if no route matches the path, and 405 if no route matches the method.
+func Router(script string, factory BoxFactory, opts ...HandlerOption) *ScriptRouter {
+	return &ScriptRouter{
+		script:        script,
+		handlerConfig: newHandlerConfig(factory, opts),
+	}
+}
+
+// ServeHTTP runs the script to register routes, and calls the handler of the matched route.
+func (h *ScriptRouter) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	w := &trackingWriter{ResponseWriter: rw}
+	defer h.recoverPanic(w, r)
+
+	box, err := h.newBox(r)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+	table := &routeTable{}
+	box.AddKeyStarlarkValue(routeBuiltinName, table.builtin())
+	resp := box.AddHTTPContext(r)
+
+	ctx, cancel := h.context(r)
+	defer cancel()
+	if _, err := box.RunContext(ctx, h.script); err != nil {
+		h.writeError(w, r, runError(ctx, err))
+		return
+	}
+
+	// find the route
+	rt, params, allowed := table.find(r)
+	if rt == nil {
+		if len(allowed) > 0 {
+			w.Header().Set("Allow", strings.Join(allowed, ", "))
+			h.writeError(w, r, &HTTPError{StatusCode: http.StatusMethodNotAllowed})
+		} else {
+			h.writeError(w, r, &HTTPError{StatusCode: http.StatusNotFound})
+		}
+		return
+	}
+
+	// call the handler
+	if err := box.callRouteHandler(ctx, rt, r, resp, params); err != nil {
+		h.writeError(w, r, runError(ctx, err))
+		return
+	}
+	if err := writeResponse(w, resp); err != nil {
+		log.Warnw("failed to write http response", "method", r.Method, "url", r.URL.String(), "error", err)
+	}
+}
+
+// callRouteHandler calls the handler of the route with the request and response after the script has been executed.
+func (s *Starbox) callRouteHandler(ctx context.Context, rt *scriptRoute, r *http.Request, resp *libhttp.ServerResponse, params map[string]string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sd := make(starlark.StringDict)
+	if req := libhttp.ConvertServerRequest(r); req != nil {
+		req.ToStringDict(sd)
+	}
+	pd := starlark.NewDict(len(params))
+	for k, v := range params {
+		_ = pd.SetKey(starlark.String(k), starlark.String(v))
+	}
+	sd["path"] = starlark.String(r.URL.Path)
+	sd["params"] = pd
+	req := starlarkstruct.FromStringDict(starlark.String("Request"), sd)
+
+	thread := s.mac.GetStarlarkThread()
+	if thread == nil {
+		return errors.New("no thread for calling route handler")
+	}
+	_, err := callWithContext(ctx, thread, rt.handler, starlark.Tuple{req, resp.Struct()}, nil)
+	return err
+}
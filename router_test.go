@@ -0,0 +1,90 @@
+package starbox_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/PureMature/starbox"
+)
+
+// TestRouter tests the following:
+// 1. Create a router with a script registering routes with path parameters.
+// 2. Serve requests for various methods and paths, and check the status and body.
+// 3. Check the path parameters and query values are exposed to the handlers.
+// 4. Check unmatched paths and methods, handler errors and timeouts.
+func TestRouter(t *testing.T) {
+	script := HereDoc(`
+		def list_users(req, resp):
+			resp.set_json({"users": ["alice", "bob"], "limit": req.query.get("limit", ["10"])[0]})
+
+		def get_user(req, resp):
+			resp.set_text("user " + req.params["id"] + " via " + req.method)
+
+		def get_file(req, resp):
+			resp.set_text("file " + req.params["path"] + " at " + req.path)
+
+		def boom(req, resp):
+			fail("boom")
+
+		def spin(req, resp):
+			while True:
+				pass
+
+		route("GET", "/users", list_users)
+		route("GET", "/users/{id}", get_user)
+		route("delete", "/users/{id}", get_user)
+		route("*", "/files/{path...}", get_file)
+		route("", "/boom", boom)
+		route("GET", "/spin", spin)
+	`)
+	h := starbox.Router(script, nil, starbox.HandlerTimeout(100*time.Millisecond))
+
+	tests := []struct {
+		method, target string
+		status         int
+		body           string
+	}{
+		{"GET", "/users?limit=5", http.StatusOK, `{"limit":"5","users":["alice","bob"]}`},
+		{"GET", "/users/", http.StatusOK, `{"limit":"10","users":["alice","bob"]}`},
+		{"GET", "/users/42", http.StatusOK, "user 42 via GET"},
+		{"DELETE", "/users/7", http.StatusOK, "user 7 via DELETE"},
+		{"PUT", "/users/7", http.StatusMethodNotAllowed, "Method Not Allowed\n"},
+		{"GET", "/users/7/extra", http.StatusNotFound, "Not Found\n"},
+		{"POST", "/files/a/b/c.txt", http.StatusOK, "file a/b/c.txt at /files/a/b/c.txt"},
+		{"GET", "/files", http.StatusOK, "file  at /files"},
+		{"GET", "/", http.StatusNotFound, "Not Found\n"},
+		{"GET", "/boom", http.StatusInternalServerError, "Internal Server Error\n"},
+		{"GET", "/spin", http.StatusServiceUnavailable, "Service Unavailable\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+tt.target, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(tt.method, "http://localhost"+tt.target, nil))
+			if rec.Code != tt.status {
+				t.Errorf("expect status %d, got %d", tt.status, rec.Code)
+			}
+			if body := rec.Body.String(); body != tt.body {
+				t.Errorf("expect body %q, got %q", tt.body, body)
+			}
+			if tt.status == http.StatusMethodNotAllowed {
+				if allow := rec.Header().Get("Allow"); allow != "DELETE, GET" {
+					t.Errorf("unexpected allow header: %q", allow)
+				}
+			}
+		})
+	}
+}
+
+// TestRouter_InvalidRoute tests invalid route patterns fail the script.
+func TestRouter_InvalidRoute(t *testing.T) {
+	for _, pattern := range []string{"users", "/a/{}", "/a/{id", "/a/{p...}/b", "/a/{id}/{id}"} {
+		h := starbox.Router(`route("GET", "`+pattern+`", lambda req, resp: None)`, nil)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://localhost/a/1", nil))
+		if rec.Code != http.StatusInternalServerError {
+			t.Errorf("pattern %q: expect status 500, got %d", pattern, rec.Code)
+		}
+	}
+}
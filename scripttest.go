@@ -0,0 +1,345 @@
+package starbox
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	lassert "github.com/PureMature/starbox/module/assert"
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+const (
+	// scriptTestPrefix is the name prefix of test functions in test scripts.
+	scriptTestPrefix = "test_"
+	// scriptTestFileSuffix is the file name suffix of test scripts found in directories.
+	scriptTestFileSuffix = "_test.star"
+	// scriptTestTopLevel is the name of the result for failures of running the test script itself.
+	scriptTestTopLevel = "<toplevel>"
+)
+
+// ScriptTestFile is a test script, which defines test functions named with the "test_" prefix.
+type ScriptTestFile struct {
+	Name   string // Name is the name of the script in reports, e.g. the file path.
+	Script string // Script is the content of the script.
+}
+
+// LoadScriptTestFiles reads the test scripts at the paths, directories are walked for files named with the "_test.star" suffix.
+func LoadScriptTestFiles(paths ...string) ([]ScriptTestFile, error) {
+	var files []ScriptTestFile
+	add := func(path string) error {
+		script, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		files = append(files, ScriptTestFile{Name: path, Script: string(script)})
+		return nil
+	}
+	for _, path := range paths {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !fi.IsDir() {
+			if err := add(path); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		err = filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.IsDir() && strings.HasSuffix(d.Name(), scriptTestFileSuffix) {
+				return add(p)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// ScriptTestOption configures the test runner of RunScriptTests().
+type ScriptTestOption func(cfg *scriptTestConfig)
+
+// scriptTestConfig is the configuration of the test runner.
+type scriptTestConfig struct {
+	filter *regexp.Regexp
+}
+
+// ScriptTestFilter only runs the test functions whose names match the regular expression, like the -run flag of go test.
+func ScriptTestFilter(re *regexp.Regexp) ScriptTestOption {
+	return func(cfg *scriptTestConfig) {
+		cfg.filter = re
+	}
+}
+
+// ScriptTestResult is the result of a test function, or of running the test script itself if the name is "<toplevel>".
+type ScriptTestResult struct {
+	File     string        // File is the name of the test script.
+	Name     string        // Name is the name of the test function.
+	Err      error         // Err is the failure of the test, nil if it passed.
+	Message  string        // Message is the failure message with the position in the test script, empty if it passed.
+	Output   string        // Output is the messages printed by the test.
+	Duration time.Duration // Duration is the time taken by the test.
+}
+
+// Passed returns true if the test passed.
+func (r *ScriptTestResult) Passed() bool {
+	return r.Err == nil
+}
+
+// ScriptTestReport is the results of test scripts run by RunScriptTests().
+type ScriptTestReport struct {
+	Results  []*ScriptTestResult // Results is the results of all tests in the order of files and definitions.
+	Duration time.Duration       // Duration is the time taken by all tests.
+}
+
+// Passed returns true if all tests passed.
+func (r *ScriptTestReport) Passed() bool {
+	return r.Failed() == 0
+}
+
+// Failed returns the number of failed tests.
+func (r *ScriptTestReport) Failed() int {
+	n := 0
+	for _, res := range r.Results {
+		if !res.Passed() {
+			n++
+		}
+	}
+	return n
+}
+
+// RunScriptTests discovers the test functions defined at the top level of the test scripts, and runs each of them in a fresh clone of the template box created by Clone().
+// The "assert" module is added to the clones, which provides eq, ne, true, fails and contains for assertions, and the printed messages are captured as the output of each test.
+// A test fails if the script or the function fails, and a failed script is reported as a test named "<toplevel>".
+// The mock of the template box set by SetMock() is reset before each test, so the recorded calls are of the current test, and the coverage set by SetCoverage() names the test scripts by their names.
+// The template box is only cloned and never executed, and an empty box is used if it's nil.
+func RunScriptTests(template *Starbox, files []ScriptTestFile, opts ...ScriptTestOption) *ScriptTestReport {
+	cfg := scriptTestConfig{}
+	for _, opt := range opts {
+		if opt != nil {
+			opt(&cfg)
+		}
+	}
+	if template == nil {
+		template = New("test")
+	}
+
+	start := time.Now()
+	report := &ScriptTestReport{}
+	for _, file := range files {
+		names, err := scriptTestNames(file)
+		if err != nil {
+			report.Results = append(report.Results, newScriptTestResult(file.Name, scriptTestTopLevel, err, "", 0))
+			continue
+		}
+		for _, name := range names {
+			if cfg.filter != nil && !cfg.filter.MatchString(name) {
+				continue
+			}
+			report.Results = append(report.Results, runScriptTest(template, file, name))
+		}
+	}
+	report.Duration = time.Since(start)
+	return report
+}
+
+// scriptTestNames parses the test script, and returns the names of test functions in the order of definitions.
+func scriptTestNames(file ScriptTestFile) ([]string, error) {
+	f, err := scriptFileOptions().Parse(file.Name, file.Script, 0)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, stmt := range f.Stmts {
+		if def, ok := stmt.(*syntax.DefStmt); ok && strings.HasPrefix(def.Name.Name, scriptTestPrefix) {
+			names = append(names, def.Name.Name)
+		}
+	}
+	return names, nil
+}
+
+// runScriptTest runs the test script in a clone of the template, and calls the test function if the script succeeds.
+func runScriptTest(template *Starbox, file ScriptTestFile, name string) *ScriptTestResult {
+	var output strings.Builder
+	box := template.Clone()
+	box.AddNamedModules(lassert.ModuleName)
+	if box.mock != nil {
+		box.mock.Reset()
+	}
+	box.coverName = file.Name
+	box.SetPrintFunc(func(_ *starlark.Thread, msg string) {
+		output.WriteString(msg)
+		output.WriteString("\n")
+	})
+
+	start := time.Now()
+	if _, err := box.Run(file.Script); err != nil {
+		return newScriptTestResult(file.Name, name, err, output.String(), time.Since(start))
+	}
+
+	box.mu.Lock()
+	_, err := box.mac.Call(name)
+	box.mu.Unlock()
+	return newScriptTestResult(file.Name, name, err, output.String(), time.Since(start))
+}
+
+// newScriptTestResult creates the result of the test, and formats the failure message with the innermost position in the test script.
+func newScriptTestResult(file, name string, err error, output string, dur time.Duration) *ScriptTestResult {
+	res := &ScriptTestResult{File: file, Name: name, Err: err, Output: output, Duration: dur}
+	if err == nil {
+		return res
+	}
+
+	res.Message = err.Error()
+	var evalErr *starlark.EvalError
+	if errors.As(err, &evalErr) {
+		res.Message = evalErr.Msg
+		for i := len(evalErr.CallStack) - 1; i >= 0; i-- {
+			if pos := evalErr.CallStack[i].Pos; pos.Filename() == mainScriptName {
+				res.Message = fmt.Sprintf("%s:%d: %s", file, pos.Line, evalErr.Msg)
+				break
+			}
+		}
+	}
+	return res
+}
+
+// WriteGoTest writes the report in the format of verbose go test output, which can be processed by tools for go test.
+func (r *ScriptTestReport) WriteGoTest(w io.Writer) error {
+	var sb strings.Builder
+	files, byFile := r.groupByFile()
+	for _, file := range files {
+		var (
+			failed bool
+			dur    time.Duration
+		)
+		for _, res := range byFile[file] {
+			name := file + "/" + res.Name
+			fmt.Fprintf(&sb, "=== RUN   %s\n", name)
+			status := "PASS"
+			if !res.Passed() {
+				status, failed = "FAIL", true
+			}
+			fmt.Fprintf(&sb, "--- %s: %s (%.2fs)\n", status, name, res.Duration.Seconds())
+			for _, line := range strings.Split(strings.TrimSuffix(res.Output, "\n"), "\n") {
+				if line != "" {
+					fmt.Fprintf(&sb, "    %s\n", line)
+				}
+			}
+			if !res.Passed() {
+				for _, line := range strings.Split(res.Message, "\n") {
+					fmt.Fprintf(&sb, "    %s\n", line)
+				}
+			}
+			dur += res.Duration
+		}
+		if failed {
+			fmt.Fprintf(&sb, "FAIL\t%s\t%.3fs\n", file, dur.Seconds())
+		} else {
+			fmt.Fprintf(&sb, "ok  \t%s\t%.3fs\n", file, dur.Seconds())
+		}
+	}
+	if r.Passed() {
+		sb.WriteString("PASS\n")
+	} else {
+		sb.WriteString("FAIL\n")
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// junitTestSuites is the root element of JUnit XML reports.
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Time     string           `xml:"time,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+// junitTestSuite is a test script in JUnit XML reports.
+type junitTestSuite struct {
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Time     string          `xml:"time,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+// junitTestCase is a test function in JUnit XML reports.
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+// junitFailure is the failure of a test function in JUnit XML reports.
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// WriteJUnit writes the report in the JUnit XML format, each test script is a test suite, and each test function is a test case.
+func (r *ScriptTestReport) WriteJUnit(w io.Writer) error {
+	seconds := func(d time.Duration) string {
+		return fmt.Sprintf("%.3f", d.Seconds())
+	}
+	root := junitTestSuites{Tests: len(r.Results), Failures: r.Failed(), Time: seconds(r.Duration)}
+	files, byFile := r.groupByFile()
+	for _, file := range files {
+		suite := junitTestSuite{Name: file}
+		var dur time.Duration
+		for _, res := range byFile[file] {
+			tc := junitTestCase{Name: res.Name, ClassName: file, Time: seconds(res.Duration), SystemOut: res.Output}
+			if !res.Passed() {
+				tc.Failure = &junitFailure{Message: res.Message, Text: res.Message}
+				suite.Failures++
+			}
+			suite.Cases = append(suite.Cases, tc)
+			dur += res.Duration
+		}
+		suite.Tests = len(suite.Cases)
+		suite.Time = seconds(dur)
+		root.Suites = append(root.Suites, suite)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(root); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// groupByFile returns the names of test scripts in the order of results, and the results of each of them.
+func (r *ScriptTestReport) groupByFile() ([]string, map[string][]*ScriptTestResult) {
+	var files []string
+	byFile := make(map[string][]*ScriptTestResult)
+	for _, res := range r.Results {
+		if _, ok := byFile[res.File]; !ok {
+			files = append(files, res.File)
+		}
+		byFile[res.File] = append(byFile[res.File], res)
+	}
+	return files, byFile
+}
@@ -0,0 +1,154 @@
+package starbox_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/PureMature/starbox"
+)
+
+// scriptTestFiles are the test scripts for the script test runner, the line numbers matter.
+var scriptTestFiles = []starbox.ScriptTestFile{
+	{Name: "math_test.star", Script: HereDoc(`
+		load("util", "twice")
+		def test_eq():
+			assert.eq(twice(2), 4)
+			assert.ne(twice(2), 5)
+			assert.true(base > 0)
+		def test_fails():
+			msg = assert.fails(lambda: 1 // 0, "division")
+			assert.contains(msg, "zero")
+			assert.contains([1, 2], 2)
+			assert.contains({"a": 1}, "a")
+		def test_wrong():
+			print("checking", base)
+			assert.eq(twice(base), 3, "twice")
+		def helper():
+			pass
+	`)},
+	{Name: "bad_test.star", Script: HereDoc(`
+		def test_fails_unexpectedly():
+			assert.fails(lambda: 1)
+		def test_not_contains():
+			assert.contains("abc", "d")
+		fail("broken")
+	`)},
+	{Name: "syntax_test.star", Script: "def test_x(:\n"},
+}
+
+// TestRunScriptTests tests the following:
+// 1. Run test scripts in clones of a template box with a global and a module script.
+// 2. Check the assert module, the discovery of test functions, and the results of passed and failed tests.
+// 3. Check the failures of test scripts and syntax errors, and the filter of test names.
+// 4. Check the reports in the go test and JUnit XML formats.
+func TestRunScriptTests(t *testing.T) {
+	tpl := starbox.New("tpl")
+	tpl.AddKeyValue("base", 1)
+	tpl.AddModuleScript("util", "def twice(n):\n\treturn n * 2\n")
+
+	report := starbox.RunScriptTests(tpl, scriptTestFiles)
+	var got []string
+	for _, res := range report.Results {
+		got = append(got, res.File+"/"+res.Name+" "+res.Message+" "+strings.TrimSpace(res.Output))
+	}
+	expected := []string{
+		"math_test.star/test_eq  ",
+		"math_test.star/test_fails  ",
+		"math_test.star/test_wrong math_test.star:13: assert.eq: twice: 2 != 3 checking 1",
+		"bad_test.star/test_fails_unexpectedly bad_test.star:5: fail: broken ",
+		"bad_test.star/test_not_contains bad_test.star:5: fail: broken ",
+		"syntax_test.star/<toplevel> syntax_test.star:1:13: got ':', want ')' ",
+	}
+	if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("unexpected results:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(expected, "\n"))
+	}
+	if report.Passed() || report.Failed() != 4 {
+		t.Errorf("unexpected failed count: %d", report.Failed())
+	}
+
+	// fix the script and filter the tests
+	files := []starbox.ScriptTestFile{{Name: "bad_test.star", Script: strings.Replace(scriptTestFiles[1].Script, `fail("broken")`, "", 1)}}
+	report = starbox.RunScriptTests(nil, files, starbox.ScriptTestFilter(regexp.MustCompile("fails")))
+	if len(report.Results) != 1 || report.Results[0].Message != "bad_test.star:2: assert.fails: lambda succeeded unexpectedly" {
+		t.Errorf("unexpected filtered results: %v", report.Results[0])
+	}
+
+	// reports
+	report = starbox.RunScriptTests(tpl, scriptTestFiles[:1])
+	durations := regexp.MustCompile(`\d+\.\d+s`)
+	var buf bytes.Buffer
+	if err := report.WriteGoTest(&buf); err != nil {
+		t.Fatal(err)
+	}
+	expectedGo := HereDoc(`
+		=== RUN   math_test.star/test_eq
+		--- PASS: math_test.star/test_eq (0s)
+		=== RUN   math_test.star/test_fails
+		--- PASS: math_test.star/test_fails (0s)
+		=== RUN   math_test.star/test_wrong
+		--- FAIL: math_test.star/test_wrong (0s)
+		    checking 1
+		    math_test.star:13: assert.eq: twice: 2 != 3
+		FAIL	math_test.star	0s
+		FAIL
+	`)
+	if got := durations.ReplaceAllString(buf.String(), "0s"); got != expectedGo {
+		t.Errorf("unexpected go test report:\n%s\nwant:\n%s", got, expectedGo)
+	}
+
+	buf.Reset()
+	if err := report.WriteJUnit(&buf); err != nil {
+		t.Fatal(err)
+	}
+	xml := regexp.MustCompile(`time="[\d.]+"`).ReplaceAllString(buf.String(), `time="0"`)
+	for _, s := range []string{
+		`<?xml version="1.0" encoding="UTF-8"?>`,
+		`<testsuites tests="3" failures="1" time="0">`,
+		`<testsuite name="math_test.star" tests="3" failures="1" time="0">`,
+		`<testcase name="test_eq" classname="math_test.star" time="0"></testcase>`,
+		`<failure message="math_test.star:13: assert.eq: twice: 2 != 3">`,
+		`<system-out>checking 1&#xA;</system-out>`,
+	} {
+		if !strings.Contains(xml, s) {
+			t.Errorf("expect JUnit report to contain %s, got:\n%s", s, xml)
+		}
+	}
+}
+
+// TestLoadScriptTestFiles tests loading test scripts from files and directories, only files with the _test.star suffix are found in directories.
+func TestLoadScriptTestFiles(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"a_test.star":     "def test_a():\n\tpass\n",
+		"lib.star":        "x = 1\n",
+		"sub/b_test.star": "def test_b():\n\tpass\n",
+	} {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, err := starbox.LoadScriptTestFiles(dir, filepath.Join(dir, "lib.star"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range files {
+		rel, _ := filepath.Rel(dir, f.Name)
+		names = append(names, filepath.ToSlash(rel))
+	}
+	if got := strings.Join(names, ","); got != "a_test.star,sub/b_test.star,lib.star" {
+		t.Errorf("unexpected files: %s", got)
+	}
+	if _, err := starbox.LoadScriptTestFiles(filepath.Join(dir, "missing")); err == nil {
+		t.Error("expect error for missing path")
+	}
+}
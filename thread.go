@@ -0,0 +1,33 @@
+package starbox
+
+import (
+	"context"
+
+	"go.starlark.net/starlark"
+)
+
+// callWithContext calls the Starlark function in the thread, and cancels the thread when the context is done.
+func callWithContext(ctx context.Context, thread *starlark.Thread, fn starlark.Callable, args starlark.Tuple, kwargs []starlark.Tuple) (res starlark.Value, err error) {
+	err = withThreadContext(ctx, thread, func() error {
+		res, err = starlark.Call(thread, fn, args, kwargs)
+		return err
+	})
+	return res, err
+}
+
+// withThreadContext runs the function with the thread, and cancels the thread when the context is done before the function returns.
+func withThreadContext(ctx context.Context, thread *starlark.Thread, fn func() error) error {
+	thread.Uncancel()
+	thread.SetLocal("context", ctx)
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			thread.Cancel("context cancelled")
+		case <-done:
+		}
+	}()
+	return fn()
+}